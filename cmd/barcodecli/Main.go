@@ -0,0 +1,280 @@
+package main
+
+import (
+	"BarcodeServer/pkg/client"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Exit codes, so that scripts can react to failed lookups
+const (
+	exitOk          = 0
+	exitError       = 1
+	exitUsage       = 2
+	exitNotFound    = 3
+	exitRateLimited = 4
+	exitBadRequest  = 5
+	exitReadOnly    = 6
+)
+
+var (
+	server        = flag.String("server", envOrDefault("BARCODE_SERVER", "http://localhost:18900"), "URL of the federation server, can be set with BARCODE_SERVER")
+	uuid          = flag.String("uuid", os.Getenv("BARCODE_UUID"), "32 character identifier of this client, can be set with BARCODE_UUID. A random one is used if not set")
+	federationKey = flag.String("federation-key", os.Getenv("BARCODE_FEDERATION_KEY"), "FederationKey of the server, can be set with BARCODE_FEDERATION_KEY")
+	languages     = flag.String("lang", os.Getenv("BARCODE_LANG"), "Comma separated list of preferred languages of names, e.g. \"de,en\", can be set with BARCODE_LANG")
+	outputJson    = flag.Bool("json", false, "Print results as JSON")
+	timeout       = flag.Duration("timeout", time.Minute, "Maximum time for the command, including retries")
+)
+
+func main() {
+	flag.Usage = printUsage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	if *uuid == "" {
+		*uuid = client.NewUuid()
+	}
+	var options []client.Option
+	if *federationKey != "" {
+		options = append(options, client.WithFederationKey(*federationKey))
+	}
+	if *languages != "" {
+		options = append(options, client.WithLanguages(strings.Split(*languages, ",")...))
+	}
+	c, err := client.New(*server, *uuid, options...)
+	if err != nil {
+		exitWithError(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+	switch command {
+	case "lookup":
+		err = runLookup(ctx, c, args)
+	case "vote":
+		err = runName(ctx, args, c.Vote)
+	case "report":
+		err = runName(ctx, args, c.Report)
+	case "upload":
+		err = runUpload(ctx, c, args)
+	case "stats":
+		err = runStats(ctx, c)
+	default:
+		fmt.Fprintln(os.Stderr, "Unknown command: "+command)
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	if err != nil {
+		exitWithError(err)
+	}
+}
+
+func printUsage() {
+	output := flag.CommandLine.Output()
+	fmt.Fprintln(output, "Usage: barcodecli [options] lookup <barcode>...")
+	fmt.Fprintln(output, "       barcodecli [options] vote <barcode> <name>")
+	fmt.Fprintln(output, "       barcodecli [options] report <barcode> <name>")
+	fmt.Fprintln(output, "       barcodecli [options] upload [--format=csv|json] <file>")
+	fmt.Fprintln(output, "       barcodecli [options] stats")
+	fmt.Fprintln(output, "")
+	fmt.Fprintln(output, "Exit codes: 0 success, 1 error, 2 invalid usage, 3 barcode not found,")
+	fmt.Fprintln(output, "            4 rate limited, 5 rejected by the server, 6 server is read-only")
+	fmt.Fprintln(output, "")
+	flag.PrintDefaults()
+}
+
+// errUsage is returned if a command has been called with invalid arguments
+var errUsage = errors.New("invalid arguments")
+
+type lookupOutput struct {
+	Barcode  string           `json:"Barcode"`
+	Names    []string         `json:"Names"`
+	Metadata *client.Metadata `json:"Metadata,omitempty"`
+	Error    string           `json:"Error,omitempty"`
+}
+
+// runLookup prints the names of all barcodes. Returns ErrNotFound if at least
+// one barcode has not been found
+func runLookup(ctx context.Context, c *client.Client, barcodes []string) error {
+	if len(barcodes) == 0 {
+		return errUsage
+	}
+	var results []client.LookupResult
+	if len(barcodes) == 1 {
+		result, err := c.Lookup(ctx, barcodes[0])
+		if err != nil && !errors.Is(err, client.ErrNotFound) {
+			return err
+		}
+		result.Err = err
+		results = append(results, result)
+	} else {
+		var err error
+		results, err = c.LookupBatch(ctx, barcodes)
+		if err != nil {
+			return err
+		}
+	}
+	var resultErr error
+	output := make([]lookupOutput, len(results))
+	for i, result := range results {
+		output[i] = lookupOutput{Barcode: result.Barcode, Names: result.Names, Metadata: result.Metadata}
+		if output[i].Names == nil {
+			output[i].Names = []string{}
+		}
+		if result.Err != nil {
+			output[i].Error = errorMessage(result.Err)
+			if resultErr == nil || errors.Is(result.Err, client.ErrBadRequest) {
+				resultErr = result.Err
+			}
+		}
+	}
+	if *outputJson {
+		if len(barcodes) == 1 {
+			printJson(output[0])
+		} else {
+			printJson(output)
+		}
+	} else {
+		for _, result := range output {
+			if result.Error != "" {
+				fmt.Println(result.Barcode + "\t" + result.Error)
+			} else {
+				fmt.Println(result.Barcode + "\t" + strings.Join(result.Names, "\t"))
+			}
+		}
+	}
+	if resultErr != nil {
+		return silentError{resultErr}
+	}
+	return nil
+}
+
+type nameOutput struct {
+	Barcode string `json:"Barcode"`
+	Name    string `json:"Name"`
+	Counted bool   `json:"Counted"`
+}
+
+// runName votes for or reports a name
+func runName(ctx context.Context, args []string, send func(ctx context.Context, barcode, name string) (bool, error)) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	counted, err := send(ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if *outputJson {
+		printJson(nameOutput{Barcode: args[0], Name: args[1], Counted: counted})
+	} else if counted {
+		fmt.Println("Counted")
+	} else {
+		fmt.Println("Not counted, already sent from this IP address")
+	}
+	return nil
+}
+
+type statsOutput struct {
+	Server        string `json:"Server"`
+	LatencyMs     int64  `json:"LatencyMs"`
+	TotalBarcodes int    `json:"TotalBarcodes"`
+	// LatestCursor is the cursor of the latest change of the server
+	LatestCursor int64 `json:"LatestCursor"`
+}
+
+func runStats(ctx context.Context, c *client.Client) error {
+	start := time.Now()
+	err := c.Ping(ctx)
+	if err != nil {
+		return err
+	}
+	output := statsOutput{Server: *server, LatencyMs: time.Since(start).Milliseconds()}
+	output.TotalBarcodes, err = c.Amount(ctx)
+	if err != nil {
+		return err
+	}
+	changes, err := c.GetChanges(ctx, 0, 1)
+	if err != nil {
+		return err
+	}
+	output.LatestCursor = changes.LatestCursor
+	if *outputJson {
+		printJson(output)
+		return nil
+	}
+	fmt.Println("Server:         " + output.Server)
+	fmt.Printf("Latency:        %d ms\n", output.LatencyMs)
+	fmt.Printf("Total barcodes: %d\n", output.TotalBarcodes)
+	fmt.Printf("Latest change:  %d\n", output.LatestCursor)
+	return nil
+}
+
+// errorMessage returns the message of the server without the status code
+func errorMessage(err error) string {
+	var apiError *client.ApiError
+	if errors.As(err, &apiError) && apiError.Message != "" {
+		return apiError.Message
+	}
+	return err.Error()
+}
+
+func printJson(value interface{}) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(value)
+}
+
+// silentError is returned if the result has already been printed and only the
+// exit code has to be set
+type silentError struct {
+	error
+}
+
+func (e silentError) Unwrap() error {
+	return e.error
+}
+
+// exitWithError prints the error and exits with the matching exit code
+func exitWithError(err error) {
+	if errors.Is(err, errUsage) {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	var silent silentError
+	if !errors.As(err, &silent) {
+		if *outputJson {
+			encoder := json.NewEncoder(os.Stderr)
+			_ = encoder.Encode(map[string]string{"Error": err.Error()})
+		} else {
+			fmt.Fprintln(os.Stderr, "Error: "+err.Error())
+		}
+	}
+	switch {
+	case errors.Is(err, client.ErrNotFound):
+		os.Exit(exitNotFound)
+	case errors.Is(err, client.ErrRateLimited):
+		os.Exit(exitRateLimited)
+	case errors.Is(err, client.ErrBadRequest), errors.Is(err, client.ErrInvalidUuid):
+		os.Exit(exitBadRequest)
+	case errors.Is(err, client.ErrReadOnly):
+		os.Exit(exitReadOnly)
+	}
+	os.Exit(exitError)
+}
+
+func envOrDefault(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"BarcodeServer/pkg/client"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	formatCsv  = "csv"
+	formatJson = "json"
+)
+
+// uploadChunkSize is the amount of barcodes sent per request, so that the
+// request stays below the maximum body size of the server. Every request
+// counts towards the daily upload limit
+const uploadChunkSize = 5000
+
+type uploadOutput struct {
+	Barcodes int `json:"Barcodes"`
+	Accepted int `json:"Accepted"`
+	Rejected int `json:"Rejected"`
+	Requests int `json:"Requests"`
+}
+
+// runUpload sends all barcodes of a CSV or JSON file to the server
+func runUpload(ctx context.Context, c *client.Client, args []string) error {
+	uploadFlags := flag.NewFlagSet("upload", flag.ContinueOnError)
+	format := uploadFlags.String("format", "", "Format of the file (csv or json), detected automatically if not set")
+	err := uploadFlags.Parse(args)
+	if err != nil || uploadFlags.NArg() != 1 {
+		return errUsage
+	}
+	file, err := os.Open(uploadFlags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	barcodes, err := readBarcodes(file, *format, uploadFlags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(barcodes) == 0 {
+		return errors.New("the file does not contain any barcodes")
+	}
+	output := uploadOutput{Barcodes: len(barcodes)}
+	for start := 0; start < len(barcodes); start += uploadChunkSize {
+		end := start + uploadChunkSize
+		if end > len(barcodes) {
+			end = len(barcodes)
+		}
+		result, err := c.Add(ctx, barcodes[start:end])
+		if err != nil {
+			return err
+		}
+		output.Accepted = output.Accepted + result.Accepted
+		output.Rejected = output.Rejected + result.Rejected
+		output.Requests++
+	}
+	if *outputJson {
+		printJson(output)
+	} else {
+		fmt.Printf("Uploaded %d barcodes: %d accepted, %d rejected\n", output.Barcodes, output.Accepted, output.Rejected)
+	}
+	return nil
+}
+
+// readBarcodes parses a file with barcode/name pairs. The format is detected by the
+// file extension or the first character of the file, if it is not set
+func readBarcodes(file io.Reader, format, fileName string) ([]client.Barcode, error) {
+	reader := bufio.NewReader(file)
+	if format == "" {
+		format = detectFormat(reader, fileName)
+	}
+	switch format {
+	case formatCsv:
+		return readCsv(reader)
+	case formatJson:
+		return readJson(reader)
+	}
+	return nil, errors.New("unknown format " + format + ", expected csv or json")
+}
+
+func detectFormat(reader *bufio.Reader, fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".csv":
+		return formatCsv
+	case ".json":
+		return formatJson
+	}
+	start, _ := reader.Peek(512)
+	start = bytes.TrimSpace(start)
+	if len(start) > 0 && (start[0] == '{' || start[0] == '[') {
+		return formatJson
+	}
+	return formatCsv
+}
+
+// readCsv reads rows of barcode and name. A header row is skipped
+func readCsv(reader io.Reader) ([]client.Barcode, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	var result []client.Barcode
+	for line := 1; ; line++ {
+		row, err := csvReader.Read()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 1 && len(row) > 0 && strings.EqualFold(strings.TrimSpace(row[0]), "barcode") {
+			continue
+		}
+		if len(row) < 2 {
+			return nil, fmt.Errorf("line %d: expected barcode and name", line)
+		}
+		result = append(result, client.Barcode{Barcode: strings.TrimSpace(row[0]), Name: strings.TrimSpace(row[1])})
+	}
+}
+
+// readJson reads the GrocyBarcodes format {"ServerBarcodes": [...]} that is sent
+// by Barcode Buddy, or a plain array of barcodes
+func readJson(reader io.Reader) ([]client.Barcode, error) {
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	content = bytes.TrimSpace(content)
+	if len(content) > 0 && content[0] == '[' {
+		var result []client.Barcode
+		err = json.Unmarshal(content, &result)
+		return result, err
+	}
+	var grocyBarcodes struct {
+		Barcodes []client.Barcode `json:"ServerBarcodes"`
+	}
+	err = json.Unmarshal(content, &grocyBarcodes)
+	return grocyBarcodes.Barcodes, err
+}
@@ -2,25 +2,106 @@ package main
 
 import (
 	"BarcodeServer/internal/configuration"
+	"BarcodeServer/internal/federation"
+	"BarcodeServer/internal/import/backup"
 	"BarcodeServer/internal/import/edeka"
-	"BarcodeServer/internal/redis"
+	"BarcodeServer/internal/metrics"
+	"BarcodeServer/internal/mirror"
+	"BarcodeServer/internal/storage"
+	"BarcodeServer/internal/storage/bolt"
+	"BarcodeServer/internal/storage/memory"
+	"BarcodeServer/internal/storage/redis"
 	"BarcodeServer/internal/webserver"
+	"flag"
 	"fmt"
+	"log"
+	"os"
 )
 
 func main() {
+	storageBackend := flag.String("storage", "", "Overrides the storage backend of the configuration (redis, embedded or memory)")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: barcodeserver [options]")
+		fmt.Fprintln(flag.CommandLine.Output(), "       barcodeserver [options] import [--mode=merge|replace] [--format=csv|json|ndjson|off] <file>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
 
 	configuration.Load()
-	redis.Connect()
-	syncEdeka()
-	webserver.Start()
+	if *storageBackend != "" {
+		configuration.Get().StorageBackend = *storageBackend
+	}
+	if flag.Arg(0) == "import" {
+		runImport(flag.Args()[1:])
+		return
+	}
+	store := openStorage()
+	// Runs before anything writes to the store, as merged barcodes are written with precomputed scores
+	storage.Migrate(store)
+	if mirror.IsEnabled() {
+		// All barcodes are received from the upstream server, which imports and synchronises them
+		fmt.Println("Running as read-only mirror of " + configuration.Get().MirrorUpstream)
+		go mirror.Start(store)
+	} else {
+		syncEdeka(store)
+		go federation.StartPeriodicSync(store)
+	}
+	go metrics.Start(store)
+	webserver.Start(store)
+}
+
+// openStorage returns the storage backend that is selected in the configuration
+func openStorage() storage.Store {
+	config := configuration.Get()
+	switch config.StorageBackend {
+	case configuration.StorageRedis:
+		return redis.Connect(config.RedisUrl, config.RedisSize)
+	case configuration.StorageEmbedded:
+		fmt.Println("Using embedded database " + config.DatabasePath)
+		return bolt.Open(config.DatabasePath)
+	case configuration.StorageMemory:
+		fmt.Println("Using in-memory storage, all data will be lost after a restart")
+		return memory.New()
+	default:
+		log.Fatal("Unknown storage backend: " + config.StorageBackend)
+		return nil
+	}
+}
+
+// runImport imports a file that has been created by the export function
+func runImport(args []string) {
+	importFlags := flag.NewFlagSet("import", flag.ExitOnError)
+	mode := importFlags.String("mode", "merge", "merge adds new names to existing barcodes, replace overwrites existing barcodes")
+	format := importFlags.String("format", "", "Format of the file (csv, json, ndjson or off), detected automatically if not set")
+	_ = importFlags.Parse(args)
+	if importFlags.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	importMode, err := backup.ParseMode(*mode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if configuration.Get().StorageBackend == configuration.StorageMemory {
+		log.Fatal("Importing into the in-memory storage is not possible, as it is not persisted")
+	}
+	file, err := os.Open(importFlags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	summary, err := backup.Import(openStorage(), file, *format, importMode)
+	fmt.Print(summary.String())
+	if err != nil {
+		log.Fatal("Import aborted: " + err.Error())
+	}
 }
 
-func syncEdeka() {
+func syncEdeka(store storage.Store) {
 	apiKey := configuration.Get().ApiKeyEdeka
 	if apiKey == "" {
 		fmt.Println("No Edeka API Key provided.")
 		return
 	}
-	go edeka.StartPeriodicSync(apiKey)
+	go edeka.StartPeriodicSync(apiKey, store)
 }
@@ -2,6 +2,12 @@ module BarcodeServer
 
 go 1.20
 
-require github.com/mediocregopher/radix/v3 v3.7.0
+require (
+	github.com/mediocregopher/radix/v3 v3.7.0
+	go.etcd.io/bbolt v1.3.8
+)
 
-require golang.org/x/xerrors v0.0.0-20191011141410-1b5146add898 // indirect
+require (
+	golang.org/x/sys v0.4.0 // indirect
+	golang.org/x/xerrors v0.0.0-20191011141410-1b5146add898 // indirect
+)
@@ -0,0 +1,212 @@
+package barcode
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	// FormatEan8 is an 8 digit EAN code
+	FormatEan8 = "EAN-8"
+	// FormatEan13 is a 13 digit EAN code
+	FormatEan13 = "EAN-13"
+	// FormatUpcA is a 12 digit UPC code
+	FormatUpcA = "UPC-A"
+	// FormatUpcE is a zero-suppressed 8 digit UPC code, that is expanded to UPC-A
+	FormatUpcE = "UPC-E"
+	// FormatGtin14 is a 14 digit code, that is used for trade units containing multiple products
+	FormatGtin14 = "GTIN-14"
+)
+
+// Circulation describes where a barcode identifies the same product
+type Circulation int
+
+const (
+	// Global GTINs identify the same product everywhere
+	Global Circulation = iota
+	// Restricted codes are only valid within a company or a country, e.g.
+	// store-internal codes, coupons or short codes for loose products
+	Restricted
+	// VariableMeasure codes are restricted codes that embed the price or the
+	// weight of a product, e.g. for cheese or meat from the service counter
+	VariableMeasure
+)
+
+// canonicalLength is the length of the canonical form. Shorter codes are padded
+// with zeros, GTIN-14 codes are only shortened if they start with a zero
+const canonicalLength = 13
+
+var (
+	// ErrNotNumeric is returned if a barcode contains other characters than digits
+	ErrNotNumeric = errors.New("barcode is not numeric")
+	// ErrUnknownFormat is returned if the length of a barcode does not match any supported format
+	ErrUnknownFormat = errors.New("barcode is not an EAN-8, EAN-13, UPC-A, UPC-E or GTIN-14 code")
+	// ErrInvalidCheckDigit is returned if the last digit of a barcode is not its check digit
+	ErrInvalidCheckDigit = errors.New("invalid check digit")
+)
+
+// Gtin is a validated barcode
+type Gtin struct {
+	// Format is the format the barcode was scanned in
+	Format string
+	// Canonical is the barcode as GTIN-13, or as GTIN-14 if it has an indicator digit.
+	// For variable measure codes, the embedded price or weight is set to zero
+	Canonical string
+	// Circulation is Global, unless the code is a restricted circulation number
+	Circulation Circulation
+}
+
+// IsShared returns true if the code identifies the same product everywhere and
+// can therefore be shared with other users and servers
+func (g Gtin) IsShared() bool {
+	return g.Circulation == Global
+}
+
+// Parse validates the check digit of a barcode and returns its canonical form.
+// Codes with 8 digits are treated as EAN-8 if their check digit is valid,
+// otherwise as UPC-E if they start with 0 or 1 and the check digit of the
+// expanded UPC-A code is valid
+func Parse(code string) (Gtin, error) {
+	code = strings.TrimSpace(code)
+	if !isNumeric(code) {
+		return Gtin{}, ErrNotNumeric
+	}
+	var format, gtin string
+	switch len(code) {
+	case 8:
+		format, gtin = FormatEan8, code
+		if !IsValidCheckDigit(code) && (code[0] == '0' || code[0] == '1') {
+			expanded := expandUpcE(code)
+			if IsValidCheckDigit(expanded) {
+				format, gtin = FormatUpcE, expanded
+			}
+		}
+	case 12:
+		format, gtin = FormatUpcA, code
+	case 13:
+		format, gtin = FormatEan13, code
+	case 14:
+		format, gtin = FormatGtin14, code
+	default:
+		return Gtin{}, ErrUnknownFormat
+	}
+	if !IsValidCheckDigit(gtin) {
+		return Gtin{}, ErrInvalidCheckDigit
+	}
+	result := Gtin{Format: format, Canonical: canonicalize(gtin)}
+	result.Circulation = circulation(result.Canonical)
+	if result.Circulation == VariableMeasure {
+		result.Canonical = stripMeasure(result.Canonical)
+	}
+	return result, nil
+}
+
+// Normalize returns the canonical form of a barcode. Returns false if the
+// barcode is not valid or if it is not shared, as restricted codes identify
+// different products in different stores
+func Normalize(code string) (string, bool) {
+	gtin, err := Parse(code)
+	return gtin.Canonical, err == nil && gtin.IsShared()
+}
+
+// IsValidCheckDigit returns true if the last digit of a numeric code is the
+// GS1 check digit of all other digits
+func IsValidCheckDigit(code string) bool {
+	if len(code) < 2 || !isNumeric(code) {
+		return false
+	}
+	return CheckDigit(code[:len(code)-1]) == code[len(code)-1]
+}
+
+// CheckDigit returns the GS1 check digit for a numeric code without check digit.
+// Starting with the rightmost digit, digits are weighted alternately with 3 and 1
+func CheckDigit(code string) byte {
+	sum := 0
+	weight := 3
+	for i := len(code) - 1; i >= 0; i-- {
+		sum = sum + int(code[i]-'0')*weight
+		weight = 4 - weight
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// expandUpcE returns the UPC-A code of an 8 digit UPC-E code, consisting of
+// the number system, six digits and the check digit
+func expandUpcE(code string) string {
+	numberSystem, digits, checkDigit := code[:1], code[1:7], code[7:]
+	var body string
+	switch digits[5] {
+	case '0', '1', '2':
+		body = digits[0:2] + digits[5:6] + "0000" + digits[2:5]
+	case '3':
+		body = digits[0:3] + "00000" + digits[3:5]
+	case '4':
+		body = digits[0:4] + "00000" + digits[4:5]
+	default:
+		body = digits[0:5] + "0000" + digits[5:6]
+	}
+	return numberSystem + body + checkDigit
+}
+
+func canonicalize(gtin string) string {
+	if len(gtin) > canonicalLength {
+		// Only a GTIN-14 with the indicator digit 0 contains the same product as a GTIN-13
+		if gtin[0] != '0' {
+			return gtin
+		}
+		return gtin[1:]
+	}
+	return strings.Repeat("0", canonicalLength-len(gtin)) + gtin
+}
+
+// circulation returns where a canonical code is valid, based on the GS1 prefixes
+// that are reserved for restricted circulation numbers
+func circulation(canonical string) Circulation {
+	code := canonical
+	if len(code) > canonicalLength {
+		// The indicator digit of a GTIN-14 is not part of the prefix
+		code = code[1:]
+	}
+	switch {
+	case strings.HasPrefix(code, "00000") && (code[5] == '0' || code[5] == '2'):
+		// EAN-8 codes starting with 0 or 2
+		return Restricted
+	case strings.HasPrefix(code, "02"):
+		// UPC-A number system 2, random weight items
+		return VariableMeasure
+	case strings.HasPrefix(code, "04"):
+		// UPC-A number system 4, store-internal codes
+		return Restricted
+	case strings.HasPrefix(code, "20"):
+		// Store-internal codes without an embedded price or weight
+		return Restricted
+	case code[0] == '2':
+		// Prefixes 21 to 29 are used for products sold by weight or price
+		return VariableMeasure
+	case strings.HasPrefix(code, "98"), strings.HasPrefix(code, "99"):
+		// Refund receipts and coupons
+		return Restricted
+	}
+	return Global
+}
+
+// stripMeasure keeps the prefix and the item reference of a variable measure
+// code, which are the first seven digits after the indicator digit, and sets
+// the price or weight to zero
+func stripMeasure(canonical string) string {
+	itemLength := 7 + len(canonical) - canonicalLength
+	withoutCheckDigit := canonical[:itemLength] + strings.Repeat("0", len(canonical)-itemLength-1)
+	return withoutCheckDigit + string(CheckDigit(withoutCheckDigit))
+}
+
+func isNumeric(input string) bool {
+	if input == "" {
+		return false
+	}
+	for _, char := range input {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+	return true
+}
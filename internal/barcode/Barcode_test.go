@@ -0,0 +1,74 @@
+package barcode
+
+import "testing"
+
+func TestParseCirculation(t *testing.T) {
+	tests := []struct {
+		code        string
+		canonical   string
+		circulation Circulation
+	}{
+		{"4006040000013", "4006040000013", Global},
+		{"2000000000008", "2000000000008", Restricted},
+		{"9800000000007", "9800000000007", Restricted},
+		// The embedded price or weight is set to zero
+		{"2112345012346", "2112345000008", VariableMeasure},
+		{"2112345099996", "2112345000008", VariableMeasure},
+		{"212345678909", "0212345000007", VariableMeasure},
+	}
+	for _, test := range tests {
+		result, err := Parse(test.code)
+		if err != nil {
+			t.Errorf("%s: %v", test.code, err)
+			continue
+		}
+		if result.Canonical != test.canonical || result.Circulation != test.circulation {
+			t.Errorf("%s: expected %s with circulation %d, got %+v", test.code, test.canonical, test.circulation, result)
+		}
+		if _, ok := Normalize(test.code); ok != (test.circulation == Global) {
+			t.Errorf("%s: unexpected result of Normalize", test.code)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := map[string]error{
+		"":              ErrNotNumeric,
+		"40060400000a3": ErrNotNumeric,
+		"123":           ErrUnknownFormat,
+		"4006040000014": ErrInvalidCheckDigit,
+	}
+	for code, expected := range tests {
+		if _, err := Parse(code); err != expected {
+			t.Errorf("%q: expected %v, got %v", code, expected, err)
+		}
+	}
+}
+
+func TestParseEightDigits(t *testing.T) {
+	tests := []struct {
+		code      string
+		format    string
+		canonical string
+	}{
+		{"96385074", FormatEan8, "0000096385074"},
+		// Valid as EAN-8 and as UPC-E, EAN-8 is preferred
+		{"10000168", FormatEan8, "0000010000168"},
+		// Only the expanded UPC-A code 100100000020 has a valid check digit
+		{"10000210", FormatUpcE, "0100100000020"},
+		{"10000308", FormatUpcE, "0100000000038"},
+	}
+	for _, test := range tests {
+		result, err := Parse(test.code)
+		if err != nil {
+			t.Errorf("%s: %v", test.code, err)
+			continue
+		}
+		if result.Format != test.format || result.Canonical != test.canonical {
+			t.Errorf("%s: expected %s %s, got %+v", test.code, test.format, test.canonical, result)
+		}
+	}
+	if _, err := Parse("96385075"); err != ErrInvalidCheckDigit {
+		t.Errorf("expected an invalid check digit, got %v", err)
+	}
+}
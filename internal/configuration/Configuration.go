@@ -4,6 +4,7 @@ import (
 	"BarcodeServer/internal/helper"
 	models "BarcodeServer/internal/webserver/sessions/model"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -16,7 +17,16 @@ const configFile = configFilePath + "config.json"
 var config Configuration
 var sessionMutex sync.Mutex
 
-const currentConfigVersion = 3
+const currentConfigVersion = 9
+
+// StorageRedis stores all data in a Redis server
+const StorageRedis = "redis"
+
+// StorageEmbedded stores all data in an embedded database file and does not require Redis
+const StorageEmbedded = "embedded"
+
+// StorageMemory keeps all data in memory only, it is lost after a restart
+const StorageMemory = "memory"
 
 type Configuration struct {
 	RedisSize           int                       `json:"RedisSize"`
@@ -24,14 +34,41 @@ type Configuration struct {
 	ApiDailyCallsUpload int                       `json:"ApiDailyCallsUpload"`
 	ConfigVersion       int                       `json:"ConfigVersion"`
 	RedisUrl            string                    `json:"RedisUrl"`
+	StorageBackend      string                    `json:"StorageBackend"`
+	DatabasePath        string                    `json:"DatabasePath"`
 	AdminUser           string                    `json:"AdminUser"`
 	AdminPassword       string                    `json:"AdminPassword"`
 	WebserverPort       string                    `json:"WebserverPort"`
 	WebserverRedirect   string                    `json:"WebserverRedirect"`
 	ApiKeyEdeka         string                    `json:"ApiKeyEdeka"`
+	FederationKey       string                    `json:"FederationKey"`
+	PeerSyncInterval    int                       `json:"PeerSyncInterval"`
+	Peers               []Peer                    `json:"Peers"`
+	MirrorUpstream      string                    `json:"MirrorUpstream"`
+	MirrorKey           string                    `json:"MirrorKey"`
+	MirrorForwardWrites bool                      `json:"MirrorForwardWrites"`
+	MirrorPollInterval  int                       `json:"MirrorPollInterval"`
+	MetricsRetention    int                       `json:"MetricsRetention"`
+	MetricsAddress      string                    `json:"MetricsAddress"`
+	MetricsToken        string                    `json:"MetricsToken"`
+	ReadyMinFreeRam     int                       `json:"ReadyMinFreeRam"`
+	ReadyMaxImportAge   int                       `json:"ReadyMaxImportAge"`
 	Sessions            map[string]models.Session `json:"Sessions"`
 }
 
+// Peer is another federation server that barcodes are synchronised with
+type Peer struct {
+	// Name is stored as the source of all names received from this peer
+	Name string `json:"Name"`
+	// Url is the base URL of the peer, e.g. https://federation.example.com
+	Url string `json:"Url"`
+	// Key is the FederationKey of the peer
+	Key string `json:"Key"`
+	// Trust is multiplied with the scores received from this peer, between 0 and 1.
+	// If not set, scores are taken over unchanged
+	Trust float64 `json:"Trust"`
+}
+
 func Load() {
 	if !helper.FileExists(configFile) {
 		generateDefault()
@@ -52,6 +89,44 @@ func Load() {
 	}
 }
 
+// Validate returns an error if a loaded value cannot be used by the server
+func Validate() error {
+	switch config.StorageBackend {
+	case StorageRedis:
+		if config.RedisUrl == "" || config.RedisSize < 1 {
+			return errors.New("RedisUrl and RedisSize are required for the Redis backend")
+		}
+	case StorageEmbedded:
+		if config.DatabasePath == "" {
+			return errors.New("DatabasePath is required for the embedded backend")
+		}
+	case StorageMemory:
+	default:
+		return errors.New("unknown StorageBackend " + config.StorageBackend)
+	}
+	if config.WebserverPort == "" {
+		return errors.New("WebserverPort is not set")
+	}
+	if config.ApiDailyCalls < 1 || config.ApiDailyCallsUpload < 1 {
+		return errors.New("ApiDailyCalls and ApiDailyCallsUpload must be positive")
+	}
+	if len(config.Peers) > 0 && config.PeerSyncInterval < 1 {
+		return errors.New("PeerSyncInterval must be positive")
+	}
+	for _, peer := range config.Peers {
+		if peer.Url == "" || peer.Trust < 0 || peer.Trust > 1 {
+			return errors.New("peer " + peer.Name + " requires an Url and a Trust between 0 and 1")
+		}
+	}
+	if config.MirrorUpstream != "" && config.MirrorPollInterval < 1 {
+		return errors.New("MirrorPollInterval must be positive")
+	}
+	if config.ReadyMinFreeRam < 0 || config.ReadyMaxImportAge < 0 {
+		return errors.New("ReadyMinFreeRam and ReadyMaxImportAge must not be negative")
+	}
+	return nil
+}
+
 func Get() *Configuration {
 	return &config
 }
@@ -73,12 +148,20 @@ func generateDefault() {
 	config = Configuration{
 		RedisUrl:            "127.0.0.1:6379",
 		RedisSize:           10,
+		StorageBackend:      StorageRedis,
+		DatabasePath:        "data/barcodes.db",
 		ApiDailyCalls:       200,
 		ApiDailyCallsUpload: 5,
 		AdminUser:           "admin",
 		AdminPassword:       "admin",
 		WebserverPort:       "127.0.0.1:18900",
 		WebserverRedirect:   "https://github.com/Forceu/barcodebuddy",
+		PeerSyncInterval:    60,
+		Peers:               []Peer{},
+		MirrorPollInterval:  10,
+		MetricsRetention:    30,
+		ReadyMinFreeRam:     16,
+		ReadyMaxImportAge:   48,
 		ConfigVersion:       currentConfigVersion,
 		Sessions:            make(map[string]models.Session),
 	}
@@ -91,6 +174,26 @@ func upgrade() {
 	if config.ConfigVersion < 2 {
 		config.Sessions = make(map[string]models.Session)
 	}
+	if config.ConfigVersion < 4 {
+		config.StorageBackend = StorageRedis
+		config.DatabasePath = "data/barcodes.db"
+	}
+	if config.ConfigVersion < 5 {
+		config.PeerSyncInterval = 60
+		config.Peers = []Peer{}
+	}
+	if config.ConfigVersion < 6 {
+		config.MirrorPollInterval = 10
+	}
+	if config.ConfigVersion < 7 {
+		config.MetricsRetention = 30
+	}
+	if config.ConfigVersion < 8 {
+		config.ReadyMinFreeRam = 16
+	}
+	if config.ConfigVersion < 9 {
+		config.ReadyMaxImportAge = 48
+	}
 	config.ConfigVersion = currentConfigVersion
 	save()
 }
@@ -0,0 +1,193 @@
+package export
+
+import (
+	"BarcodeServer/internal/storage"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// FormatCsv writes one row per name, including its score, reports and source.
+	// Metadata is not included, it is only exported by the other formats
+	FormatCsv = "csv"
+	// FormatJson writes a single JSON array containing all barcodes
+	FormatJson = "json"
+	// FormatNdjson writes one JSON object per barcode and line
+	FormatNdjson = "ndjson"
+	// FormatOpenFoodFacts writes a tab separated file with the column names of the Open Food Facts data export
+	FormatOpenFoodFacts = "off"
+)
+
+// Writer writes exported barcodes in one of the export formats
+type Writer interface {
+	// Write writes a single barcode
+	Write(record storage.ExportRecord) error
+	// Close writes all remaining data, it does not close the underlying io.Writer
+	Close() error
+}
+
+type format struct {
+	contentType   string
+	fileExtension string
+	newWriter     func(w io.Writer) Writer
+}
+
+var formats = map[string]format{
+	FormatCsv:           {contentType: "text/csv", fileExtension: "csv", newWriter: newCsvWriter},
+	FormatJson:          {contentType: "application/json", fileExtension: "json", newWriter: newJsonWriter},
+	FormatNdjson:        {contentType: "application/x-ndjson", fileExtension: "ndjson", newWriter: newNdjsonWriter},
+	FormatOpenFoodFacts: {contentType: "text/tab-separated-values", fileExtension: "tsv", newWriter: newOpenFoodFactsWriter},
+}
+
+// ErrUnknownFormat is returned if an export format is requested that does not exist
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// IsValidFormat returns true if the export format exists
+func IsValidFormat(name string) bool {
+	_, ok := formats[name]
+	return ok
+}
+
+// ContentType returns the MIME type of the export format
+func ContentType(name string) string {
+	return formats[name].contentType
+}
+
+// FileName returns the default file name for an export in the format
+func FileName(name string) string {
+	return "exportBarcodes." + formats[name].fileExtension
+}
+
+// NewWriter returns a Writer for the export format
+func NewWriter(name string, w io.Writer) (Writer, error) {
+	result, ok := formats[name]
+	if !ok {
+		return nil, ErrUnknownFormat
+	}
+	return result.newWriter(w), nil
+}
+
+// CsvHeader contains the column names of the CSV export
+var CsvHeader = []string{"barcode", "name", "score", "reports", "source", "hits", "updated", "language"}
+
+type csvWriter struct {
+	writer *csv.Writer
+}
+
+func newCsvWriter(w io.Writer) Writer {
+	writer := csv.NewWriter(w)
+	_ = writer.Write(CsvHeader)
+	return &csvWriter{writer: writer}
+}
+
+func (c *csvWriter) Write(record storage.ExportRecord) error {
+	for _, name := range record.Names {
+		_ = c.writer.Write([]string{
+			record.Barcode,
+			name.Name,
+			storage.FormatScore(name.Score),
+			strconv.Itoa(name.Reports),
+			name.Source,
+			strconv.Itoa(record.Hits),
+			strconv.FormatInt(record.Updated, 10),
+			name.Language,
+		})
+	}
+	return c.writer.Error()
+}
+
+func (c *csvWriter) Close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+type jsonWriter struct {
+	writer       io.Writer
+	isFirstEntry bool
+}
+
+func newJsonWriter(w io.Writer) Writer {
+	return &jsonWriter{writer: w, isFirstEntry: true}
+}
+
+func (j *jsonWriter) Write(record storage.ExportRecord) error {
+	separator := ",\n"
+	if j.isFirstEntry {
+		separator = "[\n"
+		j.isFirstEntry = false
+	}
+	result, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(j.writer, separator+string(result))
+	return err
+}
+
+func (j *jsonWriter) Close() error {
+	if j.isFirstEntry {
+		_, err := io.WriteString(j.writer, "[]\n")
+		return err
+	}
+	_, err := io.WriteString(j.writer, "\n]\n")
+	return err
+}
+
+type ndjsonWriter struct {
+	encoder *json.Encoder
+}
+
+func newNdjsonWriter(w io.Writer) Writer {
+	return &ndjsonWriter{encoder: json.NewEncoder(w)}
+}
+
+func (n *ndjsonWriter) Write(record storage.ExportRecord) error {
+	return n.encoder.Encode(record)
+}
+
+func (n *ndjsonWriter) Close() error {
+	return nil
+}
+
+// openFoodFactsWriter only exports the name and the metadata values with the
+// highest score, as Open Food Facts stores a single value per code
+type openFoodFactsWriter struct {
+	writer *csv.Writer
+}
+
+func newOpenFoodFactsWriter(w io.Writer) Writer {
+	writer := csv.NewWriter(w)
+	writer.Comma = '\t'
+	_ = writer.Write([]string{"code", "product_name", "unique_scans_n", "last_modified_t", "brands", "quantity", "categories", "lang"})
+	return &openFoodFactsWriter{writer: writer}
+}
+
+// tsvReplacer removes characters that are not allowed in the Open Food Facts format
+var tsvReplacer = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+
+func (o *openFoodFactsWriter) Write(record storage.ExportRecord) error {
+	if len(record.Names) == 0 {
+		return nil
+	}
+	metadata := record.TopMetadata()
+	_ = o.writer.Write([]string{
+		record.Barcode,
+		tsvReplacer.Replace(record.Names[0].Name),
+		strconv.Itoa(record.Hits),
+		strconv.FormatInt(record.Updated, 10),
+		tsvReplacer.Replace(metadata.Brand),
+		storage.FormatQuantity(metadata.Quantity, metadata.Unit),
+		tsvReplacer.Replace(metadata.Category),
+		metadata.Language,
+	})
+	return o.writer.Error()
+}
+
+func (o *openFoodFactsWriter) Close() error {
+	o.writer.Flush()
+	return o.writer.Error()
+}
@@ -0,0 +1,196 @@
+package federation
+
+import (
+	"BarcodeServer/internal/configuration"
+	"BarcodeServer/internal/export"
+	"BarcodeServer/internal/helper"
+	"BarcodeServer/internal/import/backup"
+	"BarcodeServer/internal/language"
+	"BarcodeServer/internal/storage"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExportPath is the path that peers request barcodes from
+const ExportPath = "/federation/export"
+
+// ChangesPath is the path of the change log
+const ChangesPath = "/changes"
+
+// HeaderCursor is sent with every export and contains the latest cursor of the
+// change log at the time the export was started
+const HeaderCursor = "federation-cursor"
+
+// HeaderKey is the header that contains the FederationKey of the requested server
+const HeaderKey = "federation-key"
+
+// SourcePrefix is prepended to the name of the peer and stored as the source of received names
+const SourcePrefix = "peer:"
+
+// syncTimeout is the maximum time a single synchronisation with a peer may take
+const syncTimeout = time.Hour
+
+// PeerStatus contains the result of the last synchronisation with a peer
+type PeerStatus struct {
+	Name          string
+	LastSync      string
+	LastError     string
+	ReceivedNames int
+}
+
+var statusMutex sync.Mutex
+var peerStatus = make(map[string]PeerStatus)
+
+// StartPeriodicSync synchronises with all configured peers in the configured interval
+func StartPeriodicSync(store storage.Store) {
+	peers := configuration.Get().Peers
+	if len(peers) == 0 {
+		return
+	}
+	interval := time.Duration(configuration.Get().PeerSyncInterval) * time.Minute
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	for {
+		for _, peer := range peers {
+			SyncPeer(store, peer)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// SyncPeer requests all barcodes from the peer that have changed since the
+// last synchronisation and merges them into the store
+func SyncPeer(store storage.Store, peer configuration.Peer) {
+	status := PeerStatus{Name: peer.Name, LastSync: time.Now().Format(time.RFC1123)}
+	received, err := syncPeer(store, peer)
+	status.ReceivedNames = received
+	if err != nil {
+		status.LastError = err.Error()
+		log.Println("Unable to sync with peer " + peer.Name + ": " + err.Error())
+	}
+	statusMutex.Lock()
+	peerStatus[peer.Name] = status
+	statusMutex.Unlock()
+}
+
+// GetPeerStatus returns the status of all configured peers
+func GetPeerStatus() []PeerStatus {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+	var result []PeerStatus
+	for _, peer := range configuration.Get().Peers {
+		status, ok := peerStatus[peer.Name]
+		if !ok {
+			status = PeerStatus{Name: peer.Name, LastSync: "Never"}
+		}
+		result = append(result, status)
+	}
+	return result
+}
+
+// IsValidKey returns true if the key matches the configured FederationKey. Always
+// returns false if no FederationKey is configured
+func IsValidKey(key string) bool {
+	federationKey := configuration.Get().FederationKey
+	return federationKey != "" && helper.SecureStringEqual(key, federationKey)
+}
+
+func syncPeer(store storage.Store, peer configuration.Peer) (int, error) {
+	stateKey := "peer:" + peer.Name
+	since := store.GetSyncState(stateKey)
+	response, err := requestExport(peer, since)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	received := 0
+	lastUpdated := since
+	decoder := json.NewDecoder(response.Body)
+	for {
+		var record storage.ExportRecord
+		err = decoder.Decode(&record)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return received, err
+		}
+		weighted, ok := weightRecord(record, peer)
+		if ok {
+			store.ImportBarcode(weighted, storage.ImportMerge)
+			received = received + len(weighted.Names)
+		}
+		if record.Updated > lastUpdated {
+			lastUpdated = record.Updated
+		}
+	}
+	// Barcodes that were changed during the same second might not have been
+	// exported yet, so they are requested again during the next synchronisation
+	if lastUpdated > since {
+		store.SetSyncState(stateKey, lastUpdated-1)
+	}
+	return received, nil
+}
+
+func requestExport(peer configuration.Peer, since int64) (*http.Response, error) {
+	client := http.Client{Timeout: syncTimeout}
+	url := strings.TrimSuffix(peer.Url, "/") + ExportPath + "?format=" + export.FormatNdjson + "&since=" + strconv.FormatInt(since, 10)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Barcode Buddy Federation")
+	req.Header.Set(HeaderKey, peer.Key)
+	response, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, errors.New("peer returned status " + response.Status)
+	}
+	return response, nil
+}
+
+// weightRecord validates all received names and metadata values and multiplies
+// their score with the trust of the peer. The hits of the peer are not merged, as they only reflect
+// the lookups of its own users
+func weightRecord(record storage.ExportRecord, peer configuration.Peer) (storage.ExportRecord, bool) {
+	trust := peer.Trust
+	if trust <= 0 || trust > 1 {
+		// A trust above 1 would raise scores every time barcodes are sent back and forth
+		trust = 1
+	}
+	result := storage.ExportRecord{}
+	for _, name := range record.Names {
+		sanitized, ok := backup.SanitizeName(record.Barcode, name.Name)
+		if !ok || name.Score < storage.MinScoreListed {
+			continue
+		}
+		result.Barcode = sanitized.Barcode
+		result.Names = append(result.Names, storage.ExportName{
+			Name:     sanitized.Name,
+			Score:    name.Score * trust,
+			Source:   SourcePrefix + peer.Name,
+			Language: language.Normalize(name.Language),
+		})
+	}
+	for _, metadata := range backup.SanitizeMetadata(record.Metadata) {
+		if metadata.Score < storage.MinScoreListed {
+			continue
+		}
+		metadata.Score = metadata.Score * trust
+		metadata.Source = SourcePrefix + peer.Name
+		result.Metadata = append(result.Metadata, metadata)
+	}
+	return result, len(result.Names) > 0
+}
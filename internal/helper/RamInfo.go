@@ -6,13 +6,21 @@ import (
 	"syscall"
 )
 
-func GetRamInfo() (string, string, error) {
+// GetRamBytes returns the total and the free RAM of the system in bytes
+func GetRamBytes() (uint64, uint64, error) {
 	var info syscall.Sysinfo_t
 	err := syscall.Sysinfo(&info)
+	if err != nil {
+		return 0, 0, err
+	}
+	unit := uint64(info.Unit)
+	return info.Totalram * unit, info.Freeram * unit, nil
+}
+
+func GetRamInfo() (string, string, error) {
+	totalRam, freeRam, err := GetRamBytes()
 	if err != nil {
 		return "", "", err
 	}
-	totalRam := info.Totalram
-	freeRam := info.Freeram
 	return ByteCountSI(totalRam), ByteCountSI(freeRam), nil
 }
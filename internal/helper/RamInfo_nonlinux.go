@@ -4,6 +4,13 @@ package helper
 
 import "errors"
 
+var errRamInfoNotSupported = errors.New("platform: Not supported on this platform")
+
+// GetRamBytes returns the total and the free RAM of the system in bytes
+func GetRamBytes() (uint64, uint64, error) {
+	return 0, 0, errRamInfoNotSupported
+}
+
 func GetRamInfo() (string, string, error) {
-	return "", "", errors.New("platform: Not supported on this platform")
+	return "", "", errRamInfoNotSupported
 }
@@ -0,0 +1,416 @@
+package backup
+
+import (
+	gtin "BarcodeServer/internal/barcode"
+	"BarcodeServer/internal/export"
+	"BarcodeServer/internal/language"
+	"BarcodeServer/internal/storage"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxListedErrors is the maximum amount of rejected rows that are listed in the summary
+const maxListedErrors = 50
+
+// maxLineLength is the maximum length of a single line of an NDJSON file
+const maxLineLength = 1024 * 1024
+
+// Summary contains the result of an import
+type Summary struct {
+	Accepted int
+	Rejected int
+	Errors   []string
+}
+
+func (s *Summary) reject(line int, reason string) {
+	s.Rejected++
+	if len(s.Errors) < maxListedErrors {
+		s.Errors = append(s.Errors, "Row "+strconv.Itoa(line)+": "+reason)
+	}
+}
+
+// String returns a human-readable summary of the import
+func (s Summary) String() string {
+	result := fmt.Sprintf("Accepted rows: %d\nRejected rows: %d\n", s.Accepted, s.Rejected)
+	for _, err := range s.Errors {
+		result = result + err + "\n"
+	}
+	if s.Rejected > len(s.Errors) {
+		result = result + fmt.Sprintf("... and %d more\n", s.Rejected-len(s.Errors))
+	}
+	return result
+}
+
+// ParseMode returns the import mode for "merge" or "replace"
+func ParseMode(mode string) (storage.ImportMode, error) {
+	switch mode {
+	case "", "merge":
+		return storage.ImportMerge, nil
+	case "replace":
+		return storage.ImportReplace, nil
+	default:
+		return storage.ImportMerge, errors.New("unknown import mode " + mode)
+	}
+}
+
+// row is a single name of an imported barcode
+type row struct {
+	line    int
+	barcode string
+	name    storage.ExportName
+	// metadata is only set for one row of every record
+	metadata []storage.ExportMetadata
+	hits     int
+	err      error
+}
+
+// importer combines consecutive rows of the same barcode into a single record
+type importer struct {
+	store   storage.Store
+	mode    storage.ImportMode
+	summary Summary
+	current storage.ExportRecord
+	// replaced contains all barcodes that have been replaced during this import,
+	// so that rows of a barcode that are not consecutive do not replace each other
+	replaced map[string]bool
+}
+
+// Import reads all barcodes in the given export format and stores them. If
+// format is empty, it is detected from the content. Every row is validated
+// with the same rules as uploaded barcodes
+func Import(store storage.Store, reader io.Reader, format string, mode storage.ImportMode) (Summary, error) {
+	bufferedReader := bufio.NewReader(reader)
+	if format == "" {
+		format = detectFormat(bufferedReader)
+	}
+	imp := &importer{store: store, mode: mode, replaced: make(map[string]bool)}
+	var err error
+	switch format {
+	case export.FormatCsv:
+		err = readCsv(bufferedReader, imp.addRow)
+	case export.FormatOpenFoodFacts:
+		err = readOpenFoodFacts(bufferedReader, imp.addRow)
+	case export.FormatJson:
+		err = readJson(bufferedReader, imp.addRow)
+	case export.FormatNdjson:
+		err = readNdjson(bufferedReader, imp.addRow)
+	default:
+		err = export.ErrUnknownFormat
+	}
+	imp.flush()
+	return imp.summary, err
+}
+
+func (imp *importer) addRow(r row) {
+	if r.err != nil {
+		imp.summary.reject(r.line, r.err.Error())
+		return
+	}
+	metadata := SanitizeMetadata(r.metadata)
+	// Rows without a name are only imported if they contain valid metadata
+	isMetadataOnly := r.name.Name == "" && len(r.metadata) > 0
+	var sanitized storage.Barcode
+	var ok bool
+	if isMetadataOnly {
+		sanitized.Barcode, ok = gtin.Normalize(r.barcode)
+		if ok && len(metadata) == 0 {
+			imp.summary.reject(r.line, "no valid metadata")
+			return
+		}
+	} else {
+		sanitized, ok = SanitizeName(r.barcode, r.name.Name)
+	}
+	if !ok {
+		imp.summary.reject(r.line, "invalid barcode or name")
+		return
+	}
+	if sanitized.Barcode != imp.current.Barcode {
+		imp.flush()
+		imp.current = storage.ExportRecord{Barcode: sanitized.Barcode}
+	}
+	if !isMetadataOnly {
+		r.name.Name = sanitized.Name
+		r.name.Language = language.Normalize(r.name.Language)
+		imp.current.Names = append(imp.current.Names, r.name)
+	}
+	imp.current.Metadata = append(imp.current.Metadata, metadata...)
+	imp.current.Hits = r.hits
+	imp.summary.Accepted++
+}
+
+// flush stores the current record
+func (imp *importer) flush() {
+	if len(imp.current.Names) == 0 && len(imp.current.Metadata) == 0 {
+		return
+	}
+	mode := imp.mode
+	if mode == storage.ImportReplace {
+		if imp.replaced[imp.current.Barcode] {
+			mode = storage.ImportMerge
+		}
+		imp.replaced[imp.current.Barcode] = true
+	}
+	imp.store.ImportBarcode(imp.current, mode)
+	imp.current = storage.ExportRecord{}
+}
+
+// SanitizeName validates an exported barcode and name with the same rules as
+// uploaded barcodes. Exported names are already escaped, so they are unescaped first
+func SanitizeName(barcode, name string) (storage.Barcode, bool) {
+	return storage.SanitizeBarcode(storage.Barcode{
+		Barcode: barcode,
+		Name:    html.UnescapeString(name),
+	})
+}
+
+// SanitizeMetadata validates exported metadata values with the same rules as
+// uploaded metadata. Values that are not valid are removed
+func SanitizeMetadata(metadata []storage.ExportMetadata) []storage.ExportMetadata {
+	var result []storage.ExportMetadata
+	for _, entry := range metadata {
+		value, ok := storage.SanitizeMetadataValue(entry.Field, html.UnescapeString(entry.Value))
+		if !ok {
+			continue
+		}
+		entry.Value = value
+		result = append(result, entry)
+	}
+	return result
+}
+
+// detectFormat guesses the export format from the first line of the content
+func detectFormat(reader *bufio.Reader) string {
+	start, _ := reader.Peek(4096)
+	start = bytes.TrimLeft(bytes.TrimPrefix(start, []byte("\xef\xbb\xbf")), " \t\r\n")
+	if len(start) == 0 {
+		return export.FormatCsv
+	}
+	switch {
+	case start[0] == '[':
+		return export.FormatJson
+	case start[0] == '{':
+		return export.FormatNdjson
+	}
+	firstLine, _, _ := bytes.Cut(start, []byte("\n"))
+	if bytes.Contains(firstLine, []byte("\t")) {
+		return export.FormatOpenFoodFacts
+	}
+	return export.FormatCsv
+}
+
+// readCsv reads the CSV export. The format of older versions, which contains
+// a barcode followed by all of its names, is supported as well
+func readCsv(reader io.Reader, rowFunc func(row)) error {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	header, err := csvReader.Read()
+	if err != nil {
+		return err
+	}
+	isLegacyFormat := len(header) == 2 && header[0] == "barcode" && header[1] == "names"
+	// Exports of older versions do not contain the last column "language"
+	isWithoutLanguage := strings.Join(header, ",") == strings.Join(export.CsvHeader[:len(export.CsvHeader)-1], ",")
+	if !isLegacyFormat && !isWithoutLanguage && strings.Join(header, ",") != strings.Join(export.CsvHeader, ",") {
+		return errors.New("unknown CSV header")
+	}
+	line := 1
+	for {
+		line++
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			var parseError *csv.ParseError
+			if errors.As(err, &parseError) {
+				rowFunc(row{line: line, err: err})
+				continue
+			}
+			return err
+		}
+		if isLegacyFormat {
+			for _, name := range record[1:] {
+				rowFunc(row{line: line, barcode: record[0], name: storage.ExportName{Name: name, Score: 1}})
+			}
+			continue
+		}
+		rowFunc(parseCsvRow(line, record, len(header)))
+	}
+}
+
+func parseCsvRow(line int, record []string, columns int) row {
+	result := row{line: line}
+	if len(record) != columns {
+		result.err = errors.New("invalid amount of columns")
+		return result
+	}
+	score, err := strconv.ParseFloat(record[2], 64)
+	if err != nil {
+		result.err = errors.New("invalid score")
+		return result
+	}
+	reports, err := strconv.Atoi(record[3])
+	if err != nil {
+		result.err = errors.New("invalid report count")
+		return result
+	}
+	hits, err := strconv.Atoi(record[5])
+	if err != nil {
+		result.err = errors.New("invalid hit count")
+		return result
+	}
+	result.barcode = record[0]
+	result.name = storage.ExportName{Name: record[1], Score: score, Reports: reports, Source: record[4]}
+	if columns == len(export.CsvHeader) {
+		result.name.Language = record[7]
+	}
+	result.hits = hits
+	return result
+}
+
+// openFoodFactsMetadata maps the columns of an Open Food Facts export to metadata fields
+var openFoodFactsMetadata = map[string]string{
+	"brands":     storage.FieldBrand,
+	"quantity":   storage.FieldQuantity,
+	"categories": storage.FieldCategory,
+	"lang":       storage.FieldLanguage,
+}
+
+// readOpenFoodFacts reads a tab separated file that contains the columns "code" and "product_name".
+// The columns brands, quantity, categories and lang are imported as metadata if they exist
+func readOpenFoodFacts(reader io.Reader, rowFunc func(row)) error {
+	csvReader := csv.NewReader(reader)
+	csvReader.Comma = '\t'
+	csvReader.FieldsPerRecord = -1
+	csvReader.LazyQuotes = true
+	header, err := csvReader.Read()
+	if err != nil {
+		return err
+	}
+	columnCode, columnName, columnScans, columnLanguage := -1, -1, -1, -1
+	columnsMetadata := make(map[int]string)
+	for i, column := range header {
+		switch column {
+		case "code":
+			columnCode = i
+		case "product_name":
+			columnName = i
+		case "unique_scans_n":
+			columnScans = i
+		case "lang":
+			columnLanguage = i
+		}
+		field, ok := openFoodFactsMetadata[column]
+		if ok {
+			columnsMetadata[i] = field
+		}
+	}
+	if columnCode == -1 || columnName == -1 {
+		return errors.New("columns code and product_name are required")
+	}
+	line := 1
+	for {
+		line++
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(record) <= columnCode || len(record) <= columnName {
+			rowFunc(row{line: line, err: errors.New("invalid amount of columns")})
+			continue
+		}
+		result := row{line: line, barcode: record[columnCode], name: storage.ExportName{Name: record[columnName], Score: 1}}
+		if columnScans != -1 && len(record) > columnScans {
+			result.hits, _ = strconv.Atoi(record[columnScans])
+		}
+		if columnLanguage != -1 && len(record) > columnLanguage {
+			// The product name is stored in the main language of the product
+			result.name.Language = record[columnLanguage]
+		}
+		for column, field := range columnsMetadata {
+			if len(record) <= column || record[column] == "" {
+				continue
+			}
+			value := record[column]
+			if field == storage.FieldBrand || field == storage.FieldCategory {
+				// Brands and categories are comma separated lists, only the first entry is used
+				value, _, _ = strings.Cut(value, ",")
+			}
+			result.metadata = append(result.metadata, storage.ExportMetadata{Field: field, Value: value, Score: 1})
+		}
+		rowFunc(result)
+	}
+}
+
+// readJson reads a JSON array of barcodes without loading the whole file into memory
+func readJson(reader io.Reader, rowFunc func(row)) error {
+	decoder := json.NewDecoder(reader)
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != json.Delim('[') {
+		return errors.New("expected a JSON array")
+	}
+	for line := 1; decoder.More(); line++ {
+		var record storage.ExportRecord
+		err = decoder.Decode(&record)
+		if err != nil {
+			return err
+		}
+		recordToRows(line, record, rowFunc)
+	}
+	_, err = decoder.Token()
+	return err
+}
+
+// readNdjson reads one barcode per line
+func readNdjson(reader io.Reader, rowFunc func(row)) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+	for line := 1; scanner.Scan(); line++ {
+		content := bytes.TrimSpace(scanner.Bytes())
+		if len(content) == 0 {
+			continue
+		}
+		var record storage.ExportRecord
+		err := json.Unmarshal(content, &record)
+		if err != nil {
+			rowFunc(row{line: line, err: errors.New("invalid JSON")})
+			continue
+		}
+		recordToRows(line, record, rowFunc)
+	}
+	return scanner.Err()
+}
+
+// recordToRows returns a row for every name of the record. Records without
+// names are returned as a single row that only contains the metadata
+func recordToRows(line int, record storage.ExportRecord, rowFunc func(row)) {
+	if len(record.Names) == 0 && len(record.Metadata) == 0 {
+		rowFunc(row{line: line, err: errors.New("no names or metadata")})
+		return
+	}
+	if len(record.Names) == 0 {
+		rowFunc(row{line: line, barcode: record.Barcode, metadata: record.Metadata, hits: record.Hits})
+		return
+	}
+	for i, name := range record.Names {
+		result := row{line: line, barcode: record.Barcode, name: name, hits: record.Hits}
+		if i == 0 {
+			result.metadata = record.Metadata
+		}
+		rowFunc(result)
+	}
+}
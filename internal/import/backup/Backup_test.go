@@ -0,0 +1,33 @@
+package backup
+
+import (
+	"BarcodeServer/internal/export"
+	"BarcodeServer/internal/storage"
+	"BarcodeServer/internal/storage/memory"
+	"strings"
+	"testing"
+)
+
+func TestImportMetadataOnly(t *testing.T) {
+	store := memory.New()
+	content := strings.Join([]string{
+		`{"Barcode":"4006040000013","Metadata":[{"Field":"brand","Value":"Example","Score":1}]}`,
+		`{"Barcode":"4006040000020","Metadata":[{"Field":"language","Value":"invalid","Score":1}]}`,
+		`{"Barcode":"4006040000037"}`,
+		`{"Barcode":"4006040000014","Metadata":[{"Field":"brand","Value":"Example","Score":1}]}`,
+	}, "\n")
+	summary, err := Import(store, strings.NewReader(content), export.FormatNdjson, storage.ImportMerge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Accepted != 1 || summary.Rejected != 3 {
+		t.Errorf("unexpected summary %s", summary)
+	}
+	metadata := store.GetMetadata([]string{"4006040000013", "4006040000020"})
+	if metadata[0].Brand != "Example" || metadata[1] != (storage.Metadata{}) {
+		t.Errorf("unexpected metadata %+v", metadata)
+	}
+	if names := store.GetBarcode("4006040000013", false); len(names) != 0 {
+		t.Errorf("expected no names, got %q", names)
+	}
+}
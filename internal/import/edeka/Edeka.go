@@ -1,7 +1,8 @@
 package edeka
 
 import (
-	"BarcodeServer/internal/redis"
+	"BarcodeServer/internal/metrics"
+	"BarcodeServer/internal/storage"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
@@ -19,8 +20,9 @@ type edekaItem struct {
 	Barcodes [][]string `json:"EAN"`
 }
 
-func itemsToBarcodes(response []edekaItem) redis.GrocyBarcodes {
-	var result []redis.Barcode
+// itemsToBarcodes converts the products of the Edeka API, which only contains German product names
+func itemsToBarcodes(response []edekaItem) storage.GrocyBarcodes {
+	var result []storage.Barcode
 	for _, product := range response {
 		var name string
 		if product.Brand != "" {
@@ -31,13 +33,14 @@ func itemsToBarcodes(response []edekaItem) redis.GrocyBarcodes {
 		// It would save resources to create a slice with the length determined, for better
 		// readability and robustness append has been used instead however
 		for _, barcode := range product.Barcodes[0] {
-			result = append(result, redis.Barcode{
-				Barcode: barcode,
-				Name:    name,
+			result = append(result, storage.Barcode{
+				Barcode:  barcode,
+				Name:     name,
+				Metadata: storage.Metadata{Brand: product.Brand, ProductName: product.Name, Language: "de"},
 			})
 		}
 	}
-	return redis.GrocyBarcodes{Barcodes: result}
+	return storage.GrocyBarcodes{Barcodes: result}
 }
 
 func getBarcodesFromApi(apikey string) (error, []edekaItem) {
@@ -53,7 +56,7 @@ func getBarcodesFromApi(apikey string) (error, []edekaItem) {
 
 	res, getErr := apiClient.Do(req)
 	if getErr != nil {
-		return err, nil
+		return getErr, nil
 	}
 
 	if res.Body != nil {
@@ -62,7 +65,7 @@ func getBarcodesFromApi(apikey string) (error, []edekaItem) {
 
 	body, readErr := ioutil.ReadAll(res.Body)
 	if readErr != nil {
-		return err, nil
+		return readErr, nil
 	}
 	if string(body) == "Wrong API Key" {
 		return errors.New("incorrect api key"), nil
@@ -76,23 +79,28 @@ func getBarcodesFromApi(apikey string) (error, []edekaItem) {
 	return nil, response
 }
 
-func StartPeriodicSync(apikey string) {
-	RunImport(apikey)
+func StartPeriodicSync(apikey string, store storage.Store) {
+	RunImport(apikey, store)
 	time.Sleep(24 * time.Hour)
-	go StartPeriodicSync(apikey)
+	go StartPeriodicSync(apikey, store)
 }
 
-func RunImport(apikey string) {
+func RunImport(apikey string, store storage.Store) {
 	if apikey == "" {
 		return
 	}
+	start := time.Now()
 	err, response := getBarcodesFromApi(apikey)
 	if err != nil {
 		log.Println("Unable to sync Edeka barcodes: " + err.Error())
+		metrics.Add(metrics.CounterImportFailed, 1)
+		metrics.ObserveImport(storage.SourceEdeka, time.Since(start), 0, err)
 		return
 	}
 	log.Println("Edeka Import: Total products " + strconv.Itoa(len(response)))
 	barcodes := itemsToBarcodes(response)
 	log.Println("Edeka Import: Total barcodes " + strconv.Itoa(len(barcodes.Barcodes)))
-	redis.AddGrocyBarcodes(barcodes, "edeka")
+	store.AddGrocyBarcodes(barcodes, "edeka", storage.SourceEdeka)
+	metrics.Add(metrics.CounterImported, len(barcodes.Barcodes))
+	metrics.ObserveImport(storage.SourceEdeka, time.Since(start), len(barcodes.Barcodes), nil)
 }
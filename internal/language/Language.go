@@ -0,0 +1,173 @@
+package language
+
+import (
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// keywords contains common words of product names for every language that can be detected
+var keywords = map[string][]string{
+	"de": {"und", "mit", "ohne", "der", "die", "das", "für", "aus", "milch", "käse", "wurst", "brot",
+		"butter", "sahne", "joghurt", "quark", "schinken", "hähnchen", "nudeln", "kartoffeln", "saft",
+		"wasser", "bier", "wein", "schokolade", "zucker", "mehl", "salz", "pfeffer", "gewürz", "kaffee",
+		"tee", "frisch", "vollmilch", "apfel", "erdbeere", "gemüse", "fleisch", "soße", "sauce", "stück"},
+	"en": {"and", "with", "without", "the", "for", "from", "of", "milk", "cheese", "bread", "cream",
+		"yogurt", "yoghurt", "ham", "chicken", "pasta", "potatoes", "juice", "water", "beer", "wine",
+		"chocolate", "sugar", "flour", "salt", "pepper", "coffee", "tea", "fresh", "whole", "apple",
+		"strawberry", "vegetables", "meat", "sauce", "cookies", "peanut", "beans", "chips", "organic"},
+	"fr": {"et", "avec", "sans", "le", "la", "les", "pour", "de", "du", "des", "lait", "fromage", "pain",
+		"beurre", "crème", "yaourt", "jambon", "poulet", "pâtes", "jus", "eau", "bière", "vin", "chocolat",
+		"sucre", "farine", "sel", "poivre", "café", "thé", "frais", "pomme", "fraise", "légumes", "viande"},
+	"nl": {"en", "met", "zonder", "het", "een", "voor", "melk", "kaas", "brood", "boter", "room",
+		"kip", "sap", "water", "bier", "wijn", "chocolade", "suiker", "meel", "zout", "koffie", "thee",
+		"vers", "appel", "aardbei", "groenten", "vlees", "saus", "halfvolle", "volle"},
+	"es": {"con", "sin", "el", "los", "las", "para", "leche", "queso", "pan", "mantequilla",
+		"jamón", "pollo", "zumo", "jugo", "agua", "cerveza", "vino", "azúcar", "harina", "sal",
+		"pimienta", "fresco", "manzana", "fresa", "verduras", "carne", "salsa", "entera"},
+	"it": {"con", "senza", "il", "lo", "gli", "per", "latte", "formaggio", "pane", "burro",
+		"panna", "prosciutto", "pollo", "succo", "acqua", "birra", "vino", "cioccolato", "zucchero",
+		"farina", "sale", "pepe", "caffè", "tè", "fresco", "mela", "fragola", "verdure", "carne", "sugo"},
+}
+
+// characters contains letters that are mostly used by a single language of keywords
+var characters = map[rune]string{
+	'ä': "de", 'ö': "de", 'ü': "de", 'ß': "de",
+	'ç': "fr", 'è': "fr", 'ê': "fr", 'à': "fr", 'œ': "fr",
+	'ñ': "es", '¿': "es", '¡': "es",
+	'ò': "it", 'ì': "it",
+}
+
+// wordsPerLanguage maps every keyword to the languages that contain it
+var wordsPerLanguage = func() map[string][]string {
+	result := make(map[string][]string)
+	for language, words := range keywords {
+		for _, word := range words {
+			result[word] = append(result[word], language)
+		}
+	}
+	return result
+}()
+
+// Detect guesses the language of a product name. Returns an empty string if
+// the name does not contain enough hints, e.g. if it only consists of a brand.
+// Names may be HTML escaped, as they are stored
+func Detect(name string) string {
+	name = strings.ToLower(html.UnescapeString(name))
+	scores := make(map[string]float64)
+	for _, char := range name {
+		language, ok := characters[char]
+		if ok {
+			scores[language] = scores[language] + 2
+		}
+	}
+	words := strings.FieldsFunc(name, func(char rune) bool {
+		return !unicode.IsLetter(char)
+	})
+	for _, word := range words {
+		languages := wordsPerLanguage[word]
+		for _, language := range languages {
+			// Words that are used by several languages are weaker hints
+			scores[language] = scores[language] + 1/float64(len(languages))
+		}
+	}
+	result := ""
+	highestScore := 0.0
+	for language, score := range scores {
+		if score > highestScore {
+			result = language
+			highestScore = score
+		}
+	}
+	if highestScore < 1 {
+		return ""
+	}
+	for language, score := range scores {
+		if language != result && score == highestScore {
+			// Ambiguous
+			return ""
+		}
+	}
+	return result
+}
+
+// Normalize returns the ISO 639-1 code of a language tag, e.g. "de" for "de-AT".
+// Returns an empty string if the tag is not valid
+func Normalize(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	tag, _, _ = strings.Cut(strings.ReplaceAll(tag, "_", "-"), "-")
+	if len(tag) != 2 || tag[0] < 'a' || tag[0] > 'z' || tag[1] < 'a' || tag[1] > 'z' {
+		return ""
+	}
+	return tag
+}
+
+// ParseAcceptLanguage returns the languages of an Accept-Language header or a
+// comma separated list of languages, ordered by their weight
+func ParseAcceptLanguage(header string) []string {
+	type weightedLanguage struct {
+		language string
+		weight   float64
+	}
+	var languages []weightedLanguage
+	for _, entry := range strings.Split(header, ",") {
+		tag, parameters, _ := strings.Cut(entry, ";")
+		weight := 1.0
+		parameter := strings.TrimSpace(parameters)
+		if strings.HasPrefix(parameter, "q=") {
+			parsed, err := strconv.ParseFloat(parameter[2:], 64)
+			if err == nil {
+				weight = parsed
+			}
+		}
+		language := Normalize(tag)
+		if language == "" || weight <= 0 {
+			continue
+		}
+		languages = append(languages, weightedLanguage{language: language, weight: weight})
+	}
+	sort.SliceStable(languages, func(i, j int) bool {
+		return languages[i].weight > languages[j].weight
+	})
+	var result []string
+	isAdded := make(map[string]bool)
+	for _, entry := range languages {
+		if !isAdded[entry.language] {
+			result = append(result, entry.language)
+			isAdded[entry.language] = true
+		}
+	}
+	return result
+}
+
+// SortNames moves the names in the preferred languages to the front, in the order of
+// preferred. All other names follow, the order within each group is kept. The language
+// of names without a stored language is detected
+func SortNames(names []string, languages map[string]string, preferred []string) []string {
+	if len(preferred) == 0 {
+		return names
+	}
+	rank := make(map[string]int, len(preferred))
+	for i, language := range preferred {
+		rank[language] = i
+	}
+	nameRank := make(map[string]int, len(names))
+	for _, name := range names {
+		language, ok := languages[name]
+		if !ok {
+			language = Detect(name)
+		}
+		position, ok := rank[language]
+		if !ok {
+			position = len(preferred)
+		}
+		nameRank[name] = position
+	}
+	result := append([]string(nil), names...)
+	sort.SliceStable(result, func(i, j int) bool {
+		return nameRank[result[i]] < nameRank[result[j]]
+	})
+	return result
+}
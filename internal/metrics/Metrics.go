@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"BarcodeServer/internal/configuration"
+	"BarcodeServer/internal/storage"
+	"sync"
+	"time"
+)
+
+// SnapshotInterval is the interval in which the statistics of the server are stored
+const SnapshotInterval = 5 * time.Minute
+
+// defaultRetentionDays is used if MetricsRetention is not set in the configuration
+const defaultRetentionDays = 30
+
+// Counters of events that are stored in every snapshot
+const (
+	// CounterRateLimited counts requests that have been rejected, as the user has reached the daily limit
+	CounterRateLimited = "RateLimited"
+	// CounterBadRequests counts requests that have been rejected as invalid
+	CounterBadRequests = "BadRequests"
+	// CounterUploads counts barcodes that have been uploaded by users
+	CounterUploads = "Uploads"
+	// CounterImported counts barcodes that have been imported from files or from Edeka
+	CounterImported = "Imported"
+	// CounterImportRejected counts rows of imported files that have been rejected
+	CounterImportRejected = "ImportRejected"
+	// CounterImportFailed counts imports that have been aborted
+	CounterImportFailed = "ImportFailed"
+)
+
+var countersMutex sync.Mutex
+
+// counters and requests contain the events since the last snapshot
+var counters = make(map[string]int64)
+var requests = make(map[string]int64)
+
+// totalCounters and totalRequests contain all events since the start of the server
+var totalCounters = make(map[string]int64)
+var totalRequests = make(map[requestKey]int64)
+var requestDurations = make(map[string]*histogram)
+
+// requestKey identifies the requests of an endpoint that have been answered with the same status code
+type requestKey struct {
+	endpoint string
+	status   int
+}
+
+// Add increases a counter, e.g. CounterUploads
+func Add(counter string, amount int) {
+	countersMutex.Lock()
+	counters[counter] += int64(amount)
+	totalCounters[counter] += int64(amount)
+	countersMutex.Unlock()
+}
+
+// ObserveRequest counts a request of the endpoint that has been answered with
+// the status code after duration
+func ObserveRequest(endpoint string, status int, duration time.Duration) {
+	countersMutex.Lock()
+	defer countersMutex.Unlock()
+	requests[endpoint]++
+	totalRequests[requestKey{endpoint: endpoint, status: status}]++
+	durations, ok := requestDurations[endpoint]
+	if !ok {
+		durations = newHistogram()
+		requestDurations[endpoint] = durations
+	}
+	durations.observe(duration)
+}
+
+// resetCounters returns all counters and requests since the last call and starts counting from zero
+func resetCounters() (map[string]int64, map[string]int64) {
+	countersMutex.Lock()
+	defer countersMutex.Unlock()
+	resultCounters, resultRequests := counters, requests
+	counters = make(map[string]int64)
+	requests = make(map[string]int64)
+	return resultCounters, resultRequests
+}
+
+// RetentionDays returns the amount of days snapshots are kept
+func RetentionDays() int {
+	days := configuration.Get().MetricsRetention
+	if days <= 0 {
+		return defaultRetentionDays
+	}
+	return days
+}
+
+// Start stores a snapshot of the statistics every SnapshotInterval and removes
+// all snapshots that are older than RetentionDays
+func Start(store storage.Store) {
+	for {
+		time.Sleep(SnapshotInterval)
+		now := time.Now()
+		store.AddMetricsSnapshot(newSnapshot(store, now))
+		store.RemoveMetricsSnapshots(now.AddDate(0, 0, -RetentionDays()).Unix())
+	}
+}
+
+func newSnapshot(store storage.Store, now time.Time) storage.MetricsSnapshot {
+	snapshot := storage.MetricsSnapshot{
+		Time:          now.Unix(),
+		TotalBarcodes: store.GetTotalBarcodes(),
+		Users:         store.GetTotalUsers(),
+		ActiveUsers:   store.GetTotalActiveUsers(),
+		TotalVotes:    store.GetTotalVotes(),
+		TotalReports:  store.GetTotalReports(),
+		RamUsage:      store.GetRamUsage(),
+	}
+	snapshot.Counters, snapshot.Requests = resetCounters()
+	return snapshot
+}
@@ -0,0 +1,317 @@
+package metrics
+
+import (
+	"BarcodeServer/internal/storage"
+	"bufio"
+	"io"
+	"math"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ContentType is the content type of the Prometheus text format written by WritePrometheus
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// durationBuckets are the upper bounds in seconds of the buckets of all duration histograms
+var durationBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// histogram counts observed durations in durationBuckets
+type histogram struct {
+	// counts contains the amount of observations per bucket, the last entry counts all longer durations
+	counts []int64
+	sum    float64
+	count  int64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]int64, len(durationBuckets)+1)}
+}
+
+func (h *histogram) observe(duration time.Duration) {
+	seconds := duration.Seconds()
+	index := sort.SearchFloat64s(durationBuckets, seconds)
+	h.counts[index]++
+	h.sum += seconds
+	h.count++
+}
+
+// counterNames maps the counters of Add to their name in the Prometheus format
+var counterNames = []struct {
+	counter string
+	name    string
+	help    string
+}{
+	{counter: CounterRateLimited, name: "barcodeserver_rate_limited_requests_total", help: "Requests rejected as the user reached the daily limit"},
+	{counter: CounterBadRequests, name: "barcodeserver_bad_requests_total", help: "Requests rejected as invalid"},
+	{counter: CounterUploads, name: "barcodeserver_uploaded_barcodes_total", help: "Barcodes uploaded by users"},
+	{counter: CounterImported, name: "barcodeserver_imported_barcodes_total", help: "Barcodes imported from files or from Edeka"},
+	{counter: CounterImportRejected, name: "barcodeserver_import_rejected_rows_total", help: "Rows of imported files that have been rejected"},
+	{counter: CounterImportFailed, name: "barcodeserver_import_failures_total", help: "Imports that have been aborted"},
+}
+
+var redisDurations = newHistogram()
+var redisErrors int64
+
+// ObserveRedisCommand records the duration of a command sent to Redis and if it failed
+func ObserveRedisCommand(duration time.Duration, err error) {
+	countersMutex.Lock()
+	defer countersMutex.Unlock()
+	redisDurations.observe(duration)
+	if err != nil {
+		redisErrors++
+	}
+}
+
+// gauge is a value that is read when the metrics are requested
+type gauge struct {
+	name  string
+	help  string
+	value func() float64
+}
+
+var gauges []gauge
+
+// RegisterGauge adds a value to the metrics, e.g. the state of a connection pool.
+// The value is read on every request of the metrics
+func RegisterGauge(name, help string, value func() float64) {
+	countersMutex.Lock()
+	gauges = append(gauges, gauge{name: name, help: help, value: value})
+	countersMutex.Unlock()
+}
+
+// importResult is the result of the last run of a periodic import
+type importResult struct {
+	duration    time.Duration
+	barcodes    int
+	lastSuccess time.Time
+	successes   int64
+	failures    int64
+}
+
+var imports = make(map[string]*importResult)
+
+// ObserveImport records a run of a periodic import, e.g. from Edeka. Barcodes is
+// the amount of barcodes that have been imported, err is set if the import failed
+func ObserveImport(source string, duration time.Duration, barcodes int, err error) {
+	countersMutex.Lock()
+	defer countersMutex.Unlock()
+	result, ok := imports[source]
+	if !ok {
+		result = &importResult{}
+		imports[source] = result
+	}
+	result.duration = duration
+	if err != nil {
+		result.failures++
+		return
+	}
+	result.barcodes = barcodes
+	result.lastSuccess = time.Now()
+	result.successes++
+}
+
+// LastSuccessfulImport returns the time of the last successful run of the import
+// of source. Returns false if it has not succeeded since the start of the server
+func LastSuccessfulImport(source string) (time.Time, bool) {
+	countersMutex.Lock()
+	defer countersMutex.Unlock()
+	result, ok := imports[source]
+	if !ok || result.lastSuccess.IsZero() {
+		return time.Time{}, false
+	}
+	return result.lastSuccess, true
+}
+
+// startTime is the time the server has been started
+var startTime = time.Now()
+
+// StartTime returns the time the server has been started
+func StartTime() time.Time {
+	return startTime
+}
+
+// WritePrometheus writes all metrics in the Prometheus text format
+func WritePrometheus(w io.Writer, store storage.Store) error {
+	out := &promWriter{writer: bufio.NewWriter(w)}
+	writeRequests(out)
+	writeCounters(out)
+	writeStore(out, store)
+	writeImports(out)
+	writeRuntime(out)
+	return out.writer.Flush()
+}
+
+func writeRequests(out *promWriter) {
+	countersMutex.Lock()
+	defer countersMutex.Unlock()
+	keys := make([]requestKey, 0, len(totalRequests))
+	for key := range totalRequests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].endpoint != keys[j].endpoint {
+			return keys[i].endpoint < keys[j].endpoint
+		}
+		return keys[i].status < keys[j].status
+	})
+	out.header("barcodeserver_http_requests_total", "Requests per endpoint and status code", "counter")
+	for _, key := range keys {
+		out.sample("barcodeserver_http_requests_total", labels("handler", key.endpoint, "code", strconv.Itoa(key.status)), float64(totalRequests[key]))
+	}
+
+	endpoints := make([]string, 0, len(requestDurations))
+	for endpoint := range requestDurations {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	out.header("barcodeserver_http_request_duration_seconds", "Time until requests have been answered, per endpoint", "histogram")
+	for _, endpoint := range endpoints {
+		out.histogram("barcodeserver_http_request_duration_seconds", []string{"handler", endpoint}, requestDurations[endpoint])
+	}
+}
+
+func writeCounters(out *promWriter) {
+	countersMutex.Lock()
+	defer countersMutex.Unlock()
+	for _, counter := range counterNames {
+		out.header(counter.name, counter.help, "counter")
+		out.sample(counter.name, "", float64(totalCounters[counter.counter]))
+	}
+	if redisDurations.count > 0 || redisErrors > 0 {
+		out.header("barcodeserver_redis_command_duration_seconds", "Time until commands have been answered by Redis", "histogram")
+		out.histogram("barcodeserver_redis_command_duration_seconds", nil, redisDurations)
+		out.header("barcodeserver_redis_command_errors_total", "Commands that Redis answered with an error or did not answer", "counter")
+		out.sample("barcodeserver_redis_command_errors_total", "", float64(redisErrors))
+	}
+	for _, value := range gauges {
+		out.header(value.name, value.help, "gauge")
+		out.sample(value.name, "", value.value())
+	}
+}
+
+func writeStore(out *promWriter, store storage.Store) {
+	values := []struct {
+		name  string
+		help  string
+		value float64
+	}{
+		{name: "barcodeserver_barcodes", help: "Stored barcodes", value: float64(store.GetTotalBarcodes())},
+		{name: "barcodeserver_users", help: "Users that have ever sent a request", value: float64(store.GetTotalUsers())},
+		{name: "barcodeserver_active_users", help: "Users that have sent a request within the last 30 days", value: float64(store.GetTotalActiveUsers())},
+		{name: "barcodeserver_votes", help: "Stored votes", value: float64(store.GetTotalVotes())},
+		{name: "barcodeserver_reports", help: "Reported names that have not been processed", value: float64(store.GetTotalReports())},
+		{name: "barcodeserver_storage_memory_bytes", help: "Memory used by the stored data, 0 if unknown", value: float64(store.GetRamUsage())},
+	}
+	for _, value := range values {
+		out.header(value.name, value.help, "gauge")
+		out.sample(value.name, "", value.value)
+	}
+}
+
+func writeImports(out *promWriter) {
+	countersMutex.Lock()
+	defer countersMutex.Unlock()
+	sources := make([]string, 0, len(imports))
+	for source := range imports {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	out.header("barcodeserver_import_runs_total", "Runs of periodic imports per source and result", "counter")
+	for _, source := range sources {
+		out.sample("barcodeserver_import_runs_total", labels("source", source, "result", "success"), float64(imports[source].successes))
+		out.sample("barcodeserver_import_runs_total", labels("source", source, "result", "failure"), float64(imports[source].failures))
+	}
+	out.header("barcodeserver_import_duration_seconds", "Duration of the last run of periodic imports", "gauge")
+	for _, source := range sources {
+		out.sample("barcodeserver_import_duration_seconds", labels("source", source), imports[source].duration.Seconds())
+	}
+	out.header("barcodeserver_import_barcodes", "Barcodes received by the last successful run of periodic imports", "gauge")
+	for _, source := range sources {
+		out.sample("barcodeserver_import_barcodes", labels("source", source), float64(imports[source].barcodes))
+	}
+	out.header("barcodeserver_import_last_success_timestamp_seconds", "Unix time of the last successful run of periodic imports", "gauge")
+	for _, source := range sources {
+		if !imports[source].lastSuccess.IsZero() {
+			out.sample("barcodeserver_import_last_success_timestamp_seconds", labels("source", source), float64(imports[source].lastSuccess.Unix()))
+		}
+	}
+}
+
+func writeRuntime(out *promWriter) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	out.header("go_info", "Version of Go the server has been built with", "gauge")
+	out.sample("go_info", labels("version", runtime.Version()), 1)
+	values := []struct {
+		name  string
+		help  string
+		kind  string
+		value float64
+	}{
+		{name: "go_goroutines", help: "Number of goroutines that currently exist", kind: "gauge", value: float64(runtime.NumGoroutine())},
+		{name: "go_memstats_alloc_bytes", help: "Bytes of allocated heap objects", kind: "gauge", value: float64(memStats.HeapAlloc)},
+		{name: "go_memstats_heap_inuse_bytes", help: "Bytes in in-use heap spans", kind: "gauge", value: float64(memStats.HeapInuse)},
+		{name: "go_memstats_heap_objects", help: "Number of allocated heap objects", kind: "gauge", value: float64(memStats.HeapObjects)},
+		{name: "go_memstats_sys_bytes", help: "Bytes of memory obtained from the OS", kind: "gauge", value: float64(memStats.Sys)},
+		{name: "go_memstats_alloc_bytes_total", help: "Bytes allocated for heap objects since the start", kind: "counter", value: float64(memStats.TotalAlloc)},
+		{name: "go_memstats_mallocs_total", help: "Heap objects allocated since the start", kind: "counter", value: float64(memStats.Mallocs)},
+		{name: "go_memstats_frees_total", help: "Heap objects freed since the start", kind: "counter", value: float64(memStats.Frees)},
+		{name: "go_gc_cycles_total", help: "Completed garbage collection cycles", kind: "counter", value: float64(memStats.NumGC)},
+		{name: "go_gc_pause_seconds_total", help: "Time the program has been paused by the garbage collector", kind: "counter", value: float64(memStats.PauseTotalNs) / float64(time.Second)},
+		{name: "process_start_time_seconds", help: "Unix time the server has been started", kind: "gauge", value: float64(startTime.Unix())},
+	}
+	for _, value := range values {
+		out.header(value.name, value.help, value.kind)
+		out.sample(value.name, "", value.value)
+	}
+}
+
+// promWriter writes metrics in the Prometheus text format
+type promWriter struct {
+	writer *bufio.Writer
+}
+
+func (p *promWriter) header(name, help, kind string) {
+	_, _ = p.writer.WriteString("# HELP " + name + " " + help + "\n# TYPE " + name + " " + kind + "\n")
+}
+
+func (p *promWriter) sample(name, labels string, value float64) {
+	_, _ = p.writer.WriteString(name + labels + " " + formatValue(value) + "\n")
+}
+
+// histogram writes the cumulative buckets, the sum and the count of a histogram.
+// labelPairs are added to every sample
+func (p *promWriter) histogram(name string, labelPairs []string, h *histogram) {
+	var cumulative int64
+	for i, bound := range durationBuckets {
+		cumulative += h.counts[i]
+		p.sample(name+"_bucket", labels(append(labelPairs, "le", formatValue(bound))...), float64(cumulative))
+	}
+	p.sample(name+"_bucket", labels(append(labelPairs, "le", "+Inf")...), float64(h.count))
+	p.sample(name+"_sum", labels(labelPairs...), h.sum)
+	p.sample(name+"_count", labels(labelPairs...), float64(h.count))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats pairs of label names and values, e.g. labels("code", "200") returns {code="200"}
+func labels(pairs ...string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	result := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		result = append(result, pairs[i]+`="`+labelEscaper.Replace(pairs[i+1])+`"`)
+	}
+	return "{" + strings.Join(result, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
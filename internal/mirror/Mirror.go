@@ -0,0 +1,328 @@
+package mirror
+
+import (
+	gtin "BarcodeServer/internal/barcode"
+	"BarcodeServer/internal/configuration"
+	"BarcodeServer/internal/export"
+	"BarcodeServer/internal/federation"
+	"BarcodeServer/internal/import/backup"
+	"BarcodeServer/internal/language"
+	"BarcodeServer/internal/storage"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatusPath is the path of the replication status of a mirror
+const StatusPath = "/mirror/status"
+
+const (
+	// stateCursor is the sync state key of the last change that has been replicated
+	stateCursor = "mirror:cursor"
+	// stateBootstrapped is the sync state key of the unix time the full export has been downloaded
+	stateBootstrapped = "mirror:bootstrapped"
+)
+
+// requestTimeout is the maximum time a single request to the upstream server may take,
+// the download of the full export during the bootstrap takes longer
+const requestTimeout = time.Minute
+
+// bootstrapTimeout is the maximum time the download of the full export may take
+const bootstrapTimeout = 6 * time.Hour
+
+// changesPerRequest is the amount of changes requested from the upstream server at once
+const changesPerRequest = 10000
+
+// Status contains the replication state of the mirror
+type Status struct {
+	Upstream string
+	// Cursor is the last change of the upstream server that has been replicated
+	Cursor int64
+	// UpstreamCursor is the latest change of the upstream server during the last synchronisation
+	UpstreamCursor int64
+	// PendingChanges is the amount of changes that have not been replicated yet
+	PendingChanges int64
+	LastSync       string
+	// LagSeconds is the time since the mirror has last replicated all changes, -1 if it never has
+	LagSeconds int64
+	LastError  string
+}
+
+var statusMutex sync.Mutex
+var status = Status{LastSync: "Never"}
+var lastCaughtUp time.Time
+
+// IsEnabled returns true if the server is a read-only mirror of an upstream server
+func IsEnabled() bool {
+	return configuration.Get().MirrorUpstream != ""
+}
+
+// Start replicates the upstream server in the configured interval. If no full
+// export has been downloaded yet, it is downloaded first
+func Start(store storage.Store) {
+	interval := time.Duration(configuration.Get().MirrorPollInterval) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	for {
+		err := Replicate(store)
+		statusMutex.Lock()
+		status.LastSync = time.Now().Format(time.RFC1123)
+		status.LastError = ""
+		if err != nil {
+			status.LastError = err.Error()
+			log.Println("Unable to replicate upstream server: " + err.Error())
+		}
+		statusMutex.Unlock()
+		time.Sleep(interval)
+	}
+}
+
+// GetStatus returns the current replication state
+func GetStatus() Status {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+	result := status
+	result.Upstream = configuration.Get().MirrorUpstream
+	result.LagSeconds = -1
+	if !lastCaughtUp.IsZero() {
+		result.LagSeconds = int64(time.Since(lastCaughtUp).Seconds())
+	}
+	result.PendingChanges = result.UpstreamCursor - result.Cursor
+	if result.PendingChanges < 0 {
+		result.PendingChanges = 0
+	}
+	return result
+}
+
+// NewForwardingProxy returns a handler that forwards requests to the upstream server
+func NewForwardingProxy() http.Handler {
+	upstream, err := url.Parse(configuration.Get().MirrorUpstream)
+	if err != nil {
+		log.Fatal("Invalid MirrorUpstream: " + err.Error())
+	}
+	proxy := httputil.NewSingleHostReverseProxy(upstream)
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		// Required if the upstream server is behind a virtual host
+		r.Host = upstream.Host
+	}
+	return proxy
+}
+
+// Replicate downloads the full export of the upstream server if it has not been
+// downloaded yet and applies all changes since the last replicated cursor
+func Replicate(store storage.Store) error {
+	if store.GetSyncState(stateBootstrapped) == 0 {
+		err := bootstrap(store)
+		if err != nil {
+			return err
+		}
+	}
+	return followChanges(store)
+}
+
+// bootstrap replaces all local barcodes with the full export of the upstream
+// server. Local barcodes that are not part of the export have been deleted
+// upstream and are removed after the download has completed. Changes that
+// happen during the download are replicated afterwards, as the export
+// contains the cursor of the change log at its start. All names are requested,
+// including the ones that are not listed, as the local barcodes are replaced
+func bootstrap(store storage.Store) error {
+	log.Println("Downloading full export from upstream server")
+	response, err := request(federation.ExportPath+"?format="+export.FormatNdjson+"&minscore=-inf", bootstrapTimeout)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	cursor, err := strconv.ParseInt(response.Header.Get(federation.HeaderCursor), 10, 64)
+	if err != nil {
+		return errors.New("upstream server did not send a cursor")
+	}
+	decoder := json.NewDecoder(response.Body)
+	upstreamBarcodes := make(map[string]bool)
+	for {
+		var record storage.ExportRecord
+		err = decoder.Decode(&record)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		sanitized, ok := sanitizeRecord(record)
+		if ok {
+			store.ImportBarcode(sanitized, storage.ImportReplace)
+			upstreamBarcodes[sanitized.Barcode] = true
+		}
+	}
+	err = removeMissingBarcodes(store, upstreamBarcodes)
+	if err != nil {
+		return err
+	}
+	store.SetSyncState(stateCursor, cursor)
+	store.SetSyncState(stateBootstrapped, time.Now().Unix())
+	log.Println("Full export downloaded, following changes from cursor " + strconv.FormatInt(cursor, 10))
+	return nil
+}
+
+// removeMissingBarcodes deletes all local barcodes that are not part of upstreamBarcodes
+func removeMissingBarcodes(store storage.Store, upstreamBarcodes map[string]bool) error {
+	var missing []string
+	err := store.ExportBarcodes(storage.ExportFilter{MinScore: math.Inf(-1)}, func(record storage.ExportRecord) error {
+		if !upstreamBarcodes[record.Barcode] {
+			missing = append(missing, record.Barcode)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, barcode := range missing {
+		store.DeleteBarcode(barcode)
+	}
+	if len(missing) > 0 {
+		log.Println("Removed " + strconv.Itoa(len(missing)) + " barcodes that have been deleted on the upstream server")
+	}
+	return nil
+}
+
+// changesResponse is the response of federation.ChangesPath
+type changesResponse struct {
+	Changes      []storage.Change
+	NextCursor   int64
+	HasMore      bool
+	LatestCursor int64
+}
+
+// followChanges requests all changes since the last replicated cursor
+func followChanges(store storage.Store) error {
+	for {
+		cursor := store.GetSyncState(stateCursor)
+		response, err := request(federation.ChangesPath+"?since="+strconv.FormatInt(cursor, 10)+"&limit="+strconv.Itoa(changesPerRequest), requestTimeout)
+		if err != nil {
+			var gone errGone
+			if errors.As(err, &gone) {
+				// The upstream server does not store the changes anymore
+				store.SetSyncState(stateBootstrapped, 0)
+			}
+			return err
+		}
+		var changes changesResponse
+		err = json.NewDecoder(response.Body).Decode(&changes)
+		response.Body.Close()
+		if err != nil {
+			return err
+		}
+		for _, change := range changes.Changes {
+			applyChange(store, change)
+		}
+		store.SetSyncState(stateCursor, changes.NextCursor)
+
+		statusMutex.Lock()
+		status.Cursor = changes.NextCursor
+		status.UpstreamCursor = changes.LatestCursor
+		if !changes.HasMore {
+			lastCaughtUp = time.Now()
+		}
+		statusMutex.Unlock()
+		if !changes.HasMore {
+			return nil
+		}
+	}
+}
+
+// applyChange sets the name of the change to the score it has on the upstream server
+func applyChange(store storage.Store, change storage.Change) {
+	if change.Type == storage.ChangeClear {
+		// Not normalized, as the upstream server removes non-canonical barcodes during its migration
+		store.DeleteBarcode(change.Barcode)
+		return
+	}
+	if change.Field != "" {
+		applyMetadataChange(store, change)
+		return
+	}
+	sanitized, ok := backup.SanitizeName(change.Barcode, change.Name)
+	if !ok {
+		return
+	}
+	store.ImportBarcode(storage.ExportRecord{
+		Barcode: sanitized.Barcode,
+		Names:   []storage.ExportName{{Name: sanitized.Name, Score: change.Score, Language: language.Normalize(change.Language)}},
+	}, storage.ImportUpdate)
+}
+
+// applyMetadataChange stores the score of a changed metadata value, which is sent in the name of the change
+func applyMetadataChange(store storage.Store, change storage.Change) {
+	barcode, ok := gtin.Normalize(change.Barcode)
+	if !ok {
+		return
+	}
+	metadata := backup.SanitizeMetadata([]storage.ExportMetadata{{Field: change.Field, Value: change.Name, Score: change.Score}})
+	if len(metadata) == 0 {
+		return
+	}
+	store.ImportBarcode(storage.ExportRecord{Barcode: barcode, Metadata: metadata}, storage.ImportUpdate)
+}
+
+// sanitizeRecord validates the barcode and all names and metadata of an exported barcode.
+// Scores, reports and sources are kept, so that the mirror is an exact copy of the upstream
+// server. Returns false if neither a name nor metadata is valid
+func sanitizeRecord(record storage.ExportRecord) (storage.ExportRecord, bool) {
+	barcode, ok := gtin.Normalize(record.Barcode)
+	if !ok {
+		return storage.ExportRecord{}, false
+	}
+	result := storage.ExportRecord{Barcode: barcode, Hits: record.Hits, Metadata: backup.SanitizeMetadata(record.Metadata)}
+	for _, name := range record.Names {
+		sanitized, ok := backup.SanitizeName(barcode, name.Name)
+		if !ok {
+			continue
+		}
+		name.Name = sanitized.Name
+		name.Language = language.Normalize(name.Language)
+		result.Names = append(result.Names, name)
+	}
+	return result, len(result.Names) > 0 || len(result.Metadata) > 0
+}
+
+// errGone is returned if the upstream server does not store the requested changes anymore
+type errGone struct{}
+
+func (errGone) Error() string {
+	return "changes are not available anymore, downloading full export"
+}
+
+func request(path string, timeout time.Duration) (*http.Response, error) {
+	client := http.Client{Timeout: timeout}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(configuration.Get().MirrorUpstream, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Barcode Buddy Federation")
+	req.Header.Set(federation.HeaderKey, configuration.Get().MirrorKey)
+	response, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == http.StatusGone {
+		response.Body.Close()
+		return nil, errGone{}
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, errors.New("upstream server returned status " + response.Status)
+	}
+	return response, nil
+}
@@ -0,0 +1,18 @@
+package mirror
+
+import (
+	"BarcodeServer/internal/storage"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeRecord(t *testing.T) {
+	_, ok := sanitizeRecord(storage.ExportRecord{Barcode: "4006040000013", Names: []storage.ExportName{{Name: "x"}}})
+	if ok {
+		t.Error("record without valid names or metadata has been kept")
+	}
+	record, ok := sanitizeRecord(storage.ExportRecord{Barcode: "012345678905", Names: []storage.ExportName{{Name: "Peanut &amp; butter"}}})
+	if !ok || record.Barcode != "0012345678905" || !strings.Contains(record.Names[0].Name, "Peanut") {
+		t.Errorf("unexpected record %+v", record)
+	}
+}
@@ -0,0 +1,341 @@
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version is the version of the OpenAPI specification the documents follow
+const Version = "3.0.3"
+
+// Parameter locations
+const (
+	InHeader = "header"
+	InQuery  = "query"
+)
+
+// Document is an OpenAPI description of an API. Schemas are generated from the
+// Go types of the request and response bodies, so that the description always
+// matches the JSON the handlers encode and decode
+type Document struct {
+	OpenApi    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+	types      map[string]reflect.Type
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme is an API key that is sent as header or cookie
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	In          string `json:"in"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem contains all operations of a path, keyed by the lower case HTTP method
+type PathItem map[string]*operationObject
+
+// Schema is a JSON schema. It can be passed to an Operation instead of a Go value
+// for bodies that are not JSON, e.g. uploaded files
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// Operation describes a single HTTP method of a path
+type Operation struct {
+	Method      string
+	Summary     string
+	Description string
+	Tag         string
+	Parameters  []Parameter
+	// Security contains the names of the security schemes of which one is required
+	Security []string
+	// RequestBody is nil if the operation does not expect a body
+	RequestBody *Body
+	Responses   []Response
+}
+
+type Parameter struct {
+	Name        string
+	In          string
+	Description string
+	Required    bool
+	// Schema is a Go value of the type of the parameter, string if nil
+	Schema interface{}
+}
+
+// Body is the content of a request or a response
+type Body struct {
+	ContentType string
+	// Schema is a zero value of the Go type that is encoded as JSON, or a Schema
+	Schema interface{}
+}
+
+type Response struct {
+	Status      int
+	Description string
+	// Headers contains the names and descriptions of response headers
+	Headers map[string]string
+	// Body is nil if the response has no content
+	Body *Body
+}
+
+type operationObject struct {
+	Summary     string                    `json:"summary,omitempty"`
+	Description string                    `json:"description,omitempty"`
+	Tags        []string                  `json:"tags,omitempty"`
+	Parameters  []parameterObject         `json:"parameters,omitempty"`
+	Security    []map[string][]string     `json:"security,omitempty"`
+	RequestBody *requestBodyObject        `json:"requestBody,omitempty"`
+	Responses   map[string]responseObject `json:"responses"`
+}
+
+type parameterObject struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type requestBodyObject struct {
+	Required bool                       `json:"required"`
+	Content  map[string]mediaTypeObject `json:"content"`
+}
+
+type responseObject struct {
+	Description string                     `json:"description"`
+	Headers     map[string]headerObject    `json:"headers,omitempty"`
+	Content     map[string]mediaTypeObject `json:"content,omitempty"`
+}
+
+type headerObject struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type mediaTypeObject struct {
+	Schema *Schema `json:"schema"`
+}
+
+// New returns an empty document
+func New(info Info) *Document {
+	return &Document{
+		OpenApi: Version,
+		Info:    info,
+		Paths:   make(map[string]PathItem),
+		Components: Components{
+			Schemas:         make(map[string]*Schema),
+			SecuritySchemes: make(map[string]SecurityScheme),
+		},
+		types: make(map[string]reflect.Type),
+	}
+}
+
+// AddSecurityScheme registers a security scheme that operations can refer to by its name
+func (d *Document) AddSecurityScheme(name string, scheme SecurityScheme) {
+	d.Components.SecuritySchemes[name] = scheme
+}
+
+// AddPath adds the operations of a path. Panics if two different Go types with
+// the same name are used, as their schemas would overwrite each other
+func (d *Document) AddPath(path string, operations []Operation) {
+	item, ok := d.Paths[path]
+	if !ok {
+		item = make(PathItem)
+		d.Paths[path] = item
+	}
+	for _, operation := range operations {
+		item[strings.ToLower(operation.Method)] = d.newOperation(operation)
+	}
+}
+
+func (d *Document) newOperation(operation Operation) *operationObject {
+	result := &operationObject{
+		Summary:     operation.Summary,
+		Description: operation.Description,
+		Responses:   make(map[string]responseObject),
+	}
+	if operation.Tag != "" {
+		result.Tags = []string{operation.Tag}
+	}
+	for _, parameter := range operation.Parameters {
+		schema := &Schema{Type: "string"}
+		if parameter.Schema != nil {
+			schema = d.schemaOf(parameter.Schema)
+		}
+		result.Parameters = append(result.Parameters, parameterObject{
+			Name:        parameter.Name,
+			In:          parameter.In,
+			Description: parameter.Description,
+			Required:    parameter.Required,
+			Schema:      schema,
+		})
+	}
+	for _, name := range operation.Security {
+		result.Security = append(result.Security, map[string][]string{name: {}})
+	}
+	if operation.RequestBody != nil {
+		result.RequestBody = &requestBodyObject{
+			Required: true,
+			Content:  d.content(*operation.RequestBody),
+		}
+	}
+	for _, response := range operation.Responses {
+		object := responseObject{Description: response.Description}
+		if object.Description == "" {
+			object.Description = http.StatusText(response.Status)
+		}
+		if len(response.Headers) > 0 {
+			object.Headers = make(map[string]headerObject)
+			for name, description := range response.Headers {
+				object.Headers[name] = headerObject{Description: description, Schema: &Schema{Type: "string"}}
+			}
+		}
+		if response.Body != nil {
+			object.Content = d.content(*response.Body)
+		}
+		result.Responses[strconv.Itoa(response.Status)] = object
+	}
+	return result
+}
+
+func (d *Document) content(body Body) map[string]mediaTypeObject {
+	return map[string]mediaTypeObject{body.ContentType: {Schema: d.schemaOf(body.Schema)}}
+}
+
+// oneOf is a body that is one of several Go types
+type oneOf []interface{}
+
+// OneOf returns a body schema that matches exactly one of the Go values
+func OneOf(values ...interface{}) interface{} {
+	return oneOf(values)
+}
+
+// schemaOf returns the schema of a Go value, or the value itself if it is a Schema
+func (d *Document) schemaOf(value interface{}) *Schema {
+	switch schema := value.(type) {
+	case Schema:
+		return &schema
+	case *Schema:
+		return schema
+	case oneOf:
+		result := &Schema{}
+		for _, value := range schema {
+			result.OneOf = append(result.OneOf, d.schemaOf(value))
+		}
+		return result
+	}
+	return d.schemaOfType(reflect.TypeOf(value))
+}
+
+// schemaOfType returns the schema of a Go type. Named structs are added to the
+// components and referenced, so that every type is only described once
+func (d *Document) schemaOfType(t reflect.Type) *Schema {
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := d.schemaOfType(t.Elem())
+		if schema.Ref != "" {
+			// Siblings of $ref are ignored in OpenAPI 3.0
+			return schema
+		}
+		schema.Nullable = true
+		return schema
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: d.schemaOfType(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.schemaOfType(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.structSchema(t)
+		}
+		d.addComponent(t)
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	}
+	return &Schema{}
+}
+
+func (d *Document) addComponent(t reflect.Type) {
+	existing, ok := d.types[t.Name()]
+	if ok {
+		if existing != t {
+			panic("openapi: different types with the same name " + t.Name() + ": " + existing.PkgPath() + ", " + t.PkgPath())
+		}
+		return
+	}
+	d.types[t.Name()] = t
+	// Registered before the properties are generated, to support recursive types
+	d.Components.Schemas[t.Name()] = &Schema{}
+	*d.Components.Schemas[t.Name()] = *d.structSchema(t)
+}
+
+// structSchema describes all exported fields with the name of their json tag.
+// Fields are required unless they are omitted when empty
+func (d *Document) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" && options == "" {
+			continue
+		}
+		if name == "" && field.Anonymous && field.Type.Kind() == reflect.Struct {
+			// Embedded structs are flattened by encoding/json
+			embedded := d.structSchema(field.Type)
+			for property, propertySchema := range embedded.Properties {
+				schema.Properties[property] = propertySchema
+			}
+			schema.Required = append(schema.Required, embedded.Required...)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = d.schemaOfType(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	sort.Strings(schema.Required)
+	return schema
+}
@@ -0,0 +1,32 @@
+package storage
+
+import "time"
+
+// dayLayout is the format of the keys of daily buckets, which sort chronologically
+const dayLayout = "2006-01-02"
+
+// DayKey returns the key of the daily bucket that contains t, in the local time of the server
+func DayKey(t time.Time) string {
+	return t.Format(dayLayout)
+}
+
+// LastDays returns the keys of the last days daily buckets, starting with today
+func LastDays(days int) []string {
+	return DayRange(0, days)
+}
+
+// DayRange returns the keys of days daily buckets, starting with the day offset days ago
+// and going back in time. DayRange(7, 7) returns the week before the last 7 days
+func DayRange(offset, days int) []string {
+	result := make([]string, days)
+	now := time.Now()
+	for i := range result {
+		result[i] = DayKey(now.AddDate(0, 0, -offset-i))
+	}
+	return result
+}
+
+// IsExpiredDay returns true if the daily bucket day is older than the last retentionDays days
+func IsExpiredDay(day string, retentionDays int) bool {
+	return day < DayKey(time.Now().AddDate(0, 0, 1-retentionDays))
+}
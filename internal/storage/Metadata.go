@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"html/template"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Fields of the structured metadata of a barcode. Every field stores multiple
+// values with a score, like the names of a barcode
+const (
+	FieldBrand       = "brand"
+	FieldProductName = "product"
+	// FieldQuantity contains the quantity and the unit, e.g. "500 g", as they
+	// are only meaningful together
+	FieldQuantity = "quantity"
+	FieldCategory = "category"
+	// FieldLanguage is the ISO 639-1 code of the language of the product texts
+	FieldLanguage = "language"
+)
+
+// MetadataFields contains all fields of the structured metadata
+var MetadataFields = []string{FieldBrand, FieldProductName, FieldQuantity, FieldCategory, FieldLanguage}
+
+// maxUnitLength is the maximum length of the unit of a quantity
+const maxUnitLength = 10
+
+// Metadata contains structured data of a product. All fields are optional
+type Metadata struct {
+	Brand       string  `json:"Brand,omitempty"`
+	ProductName string  `json:"ProductName,omitempty"`
+	Quantity    float64 `json:"Quantity,omitempty"`
+	// Unit of the quantity, e.g. "g" or "ml"
+	Unit     string `json:"Unit,omitempty"`
+	Category string `json:"Category,omitempty"`
+	// Language is the ISO 639-1 code of the language of the product texts, e.g. "de"
+	Language string `json:"Language,omitempty"`
+}
+
+// IsEmpty returns true if no field is set
+func (m Metadata) IsEmpty() bool {
+	return m == Metadata{}
+}
+
+// Values returns the stored value of every field that is set
+func (m Metadata) Values() map[string]string {
+	result := make(map[string]string)
+	values := map[string]string{
+		FieldBrand:       m.Brand,
+		FieldProductName: m.ProductName,
+		FieldQuantity:    FormatQuantity(m.Quantity, m.Unit),
+		FieldCategory:    m.Category,
+		FieldLanguage:    m.Language,
+	}
+	for field, value := range values {
+		if value != "" {
+			result[field] = value
+		}
+	}
+	return result
+}
+
+// set stores the value of a field. Unknown fields are ignored
+func (m *Metadata) set(field, value string) {
+	switch field {
+	case FieldBrand:
+		m.Brand = value
+	case FieldProductName:
+		m.ProductName = value
+	case FieldQuantity:
+		m.Quantity, m.Unit, _ = ParseQuantity(value)
+	case FieldCategory:
+		m.Category = value
+	case FieldLanguage:
+		m.Language = value
+	}
+}
+
+// FormatQuantity returns the stored value of a quantity, e.g. "500 g". Returns
+// an empty string if the quantity is not set
+func FormatQuantity(quantity float64, unit string) string {
+	if quantity <= 0 {
+		return ""
+	}
+	if unit == "" {
+		return FormatScore(quantity)
+	}
+	return FormatScore(quantity) + " " + unit
+}
+
+// ParseQuantity splits a quantity like "500 g" or "1.5l" into its amount and
+// unit. Returns false if it does not start with a positive number
+func ParseQuantity(value string) (float64, string, bool) {
+	value = strings.TrimSpace(strings.ReplaceAll(value, ",", "."))
+	end := 0
+	for end < len(value) && (value[end] == '.' || (value[end] >= '0' && value[end] <= '9')) {
+		end++
+	}
+	quantity, err := strconv.ParseFloat(value[:end], 64)
+	if err != nil || quantity <= 0 || math.IsInf(quantity, 0) {
+		return 0, "", false
+	}
+	return quantity, strings.ToLower(strings.TrimSpace(value[end:])), true
+}
+
+// SanitizeMetadata escapes all fields and removes the fields that are not valid
+func SanitizeMetadata(metadata Metadata) Metadata {
+	result := Metadata{}
+	for field, value := range metadata.Values() {
+		sanitized, ok := SanitizeMetadataValue(field, value)
+		if ok {
+			result.set(field, sanitized)
+		}
+	}
+	return result
+}
+
+// SanitizeMetadataValue escapes the value of a field. Returns false if the field
+// does not exist or the value is not valid
+func SanitizeMetadataValue(field, value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	switch field {
+	case FieldBrand, FieldProductName, FieldCategory:
+		value = template.HTMLEscapeString(value)
+		return value, len(value) > 0 && len(value) < 90
+	case FieldQuantity:
+		quantity, unit, ok := ParseQuantity(value)
+		if !ok || quantity >= 1000000 || len(unit) > maxUnitLength || !isLetters(unit) {
+			return "", false
+		}
+		return FormatQuantity(quantity, unit), true
+	case FieldLanguage:
+		value = strings.ToLower(value)
+		return value, len(value) == 2 && isLetters(value)
+	}
+	return "", false
+}
+
+func isLetters(value string) bool {
+	for _, char := range value {
+		if char < 'a' || char > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+// ExportMetadata is a metadata value of an ExportRecord
+type ExportMetadata struct {
+	Field  string  `json:"Field"`
+	Value  string  `json:"Value"`
+	Score  float64 `json:"Score"`
+	Source string  `json:"Source,omitempty"`
+}
+
+// MetadataKey returns the member under which a value is stored in the sorted
+// set of all metadata of a barcode
+func MetadataKey(field, value string) string {
+	return field + ":" + value
+}
+
+// SplitMetadataKey returns the field and the value of a key created by MetadataKey
+func SplitMetadataKey(key string) (string, string) {
+	field, value, _ := strings.Cut(key, ":")
+	return field, value
+}
+
+// NewExportMetadata returns all metadata values ordered by their score. The keys
+// of scores and sources have been created by MetadataKey
+func NewExportMetadata(scores map[string]float64, sources map[string]string) []ExportMetadata {
+	sorted := SortByScore(scores, math.Inf(-1))
+	result := make([]ExportMetadata, 0, len(sorted))
+	for _, entry := range sorted {
+		field, value := SplitMetadataKey(entry.Member)
+		result = append(result, ExportMetadata{
+			Field:  field,
+			Value:  value,
+			Score:  entry.Score,
+			Source: sources[entry.Member],
+		})
+	}
+	return result
+}
+
+// TopMetadata returns the value with the highest score of every field. Values
+// with a score below MinScoreListed are not returned
+func TopMetadata(scores map[string]float64) Metadata {
+	result := Metadata{}
+	isSet := make(map[string]bool)
+	for _, entry := range SortByScore(scores, MinScoreListed) {
+		field, value := SplitMetadataKey(entry.Member)
+		if !isSet[field] {
+			result.set(field, value)
+			isSet[field] = true
+		}
+	}
+	return result
+}
+
+// TopMetadata returns the value with the highest score of every field of the record
+func (r ExportRecord) TopMetadata() Metadata {
+	scores := make(map[string]float64, len(r.Metadata))
+	for _, metadata := range r.Metadata {
+		scores[MetadataKey(metadata.Field, metadata.Value)] = metadata.Score
+	}
+	return TopMetadata(scores)
+}
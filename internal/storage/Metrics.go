@@ -0,0 +1,18 @@
+package storage
+
+// MetricsSnapshot contains the statistics of the server at a point in time
+type MetricsSnapshot struct {
+	// Time is the unix time the snapshot has been taken
+	Time          int64 `json:"Time"`
+	TotalBarcodes int   `json:"TotalBarcodes"`
+	Users         int   `json:"Users"`
+	ActiveUsers   int   `json:"ActiveUsers"`
+	TotalVotes    int   `json:"TotalVotes"`
+	TotalReports  int   `json:"TotalReports"`
+	// RamUsage is the result of GetRamUsage in bytes
+	RamUsage uint64 `json:"RamUsage"`
+	// Counters contains the events since the previous snapshot, e.g. uploaded barcodes
+	Counters map[string]int64 `json:"Counters,omitempty"`
+	// Requests contains the requests per endpoint since the previous snapshot
+	Requests map[string]int64 `json:"Requests,omitempty"`
+}
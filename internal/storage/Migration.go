@@ -0,0 +1,170 @@
+package storage
+
+import (
+	gtin "BarcodeServer/internal/barcode"
+	"log"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	// migrationRestricted is the sync state key that is set after all restricted codes have been removed
+	migrationRestricted = "migration:restricted"
+	// migrationGtin is the sync state key that is set after all barcodes have been normalized
+	migrationGtin = "migration:gtin"
+)
+
+// migrationLogInterval is the amount of migrated barcodes after which the progress is logged
+const migrationLogInterval = 10000
+
+// allRecords exports all names, including names that have been removed by an
+// admin, so that they stay removed after a migration
+var allRecords = ExportFilter{MinScore: math.Inf(-1)}
+
+// Migrate converts barcodes that have been stored by older versions. Every
+// migration runs only once per store. It must finish before the server starts,
+// as votes that are stored during the migration would be overwritten
+func Migrate(store Store) {
+	if store.GetSyncState(migrationRestricted) == 0 {
+		removeRestrictedBarcodes(store)
+	}
+	if store.GetSyncState(migrationGtin) == 0 {
+		normalizeBarcodes(store)
+	}
+}
+
+// removeRestrictedBarcodes deletes all store-internal and variable measure
+// codes, as they identify different products in different stores
+func removeRestrictedBarcodes(store Store) {
+	log.Println("Removing stored restricted circulation barcodes")
+	var restricted []string
+	err := store.ExportBarcodes(allRecords, func(record ExportRecord) error {
+		code, err := gtin.Parse(record.Barcode)
+		if err == nil && !code.IsShared() {
+			restricted = append(restricted, record.Barcode)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("Unable to remove restricted barcodes: " + err.Error())
+		return
+	}
+	for i, barcode := range restricted {
+		store.DeleteBarcode(barcode)
+		if (i+1)%migrationLogInterval == 0 {
+			log.Println("Removed " + strconv.Itoa(i+1) + " of " + strconv.Itoa(len(restricted)) + " restricted barcodes")
+		}
+	}
+	if len(restricted) > 0 {
+		log.Println("Removed " + strconv.Itoa(len(restricted)) + " store-internal or variable measure barcodes")
+	}
+	store.SetSyncState(migrationRestricted, time.Now().Unix())
+}
+
+// normalizeBarcodes merges all barcodes that have been stored with a
+// non-canonical GTIN, e.g. as UPC-A or as GTIN-14 with a leading zero, into
+// their canonical barcode. The scores, reports and hits of all forms of a
+// barcode are summed up, as they have been counted separately before
+func normalizeBarcodes(store Store) {
+	log.Println("Normalizing stored barcodes")
+	merged := make(map[string]ExportRecord)
+	var duplicates []string
+	invalid := 0
+	err := store.ExportBarcodes(allRecords, func(record ExportRecord) error {
+		normalized, ok := gtin.Normalize(record.Barcode)
+		if !ok {
+			invalid++
+		} else if normalized != record.Barcode {
+			duplicates = append(duplicates, record.Barcode)
+			record.Barcode = normalized
+			merged[normalized] = sumRecords(merged[normalized], record)
+		}
+		return nil
+	})
+	if err == nil && len(merged) > 0 {
+		// The canonical barcodes are only known after all duplicates have been found
+		err = store.ExportBarcodes(allRecords, func(record ExportRecord) error {
+			if duplicate, ok := merged[record.Barcode]; ok {
+				merged[record.Barcode] = sumRecords(record, duplicate)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		log.Println("Unable to normalize barcodes: " + err.Error())
+		return
+	}
+	processed := 0
+	for _, record := range merged {
+		store.ImportBarcode(record, ImportUpdate)
+		processed++
+		if processed%migrationLogInterval == 0 {
+			log.Println("Normalized " + strconv.Itoa(processed) + " of " + strconv.Itoa(len(merged)) + " barcodes")
+		}
+	}
+	for _, barcode := range duplicates {
+		store.DeleteBarcode(barcode)
+	}
+	if len(duplicates) > 0 {
+		log.Println("Merged " + strconv.Itoa(len(duplicates)) + " barcodes into their canonical GTIN")
+	}
+	if invalid > 0 {
+		log.Println(strconv.Itoa(invalid) + " stored barcodes are not valid GTINs and cannot be looked up anymore")
+	}
+	store.SetSyncState(migrationGtin, time.Now().Unix())
+}
+
+// sumRecords combines two records of the same barcode. Scores, reports and hits
+// are added up, except for names and metadata that have been removed by an admin,
+// which keep their negative score
+func sumRecords(record, other ExportRecord) ExportRecord {
+	if record.Barcode == "" {
+		return other
+	}
+	result := ExportRecord{Barcode: record.Barcode, Hits: record.Hits + other.Hits, Updated: record.Updated}
+	if other.Updated > result.Updated {
+		result.Updated = other.Updated
+	}
+	names := make(map[string]int)
+	for _, name := range append(record.Names, other.Names...) {
+		index, exists := names[name.Name]
+		if !exists {
+			names[name.Name] = len(result.Names)
+			result.Names = append(result.Names, name)
+			continue
+		}
+		summed := &result.Names[index]
+		summed.Score = sumScores(summed.Score, name.Score)
+		summed.Reports += name.Reports
+		if summed.Source == "" {
+			summed.Source = name.Source
+		}
+		if summed.Language == "" {
+			summed.Language = name.Language
+		}
+	}
+	metadata := make(map[string]int)
+	for _, value := range append(record.Metadata, other.Metadata...) {
+		key := MetadataKey(value.Field, value.Value)
+		index, exists := metadata[key]
+		if !exists {
+			metadata[key] = len(result.Metadata)
+			result.Metadata = append(result.Metadata, value)
+			continue
+		}
+		summed := &result.Metadata[index]
+		summed.Score = sumScores(summed.Score, value.Score)
+		if summed.Source == "" {
+			summed.Source = value.Source
+		}
+	}
+	return result
+}
+
+func sumScores(score, other float64) float64 {
+	if score < 0 || other < 0 {
+		return math.Min(score, other)
+	}
+	return score + other
+}
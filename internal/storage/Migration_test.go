@@ -0,0 +1,51 @@
+package storage_test
+
+import (
+	"BarcodeServer/internal/storage"
+	"BarcodeServer/internal/storage/memory"
+	"testing"
+)
+
+func TestMigrateSumsScores(t *testing.T) {
+	store := memory.New()
+	// Stored by older versions as UPC-A, GTIN-14 and EAN-13
+	store.ImportBarcode(storage.ExportRecord{Barcode: "012345678905", Hits: 2, Names: []storage.ExportName{
+		{Name: "Peanut butter", Score: 2},
+		{Name: "Removed name", Score: -1},
+	}}, storage.ImportMerge)
+	store.ImportBarcode(storage.ExportRecord{Barcode: "00012345678905", Hits: 1, Names: []storage.ExportName{
+		{Name: "Peanut butter", Score: 1},
+		{Name: "Removed name", Score: 4},
+	}}, storage.ImportMerge)
+	store.ImportBarcode(storage.ExportRecord{Barcode: "0012345678905", Hits: 3, Names: []storage.ExportName{
+		{Name: "Peanut butter", Score: 3},
+		{Name: "Crunchy peanut butter", Score: 1},
+	}, Metadata: []storage.ExportMetadata{{Field: storage.FieldBrand, Value: "Example", Score: 1}}}, storage.ImportMerge)
+
+	storage.Migrate(store)
+
+	var records []storage.ExportRecord
+	err := store.ExportBarcodes(storage.ExportFilter{MinScore: -100}, func(record storage.ExportRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Barcode != "0012345678905" {
+		t.Fatalf("expected only the canonical barcode, got %+v", records)
+	}
+	scores := make(map[string]float64)
+	for _, name := range records[0].Names {
+		scores[name.Name] = name.Score
+	}
+	if scores["Peanut butter"] != 6 || scores["Crunchy peanut butter"] != 1 || scores["Removed name"] != -1 {
+		t.Errorf("unexpected scores %v", scores)
+	}
+	if records[0].Hits != 6 || len(records[0].Metadata) != 1 {
+		t.Errorf("unexpected record %+v", records[0])
+	}
+	if store.GetTotalBarcodes() != 1 {
+		t.Errorf("expected 1 barcode, got %d", store.GetTotalBarcodes())
+	}
+}
@@ -0,0 +1,32 @@
+package storage
+
+// MissRetentionDays is the amount of days for which lookups of unknown barcodes are kept
+const MissRetentionDays = 30
+
+// MissWindows are the time windows in days for which the most requested unknown barcodes can be listed
+var MissWindows = []int{1, 7, 30}
+
+// MissedBarcode is a barcode that has been requested, but is not stored
+type MissedBarcode struct {
+	Barcode string
+	// Requests is the amount of users that requested the barcode, every user is counted once per day
+	Requests int
+}
+
+// MostRequestedMisses returns up to limit unknown barcodes that have been requested
+// most within the last days days, or all of them if limit is 0. Barcodes that have
+// been added since they were requested are skipped
+func MostRequestedMisses(store Store, days, limit int) []MissedBarcode {
+	sorted := SortByScore(store.GetLookupMisses(days), 1)
+	var result []MissedBarcode
+	for i, names := range store.GetBarcodes(Members(sorted), false) {
+		if len(names) > 0 {
+			continue
+		}
+		result = append(result, MissedBarcode{Barcode: sorted[i].Member, Requests: int(sorted[i].Score)})
+		if len(result) == limit {
+			break
+		}
+	}
+	return result
+}
@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"encoding/json"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// MinMergeSimilarity is the similarity two names of a barcode require to be proposed for a merge
+const MinMergeSimilarity = 0.85
+
+// unitPattern matches a quantity followed by a unit, e.g. "100g", "1,5 L" or "250 ML"
+var unitPattern = regexp.MustCompile(`(?i)(\d+(?:[.,]\d+)?)\s*(kg|mg|g|ml|cl|dl|l|oz|lb)\b`)
+
+// NormalizeName cleans up a name before it is stored: whitespace is collapsed and
+// units are written in lowercase and separated from the quantity, e.g. "100 g"
+func NormalizeName(name string) string {
+	name = strings.Join(strings.Fields(name), " ")
+	return unitPattern.ReplaceAllStringFunc(name, func(quantity string) string {
+		parts := unitPattern.FindStringSubmatch(quantity)
+		return parts[1] + " " + strings.ToLower(parts[2])
+	})
+}
+
+// NameKey returns the form of a name that is used for comparisons. Names with
+// the same key are treated as the same name
+func NameKey(name string) string {
+	return strings.ToLower(NormalizeName(name))
+}
+
+// FindEquivalentName returns the stored name that has the same key as an uploaded name,
+// so that both share their votes. Removed names are included, otherwise a removed name
+// could be uploaded again with a different case
+func FindEquivalentName(storedNames map[string]float64, name string) (string, bool) {
+	_, exists := storedNames[name]
+	if exists {
+		return name, true
+	}
+	key := NameKey(name)
+	for _, entry := range SortByScore(storedNames, math.Inf(-1)) {
+		if NameKey(entry.Member) == key {
+			return entry.Member, true
+		}
+	}
+	return "", false
+}
+
+// NameSimilarity returns a value between 0 and 1 that describes how similar two
+// names are, 1 means that they have the same key. Names with different numbers,
+// e.g. "Milk 1 l" and "Milk 2 l", are never similar, as they describe different products
+func NameSimilarity(a, b string) float64 {
+	keyA, keyB := NameKey(a), NameKey(b)
+	if keyA == keyB {
+		return 1
+	}
+	if strings.Join(numbers(keyA), " ") != strings.Join(numbers(keyB), " ") {
+		return 0
+	}
+	runesA, runesB := []rune(comparableText(keyA)), []rune(comparableText(keyB))
+	longest := len(runesA)
+	if len(runesB) > longest {
+		longest = len(runesB)
+	}
+	if longest == 0 {
+		return 0
+	}
+	return 1 - float64(levenshtein(runesA, runesB))/float64(longest)
+}
+
+func numbers(text string) []string {
+	return strings.FieldsFunc(text, func(char rune) bool {
+		return !unicode.IsDigit(char)
+	})
+}
+
+// comparableText removes all characters that are neither letters nor digits
+func comparableText(text string) string {
+	return strings.Map(func(char rune) rune {
+		if unicode.IsLetter(char) || unicode.IsDigit(char) {
+			return char
+		}
+		return -1
+	}, text)
+}
+
+func levenshtein(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minimum(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func minimum(values ...int) int {
+	result := values[0]
+	for _, value := range values[1:] {
+		if value < result {
+			result = value
+		}
+	}
+	return result
+}
+
+// MergeProposal proposes to merge Name into Target, as both names of the barcode are near-identical
+type MergeProposal struct {
+	// Id is the position in the list of proposals, it is only valid until the list changes
+	Id         int     `json:"-"`
+	Barcode    string  `json:"Barcode"`
+	Name       string  `json:"Name"`
+	Target     string  `json:"Target"`
+	Similarity float64 `json:"Similarity"`
+}
+
+// Key identifies the pair of names of a proposal, regardless of the direction of the merge
+func (p MergeProposal) Key() string {
+	names := []string{p.Name, p.Target}
+	sort.Strings(names)
+	key, _ := json.Marshal(append([]string{p.Barcode}, names...))
+	return string(key)
+}
+
+// Contains returns true if the name of the barcode is one of the names of the proposal
+func (p MergeProposal) Contains(barcode, name string) bool {
+	return p.Barcode == barcode && (p.Name == name || p.Target == name)
+}
+
+// FindMergeProposals compares all listed names of a barcode and proposes to merge
+// every similar name into the name with the higher score
+func FindMergeProposals(barcode string, names map[string]float64) []MergeProposal {
+	var result []MergeProposal
+	sorted := SortByScore(names, MinScoreListed)
+	for i, target := range sorted {
+		for _, name := range sorted[i+1:] {
+			similarity := NameSimilarity(name.Member, target.Member)
+			if similarity >= MinMergeSimilarity {
+				result = append(result, MergeProposal{Barcode: barcode, Name: name.Member, Target: target.Member, Similarity: similarity})
+			}
+		}
+	}
+	return result
+}
+
+// FindMergeProposalsForName proposes to merge a newly added name with all similar listed
+// names of the barcode. The new name is merged into names with the same or a higher score
+func FindMergeProposalsForName(barcode string, names map[string]float64, newName string, newScore float64) []MergeProposal {
+	var result []MergeProposal
+	for _, entry := range SortByScore(names, MinScoreListed) {
+		if entry.Member == newName {
+			continue
+		}
+		similarity := NameSimilarity(newName, entry.Member)
+		if similarity < MinMergeSimilarity {
+			continue
+		}
+		proposal := MergeProposal{Barcode: barcode, Name: newName, Target: entry.Member, Similarity: similarity}
+		if entry.Score < newScore {
+			proposal.Name, proposal.Target = entry.Member, newName
+		}
+		result = append(result, proposal)
+	}
+	return result
+}
+
+// SortMergeProposals orders proposals by descending similarity and sets their Id
+func SortMergeProposals(proposals []MergeProposal) []MergeProposal {
+	sort.Slice(proposals, func(i, j int) bool {
+		if proposals[i].Similarity != proposals[j].Similarity {
+			return proposals[i].Similarity > proposals[j].Similarity
+		}
+		return proposals[i].Key() < proposals[j].Key()
+	})
+	for i := range proposals {
+		proposals[i].Id = i
+	}
+	return proposals
+}
+
+// MergedScore returns the score of the target of a merge. Negative scores of
+// the merged name are not added, so that reported names cannot lower the target
+func MergedScore(targetScore, nameScore float64) float64 {
+	if nameScore < 0 {
+		return targetScore
+	}
+	return targetScore + nameScore
+}
+
+// ScanMergeProposals compares the names of all stored barcodes and stores the
+// proposals. Returns the amount of proposals that have been found
+func ScanMergeProposals(store Store) (int, error) {
+	var proposals []MergeProposal
+	err := store.ExportBarcodes(DefaultExportFilter, func(record ExportRecord) error {
+		if len(record.Names) < 2 {
+			return nil
+		}
+		scores := make(map[string]float64, len(record.Names))
+		for _, name := range record.Names {
+			scores[name.Name] = name.Score
+		}
+		proposals = append(proposals, FindMergeProposals(record.Barcode, scores)...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	store.AddMergeProposals(proposals)
+	return len(proposals), nil
+}
@@ -0,0 +1,85 @@
+package storage
+
+// HitRetentionDays is the amount of days for which the daily hits of barcodes are kept.
+// It covers two of the longest PopularityWindows, so that their trend can be calculated
+const HitRetentionDays = 60
+
+// PopularityWindows are the time windows in days for which popular and trending barcodes can be listed
+var PopularityWindows = []int{1, 7, 30}
+
+// IsPopularityWindow returns true if days is one of the PopularityWindows
+func IsPopularityWindow(days int) bool {
+	for _, window := range PopularityWindows {
+		if window == days {
+			return true
+		}
+	}
+	return false
+}
+
+// PopularBarcode is a barcode with its lookups within a time window and the window before
+type PopularBarcode struct {
+	Barcode string   `json:"Barcode"`
+	Names   []string `json:"Names"`
+	Hits    int      `json:"Hits"`
+	// PreviousHits are the lookups within the time window before, e.g. the week before the last 7 days
+	PreviousHits int `json:"PreviousHits"`
+}
+
+// Rise returns the increase of lookups compared to the previous time window
+func (b PopularBarcode) Rise() int {
+	return b.Hits - b.PreviousHits
+}
+
+// namesBatchSize is the amount of barcodes whose names are requested at once while filling a list
+const namesBatchSize = 100
+
+// MostPopularBarcodes returns up to limit barcodes that have been looked up most within
+// the last days days. Barcodes that are not stored are skipped
+func MostPopularBarcodes(store Store, days, limit int) []PopularBarcode {
+	hits := store.GetDailyHits(LastDays(days))
+	previousHits := store.GetDailyHits(DayRange(days, days))
+	return withNames(store, SortByScore(hits, 1), previousHits, limit)
+}
+
+// TrendingBarcodes returns up to limit barcodes with the biggest rise of lookups within
+// the last days days, compared to the days before. Barcodes that are not stored are skipped
+func TrendingBarcodes(store Store, days, limit int) []PopularBarcode {
+	hits := store.GetDailyHits(LastDays(days))
+	previousHits := store.GetDailyHits(DayRange(days, days))
+	rise := make(map[string]float64)
+	for barcode, amount := range hits {
+		if amount > previousHits[barcode] {
+			rise[barcode] = amount - previousHits[barcode]
+		}
+	}
+	result := withNames(store, SortByScore(rise, 1), previousHits, limit)
+	for i := range result {
+		result[i].Hits = int(hits[result[i].Barcode])
+	}
+	return result
+}
+
+// withNames returns up to limit of the sorted barcodes with their names, skipping
+// barcodes without names. The score of an entry is used as the amount of hits
+func withNames(store Store, sorted []ScoredEntry, previousHits map[string]float64, limit int) []PopularBarcode {
+	result := []PopularBarcode{}
+	for start := 0; start < len(sorted) && len(result) < limit; start += namesBatchSize {
+		batch := sorted[start:minimum(start+namesBatchSize, len(sorted))]
+		for i, names := range store.GetBarcodes(Members(batch), false) {
+			if len(names) == 0 {
+				continue
+			}
+			result = append(result, PopularBarcode{
+				Barcode:      batch[i].Member,
+				Names:        names,
+				Hits:         int(batch[i].Score),
+				PreviousHits: int(previousHits[batch[i].Member]),
+			})
+			if len(result) == limit {
+				break
+			}
+		}
+	}
+	return result
+}
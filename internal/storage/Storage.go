@@ -0,0 +1,472 @@
+package storage
+
+import (
+	gtin "BarcodeServer/internal/barcode"
+	"BarcodeServer/internal/language"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Store is implemented by every storage backend of the federation server
+type Store interface {
+	// LogNewRequest registers a request of the user and returns the amount of
+	// requests that were made from ipAddr today
+	LogNewRequest(ipAddr, uuid string, isUpload bool) int
+	// LogNewRequests registers amount lookups of the user at once and returns
+	// the amount of requests that were made from ipAddr today
+	LogNewRequests(ipAddr, uuid string, amount int) int
+	// GetBarcode returns all names stored for a barcode, ordered by their score.
+	// If increaseHit is set, the lookup is counted in the all-time hits and the hits of today
+	GetBarcode(barcode string, increaseHit bool) []string
+	// GetBarcodes returns the names of multiple barcodes in the order of the
+	// requested barcodes, ordered by their score
+	GetBarcodes(barcodes []string, increaseHit bool) [][]string
+	// LookupBarcodes returns the names, metadata and name languages of multiple barcodes
+	// in the order of the requested barcodes, with a single request to the backend.
+	// Every lookup is counted in the hits, barcodes without names are registered as
+	// lookup misses of uuid. Lookups of scoped barcodes are neither counted nor registered
+	LookupBarcodes(barcodes []string, uuid string) []LookupResult
+	// VoteName increases the score of a name. Returns false if ipAddr has already voted
+	VoteName(barcode, name, ipAddr string) bool
+	// ReportName decreases the score of a name and adds it to the report list.
+	// Returns false if ipAddr has already reported the name or the name does not exist
+	ReportName(barcode, name, ipAddr string) bool
+	// ProcessReport either removes the reported name or dismisses the report
+	ProcessReport(report Report, dismissReport bool)
+	// AddGrocyBarcodes stores all valid barcodes that have been uploaded, including
+	// their metadata. Source is stored for every new name and metadata value, e.g.
+	// SourceUser or SourceEdeka. The language of a name is set by NameLanguage, unless
+	// the name already has a language. A name with the same NameKey as a stored name
+	// is counted as the stored name, new names that are similar to listed names are
+	// proposed for a merge
+	AddGrocyBarcodes(barcodes GrocyBarcodes, uuid, source string)
+	// GetNameLanguages returns the language of every name that has one, in the
+	// order of the requested barcodes
+	GetNameLanguages(barcodes []string) []map[string]string
+	// GetMetadata returns the metadata value with the highest score of every field,
+	// in the order of the requested barcodes
+	GetMetadata(barcodes []string) []Metadata
+	// VoteMetadata increases the score of a metadata value, which has been
+	// validated with SanitizeMetadataValue. Returns false if ipAddr has already voted
+	VoteMetadata(barcode, field, value, ipAddr string) bool
+	// GetDailyHits returns the lookups of every barcode, summed up over the requested
+	// days. Daily hits are kept for HitRetentionDays
+	GetDailyHits(days []string) map[string]float64
+	// LogLookupMisses registers lookups of barcodes that are not stored. Every uuid
+	// is counted once per barcode and day, the counts are kept for MissRetentionDays
+	LogLookupMisses(barcodes []string, uuid string)
+	// GetLookupMisses returns the counts of LogLookupMisses of every barcode, summed
+	// up over the last days days including today
+	GetLookupMisses(days int) map[string]float64
+	// GetMergeProposals returns all proposed merges of similar names, sorted with SortMergeProposals
+	GetMergeProposals() []MergeProposal
+	// AddMergeProposals stores proposed merges, unless they already exist or have been dismissed
+	AddMergeProposals(proposals []MergeProposal)
+	// ProcessMergeProposal either merges the name of the proposal into its target or
+	// dismisses the proposal, so that it is not proposed again. When merging, the score
+	// of the name is added to the target with MergedScore and the name is removed like a reported name
+	ProcessMergeProposal(proposal MergeProposal, accept bool)
+
+	// Ping returns an error if the storage backend cannot be reached
+	Ping() error
+	// ReconcileStatistics recounts all stored data and repairs the counters used by the GetTotal functions
+	ReconcileStatistics()
+
+	GetTotalBarcodes() int
+	GetTotalVotes() int
+	GetTotalActiveUsers() int
+	GetTotalReports() int
+	GetTotalUsers() int
+	GetReportList() []Report
+	GetMostPopularBarcodes() []TopBarcode
+	// GetRamUsage returns the memory used by the stored data in bytes, or 0 if it is unknown
+	GetRamUsage() uint64
+	// DeleteBarcode removes a barcode including all of its names, metadata, hits and reports
+	DeleteBarcode(barcode string)
+	// ImportBarcode stores an exported barcode. The names and the metadata of the
+	// record must have been validated with SanitizeBarcode and SanitizeMetadataValue before.
+	// The language of a name is only stored if the name does not have a language yet
+	ImportBarcode(record ExportRecord, mode ImportMode)
+	// GetSyncState returns a value that was stored with SetSyncState, or 0 if it does not exist
+	GetSyncState(key string) int64
+	// SetSyncState stores a value, e.g. the time or cursor of the last synchronisation with another server
+	SetSyncState(key string, value int64)
+	// GetChanges returns up to limit changes with a cursor higher than since
+	GetChanges(since int64, limit int) ChangeLog
+	// AddMetricsSnapshot stores a snapshot of the statistics of the server
+	AddMetricsSnapshot(snapshot MetricsSnapshot)
+	// GetMetricsSnapshots returns all snapshots taken after the unix time since, ordered by their time
+	GetMetricsSnapshots(since int64) []MetricsSnapshot
+	// RemoveMetricsSnapshots removes all snapshots taken before the unix time before
+	RemoveMetricsSnapshots(before int64)
+	// ExportBarcodes calls exportFunc for every stored barcode that matches the filter.
+	// Stops and returns the error, if exportFunc fails
+	ExportBarcodes(filter ExportFilter, exportFunc ExportFunc) error
+}
+
+// LookupResult contains everything that is returned for a barcode by LookupBarcodes.
+// Metadata and Languages are empty if the barcode has no names
+type LookupResult struct {
+	// Names are ordered by their score
+	Names    []string
+	Metadata Metadata
+	// Languages maps every name that has a language to its language
+	Languages map[string]string
+}
+
+// ExportFunc is called by ExportBarcodes for every barcode
+type ExportFunc func(record ExportRecord) error
+
+// SourceUser is the source of names that have been uploaded by Barcode Buddy users
+const SourceUser = "user"
+
+// SourceEdeka is the source of names that have been imported from the Edeka API
+const SourceEdeka = "edeka"
+
+// ExportRecord contains all data that is stored for a barcode
+type ExportRecord struct {
+	Barcode string       `json:"Barcode"`
+	Hits    int          `json:"Hits"`
+	Updated int64        `json:"Updated"`
+	Names   []ExportName `json:"Names"`
+	// Metadata contains all structured values of the barcode, ordered by their score
+	Metadata []ExportMetadata `json:"Metadata,omitempty"`
+}
+
+// ExportName is a name of an ExportRecord, names are ordered by their score
+type ExportName struct {
+	Name    string  `json:"Name"`
+	Score   float64 `json:"Score"`
+	Reports int     `json:"Reports"`
+	Source  string  `json:"Source"`
+	// Language is the ISO 639-1 code of the name, if it is known
+	Language string `json:"Language,omitempty"`
+}
+
+// MaxStoredChanges is the amount of changes that are kept in the change log.
+// Older changes are removed, clients requesting them have to download a full export
+const MaxStoredChanges = 1000000
+
+// Types of changes in the change log
+const (
+	// ChangeAdd is a name that has been uploaded
+	ChangeAdd = "add"
+	// ChangeVote is a name that has been voted for
+	ChangeVote = "vote"
+	// ChangeReport is a name that has been reported
+	ChangeReport = "report"
+	// ChangeRemove is a reported name that has been removed by an admin
+	ChangeRemove = "remove"
+	// ChangeDismiss is a reported name of which the reports have been dismissed by an admin
+	ChangeDismiss = "dismiss"
+	// ChangeImport is a name that has been imported by an admin or received from a peer
+	ChangeImport = "import"
+	// ChangeClear is a barcode of which all names have been removed before an import
+	ChangeClear = "clear"
+	// ChangeMerge is a name that received the score of a similar name, which has been
+	// merged into it by an admin. The merged name is logged with ChangeRemove
+	ChangeMerge = "merge"
+)
+
+// Change is an entry of the change log. Score is the score of the name after the change.
+// If Field is set, the change affects a metadata value, which is stored in Name
+type Change struct {
+	Cursor  int64   `json:"Cursor"`
+	Time    int64   `json:"Time"`
+	Type    string  `json:"Type"`
+	Barcode string  `json:"Barcode"`
+	Field   string  `json:"Field,omitempty"`
+	Name    string  `json:"Name,omitempty"`
+	Score   float64 `json:"Score"`
+	// Language is set for added and imported names with a known language
+	Language string `json:"Language,omitempty"`
+}
+
+// NewChange returns a change with the current time, the cursor is set by the store
+func NewChange(changeType, barcode, name string, score float64) Change {
+	return Change{
+		Time:    time.Now().Unix(),
+		Type:    changeType,
+		Barcode: barcode,
+		Name:    name,
+		Score:   score,
+	}
+}
+
+// NewNameChange returns a change of a name with the current time, including the language of the name
+func NewNameChange(changeType, barcode, name, nameLanguage string, score float64) Change {
+	change := NewChange(changeType, barcode, name, score)
+	change.Language = nameLanguage
+	return change
+}
+
+// NewMetadataChange returns a change of a metadata value with the current time
+func NewMetadataChange(changeType, barcode, field, value string, score float64) Change {
+	change := NewChange(changeType, barcode, value, score)
+	change.Field = field
+	return change
+}
+
+// ChangeLog is a page of the change log
+type ChangeLog struct {
+	Changes []Change
+	// LatestCursor is the cursor of the most recent change
+	LatestCursor int64
+	// OldestCursor is the cursor of the oldest change that is still stored
+	OldestCursor int64
+}
+
+// ImportMode defines how imported barcodes are combined with existing ones
+type ImportMode int
+
+const (
+	// ImportMerge keeps all existing names. Names that do not exist yet are
+	// added, the score of existing names is raised to the imported score
+	ImportMerge ImportMode = iota
+	// ImportReplace removes all existing names of an imported barcode first
+	ImportReplace
+	// ImportUpdate sets the score of all imported names, names that are not
+	// imported are kept. It is used to replicate changes of another server
+	ImportUpdate
+)
+
+// ImportedScore returns the score of a name after importing it with the given
+// mode. Returns false if the score does not change
+func ImportedScore(mode ImportMode, localScore float64, exists bool, importedScore float64) (float64, bool) {
+	if mode == ImportUpdate {
+		return importedScore, !exists || localScore != importedScore
+	}
+	return MergeScore(localScore, exists, importedScore)
+}
+
+// MergeScore returns the score of a name after merging it with an imported
+// score. Names with a negative local score have been reported or removed by
+// an admin and are not changed. Returns false if the score does not change
+func MergeScore(localScore float64, exists bool, importedScore float64) (float64, bool) {
+	if !exists {
+		return importedScore, true
+	}
+	if localScore < 0 || importedScore <= localScore {
+		return localScore, false
+	}
+	return importedScore, true
+}
+
+// ExportFilter limits which barcodes and names are exported
+type ExportFilter struct {
+	// MinScore is the minimum score a name requires to be exported
+	MinScore float64
+	// ChangedSince excludes all barcodes that have not been changed after this unix time, if not zero
+	ChangedSince int64
+}
+
+// DefaultExportFilter exports the same names that are returned by a lookup
+var DefaultExportFilter = ExportFilter{MinScore: MinScoreListed}
+
+// Apply removes all names and metadata values with a lower score than MinScore.
+// Returns false if the record does not match the filter or nothing is left of it.
+// Scoped barcodes never match, as they are only valid for a single user
+func (f ExportFilter) Apply(record ExportRecord) (ExportRecord, bool) {
+	if IsScopedBarcode(record.Barcode) {
+		return record, false
+	}
+	if f.ChangedSince != 0 && record.Updated <= f.ChangedSince {
+		return record, false
+	}
+	names := make([]ExportName, 0, len(record.Names))
+	for _, name := range record.Names {
+		if name.Score >= f.MinScore {
+			names = append(names, name)
+		}
+	}
+	record.Names = names
+	var metadata []ExportMetadata
+	for _, value := range record.Metadata {
+		if value.Score >= f.MinScore {
+			metadata = append(metadata, value)
+		}
+	}
+	record.Metadata = metadata
+	return record, len(names) > 0 || len(metadata) > 0
+}
+
+// NewExportNames returns the names ordered by their score, with their report count, source and language
+func NewExportNames(scores, reports map[string]float64, sources, languages map[string]string) []ExportName {
+	sorted := SortByScore(scores, math.Inf(-1))
+	result := make([]ExportName, len(sorted))
+	for i, entry := range sorted {
+		result[i] = ExportName{
+			Name:     entry.Member,
+			Score:    entry.Score,
+			Reports:  int(reports[entry.Member]),
+			Source:   sources[entry.Member],
+			Language: languages[entry.Member],
+		}
+	}
+	return result
+}
+
+// MinScoreListed is the minimum score a name requires to be returned in a lookup
+const MinScoreListed = -1
+
+// ScoreRemoved is the score of a name that has been removed by an admin. The name
+// is kept, so that uploading it again does not add it back to the list
+const ScoreRemoved = -100
+
+// AmountTopBarcodes is the amount of barcodes shown in the list of most popular barcodes
+const AmountTopBarcodes = 50
+
+// TimespanActiveUser is the time in seconds in which the user must have sent
+// a request in order to count as being active. Default is 30 days (2592000s)
+const TimespanActiveUser = 2592000
+
+// TimespanUploadLog is the time in seconds for how long the uuid of an uploader
+// is kept for a barcode. Default is 4 days (345600s)
+const TimespanUploadLog = 345600
+
+type GrocyBarcodes struct {
+	Barcodes []Barcode `json:"ServerBarcodes"`
+}
+
+type Barcode struct {
+	Barcode string `json:"Barcode"`
+	Name    string `json:"Name"`
+	// Metadata is optional, its fields are part of the barcode object in JSON.
+	// Its Language is also used as the language of the name
+	Metadata
+}
+
+type Report struct {
+	Id             int
+	BarcodeAndName string
+	ReportCount    string
+}
+
+type TopBarcode struct {
+	Barcode string
+	Hits    string
+	Names   string
+}
+
+// SanitizeBarcode normalizes an uploaded barcode to its canonical GTIN and
+// its name with NormalizeName, and escapes its name and metadata. Returns false if the barcode or its name is
+// not valid, invalid metadata fields are removed. Scoped barcodes are kept as they are
+func SanitizeBarcode(barcode Barcode) (Barcode, bool) {
+	normalized, isValidBarcode := normalizeStoredBarcode(barcode.Barcode)
+	result := Barcode{
+		Barcode:  normalized,
+		Name:     template.HTMLEscapeString(NormalizeName(barcode.Name)),
+		Metadata: SanitizeMetadata(barcode.Metadata),
+	}
+	isValid := isValidBarcode && len(result.Name) > 2 && len(result.Name) < 90
+	return result, isValid
+}
+
+// scopeSeparator separates a variable measure code from the user it is stored for
+const scopeSeparator = "@"
+
+// scopeLength is the amount of hex characters of the hashed uuid of a scoped barcode
+const scopeLength = 16
+
+// ScopedBarcode returns the key under which a variable measure code is stored for a
+// single user, as its item reference identifies different products in different stores.
+// The uuid is hashed, so that it cannot be read from the stored barcodes
+func ScopedBarcode(canonical, uuid string) string {
+	hash := sha256.Sum256([]byte(uuid))
+	return canonical + scopeSeparator + hex.EncodeToString(hash[:])[:scopeLength]
+}
+
+// IsScopedBarcode returns true if the barcode has been created with ScopedBarcode
+func IsScopedBarcode(barcode string) bool {
+	return strings.Contains(barcode, scopeSeparator)
+}
+
+// normalizeStoredBarcode returns the canonical GTIN of a shared barcode. Scoped
+// barcodes are returned unchanged if they contain a stripped variable measure code
+func normalizeStoredBarcode(barcode string) (string, bool) {
+	code, scope, isScoped := strings.Cut(barcode, scopeSeparator)
+	if !isScoped {
+		return gtin.Normalize(barcode)
+	}
+	parsed, err := gtin.Parse(code)
+	if err != nil || parsed.Circulation != gtin.VariableMeasure || parsed.Canonical != code {
+		return "", false
+	}
+	_, err = hex.DecodeString(scope)
+	return barcode, err == nil && len(scope) == scopeLength
+}
+
+// NameLanguage returns the language of an uploaded name. The language declared by
+// the uploader is used if it is set, otherwise it is detected from the name
+func NameLanguage(barcode Barcode) string {
+	if barcode.Language != "" {
+		return barcode.Language
+	}
+	return language.Detect(barcode.Name)
+}
+
+// SplitReport returns the barcode and the name of a report
+func SplitReport(report Report) (string, string) {
+	splitArray := strings.SplitN(report.BarcodeAndName, ":", 2)
+	if len(splitArray) != 2 {
+		return splitArray[0], ""
+	}
+	return splitArray[0], splitArray[1]
+}
+
+// JoinNames returns all names as a comma separated list
+func JoinNames(names []string) string {
+	var result string
+	for i, name := range names {
+		result = result + " " + name
+		if i < len(names)-1 {
+			result = result + ","
+		}
+	}
+	return result
+}
+
+// ScoredEntry is a member of a sorted set with its score
+type ScoredEntry struct {
+	Member string
+	Score  float64
+}
+
+// SortByScore returns all entries with a score of at least minScore, ordered
+// by descending score. Ties are ordered reverse lexicographically, which
+// matches the ordering of ZREVRANGEBYSCORE in Redis
+func SortByScore(entries map[string]float64, minScore float64) []ScoredEntry {
+	result := make([]ScoredEntry, 0, len(entries))
+	for member, score := range entries {
+		if score >= minScore {
+			result = append(result, ScoredEntry{Member: member, Score: score})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score > result[j].Score
+		}
+		return result[i].Member > result[j].Member
+	})
+	return result
+}
+
+// Members returns only the members of the sorted entries
+func Members(entries []ScoredEntry) []string {
+	result := make([]string, len(entries))
+	for i, entry := range entries {
+		result[i] = entry.Member
+	}
+	return result
+}
+
+// FormatScore formats a score the same way Redis does in its replies
+func FormatScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
@@ -0,0 +1,1599 @@
+package bolt
+
+import (
+	"BarcodeServer/internal/helper"
+	"BarcodeServer/internal/storage"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	bbolt "go.etcd.io/bbolt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Store is an embedded, on-disk implementation of storage.Store. It has the
+// same semantics as the Redis backend, but does not require a Redis server
+type Store struct {
+	db   *bbolt.DB
+	path string
+}
+
+var _ storage.Store = (*Store)(nil)
+
+var (
+	// bucketBarcodes contains a nested bucket for every barcode, mapping names to their score
+	bucketBarcodes = []byte("barcodes")
+	// bucketReported contains a nested bucket for every barcode, mapping reported names to their report count
+	bucketReported = []byte("reported")
+	// bucketReports maps "barcode:name" to the report count
+	bucketReports = []byte("reports")
+	// bucketHits maps barcodes to the amount of lookups
+	bucketHits = []byte("hits")
+	// bucketTopHits contains a key for the most looked up barcodes, consisting of their hits and the
+	// barcode, so that they can be listed without reading the hits of all barcodes
+	bucketTopHits = []byte("topHits")
+	// bucketVotes contains a key for every "ip:barcode:name" that has been voted
+	bucketVotes = []byte("votes")
+	// bucketReportsIp contains a key for every "ip:barcode:name" that has been reported
+	bucketReportsIp = []byte("reportsIp")
+	// bucketRequests maps request counter keys to their counter and expiry
+	bucketRequests = []byte("requests")
+	// bucketUsers maps uuids to the unix time until they count as active
+	bucketUsers = []byte("users")
+	// bucketUsersLastSeen contains a key for every active user, consisting of the unix time
+	// of the last request and the uuid, so that active users can be counted without a full scan
+	bucketUsersLastSeen = []byte("usersLastSeen")
+	// bucketUploadLog maps "barcode:name" to the uploader uuid and expiry
+	bucketUploadLog = []byte("uploadLog")
+	// bucketStats maps the keys below to counters, so that statistics do not require a full scan
+	bucketStats = []byte("stats")
+	// bucketSources contains a nested bucket for every barcode, mapping names to their source
+	bucketSources = []byte("sources")
+	// bucketUpdated maps barcodes to the unix time of their last change
+	bucketUpdated = []byte("updated")
+	// bucketSyncState maps keys to values stored with SetSyncState
+	bucketSyncState = []byte("syncState")
+	// bucketChanges maps cursors to the change log entry, the sequence of the bucket is the latest cursor
+	bucketChanges = []byte("changes")
+	// bucketLanguages contains a nested bucket for every barcode, mapping names to their language
+	bucketLanguages = []byte("languages")
+	// bucketMetadata contains a nested bucket for every barcode, mapping "field:value" to its score
+	bucketMetadata = []byte("metadata")
+	// bucketMetadataSources contains a nested bucket for every barcode, mapping "field:value" to its source
+	bucketMetadataSources = []byte("metadataSources")
+	// bucketMetadataVotes contains a key for every "ip:barcode:field:value" that has been voted
+	bucketMetadataVotes = []byte("metadataVotes")
+	// bucketDailyHits contains a nested bucket for every day, mapping barcodes to the amount of lookups
+	bucketDailyHits = []byte("dailyHits")
+	// bucketMisses contains a nested bucket for every day, mapping barcodes that have not been found to the amount of requesting uuids
+	bucketMisses = []byte("misses")
+	// bucketMissUsers contains a nested bucket for every day with a key for every "barcode:uuid" of a counted lookup miss
+	bucketMissUsers = []byte("missUsers")
+	// bucketMergeProposals maps storage.MergeProposal.Key to the JSON of every proposed merge of similar names
+	bucketMergeProposals = []byte("mergeProposals")
+	// bucketDismissedMerges contains the key of every merge proposal that has been dismissed
+	bucketDismissedMerges = []byte("dismissedMerges")
+	// bucketMetrics maps the unix time of every metrics snapshot to its JSON
+	bucketMetrics = []byte("metrics")
+)
+
+var (
+	statTotalBarcodes = []byte("barcodes")
+	statTotalVotes    = []byte("votes")
+	statTotalReports  = []byte("reports")
+	statTotalUsers    = []byte("users")
+)
+
+var allBuckets = [][]byte{bucketBarcodes, bucketReported, bucketReports, bucketHits, bucketTopHits, bucketVotes,
+	bucketReportsIp, bucketRequests, bucketUsers, bucketUsersLastSeen, bucketUploadLog, bucketStats, bucketSources, bucketUpdated, bucketSyncState, bucketChanges,
+	bucketLanguages, bucketMetadata, bucketMetadataSources, bucketMetadataVotes, bucketMergeProposals, bucketDismissedMerges,
+	bucketMisses, bucketMissUsers, bucketDailyHits, bucketMetrics}
+
+// cleanupInterval is the interval in which expired keys are removed from the database
+const cleanupInterval = time.Hour
+
+// exportPageSize is the amount of barcodes that ExportBarcodes reads per transaction
+var exportPageSize = 1000
+
+// Open opens or creates the database file at path
+func Open(path string) *Store {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		log.Fatal(err)
+	}
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		// The database file is locked while it is opened by another instance
+		log.Fatal("Unable to open database " + path + ": " + err.Error())
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range allBuckets {
+			_, err := tx.CreateBucketIfNotExists(bucket)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	store := &Store{db: db, path: path}
+	go store.startPeriodicCleanup()
+	return store
+}
+
+// Close closes the database file
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) startPeriodicCleanup() {
+	for {
+		time.Sleep(cleanupInterval)
+		s.removeExpired()
+	}
+}
+
+// removeExpired deletes all request counters, upload logs, daily buckets and
+// last seen entries of users that have expired, as well as changes that exceed
+// storage.MaxStoredChanges
+func (s *Store) removeExpired() {
+	now := time.Now().Unix()
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{bucketRequests, bucketUploadLog} {
+			bucket := tx.Bucket(name)
+			var expiredKeys [][]byte
+			_ = bucket.ForEach(func(key, value []byte) error {
+				if isExpired(value, now) {
+					expiredKeys = append(expiredKeys, key)
+				}
+				return nil
+			})
+			for _, key := range expiredKeys {
+				err := bucket.Delete(key)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		for _, name := range [][]byte{bucketMisses, bucketMissUsers} {
+			err := removeExpiredDays(tx.Bucket(name), storage.MissRetentionDays)
+			if err != nil {
+				return err
+			}
+		}
+		err := removeExpiredDays(tx.Bucket(bucketDailyHits), storage.HitRetentionDays)
+		if err != nil {
+			return err
+		}
+		err = removeInactiveUsers(tx.Bucket(bucketUsersLastSeen), now)
+		if err != nil {
+			return err
+		}
+		return trimChanges(tx.Bucket(bucketChanges))
+	})
+	if err != nil {
+		log.Println("Unable to remove expired keys: " + err.Error())
+	}
+}
+
+// removeExpiredDays deletes all nested buckets of days that are older than retentionDays
+func removeExpiredDays(bucket *bbolt.Bucket, retentionDays int) error {
+	var expiredDays [][]byte
+	_ = bucket.ForEach(func(day, _ []byte) error {
+		if storage.IsExpiredDay(string(day), retentionDays) {
+			expiredDays = append(expiredDays, day)
+		}
+		return nil
+	})
+	for _, day := range expiredDays {
+		err := bucket.DeleteBucket(day)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeInactiveUsers deletes the last seen entries of all users that are not active anymore
+func removeInactiveUsers(lastSeen *bbolt.Bucket, now int64) error {
+	minKey := uint64ToBytes(uint64(now - storage.TimespanActiveUser))
+	var expiredKeys [][]byte
+	cursor := lastSeen.Cursor()
+	for key, _ := cursor.First(); key != nil && bytes.Compare(key, minKey) < 0; key, _ = cursor.Next() {
+		expiredKeys = append(expiredKeys, key)
+	}
+	for _, key := range expiredKeys {
+		err := lastSeen.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) LogNewRequest(ipAddr, uuid string, isUpload bool) int {
+	keyName := "requests:"
+	if isUpload {
+		keyName = "requests_upload:"
+	}
+	return s.logRequests(keyName, ipAddr, uuid, 1)
+}
+
+func (s *Store) LogNewRequests(ipAddr, uuid string, amount int) int {
+	return s.logRequests("requests:", ipAddr, uuid, amount)
+}
+
+func (s *Store) logRequests(keyName, ipAddr, uuid string, amount int) int {
+	now := time.Now()
+	secondsToMidnight, _ := strconv.Atoi(helper.GetSecondsToMidnight())
+	var requests int
+	_ = s.db.Batch(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketRequests)
+		key := []byte(keyName + ipAddr)
+		counter := 0
+		value := bucket.Get(key)
+		if value != nil && !isExpired(value, now.Unix()) {
+			counter = int(binary.BigEndian.Uint64(value[8:]))
+		}
+		requests = counter + amount
+		err := bucket.Put(key, withExpiry(uint64ToBytes(uint64(requests)), now.Unix()+int64(secondsToMidnight)))
+		if err != nil {
+			return err
+		}
+		return logUser(tx, uuid, now.Unix())
+	})
+	return requests
+}
+
+// logUser stores the time of the latest request of a user and counts users that have not been seen before
+func logUser(tx *bbolt.Tx, uuid string, now int64) error {
+	users := tx.Bucket(bucketUsers)
+	lastSeen := tx.Bucket(bucketUsersLastSeen)
+	activeUntil := users.Get([]byte(uuid))
+	if activeUntil == nil {
+		err := incrementCounter(tx, statTotalUsers)
+		if err != nil {
+			return err
+		}
+	} else {
+		previous := int64(binary.BigEndian.Uint64(activeUntil)) - storage.TimespanActiveUser
+		if previous == now {
+			return nil
+		}
+		err := lastSeen.Delete(lastSeenKey(previous, uuid))
+		if err != nil {
+			return err
+		}
+	}
+	err := lastSeen.Put(lastSeenKey(now, uuid), []byte{})
+	if err != nil {
+		return err
+	}
+	return users.Put([]byte(uuid), uint64ToBytes(uint64(now+storage.TimespanActiveUser)))
+}
+
+// lastSeenKey returns the key of a user in bucketUsersLastSeen, which is ordered by the time
+func lastSeenKey(lastSeen int64, uuid string) []byte {
+	return append(uint64ToBytes(uint64(lastSeen)), uuid...)
+}
+
+func (s *Store) GetBarcode(barcode string, increaseHit bool) []string {
+	var names map[string]float64
+	_ = s.db.View(func(tx *bbolt.Tx) error {
+		names = readScores(tx.Bucket(bucketBarcodes).Bucket([]byte(barcode)))
+		return nil
+	})
+	if increaseHit {
+		_ = s.db.Batch(func(tx *bbolt.Tx) error {
+			return increaseHits(tx, []string{barcode})
+		})
+	}
+	return storage.Members(storage.SortByScore(names, storage.MinScoreListed))
+}
+
+// GetBarcodes reads all barcodes within a single transaction
+func (s *Store) GetBarcodes(barcodes []string, increaseHit bool) [][]string {
+	result := make([][]string, len(barcodes))
+	_ = s.db.View(func(tx *bbolt.Tx) error {
+		for i, barcode := range barcodes {
+			names := readScores(tx.Bucket(bucketBarcodes).Bucket([]byte(barcode)))
+			result[i] = storage.Members(storage.SortByScore(names, storage.MinScoreListed))
+		}
+		return nil
+	})
+	if increaseHit && len(barcodes) > 0 {
+		_ = s.db.Batch(func(tx *bbolt.Tx) error {
+			return increaseHits(tx, barcodes)
+		})
+	}
+	return result
+}
+
+// LookupBarcodes reads and counts all barcodes within a single transaction
+func (s *Store) LookupBarcodes(barcodes []string, uuid string) []storage.LookupResult {
+	result := make([]storage.LookupResult, len(barcodes))
+	if len(barcodes) == 0 {
+		return result
+	}
+	err := s.db.Batch(func(tx *bbolt.Tx) error {
+		var counted, missed []string
+		for i, barcode := range barcodes {
+			isCounted := !storage.IsScopedBarcode(barcode)
+			if isCounted {
+				counted = append(counted, barcode)
+			}
+			names := readScores(tx.Bucket(bucketBarcodes).Bucket([]byte(barcode)))
+			result[i] = storage.LookupResult{Names: storage.Members(storage.SortByScore(names, storage.MinScoreListed))}
+			if len(result[i].Names) == 0 {
+				if isCounted {
+					missed = append(missed, barcode)
+				}
+				continue
+			}
+			result[i].Metadata = storage.TopMetadata(readScores(tx.Bucket(bucketMetadata).Bucket([]byte(barcode))))
+			result[i].Languages = readSources(tx.Bucket(bucketLanguages).Bucket([]byte(barcode)))
+		}
+		err := increaseHits(tx, counted)
+		if err != nil {
+			return err
+		}
+		return logLookupMisses(tx, missed, uuid)
+	})
+	if err != nil {
+		log.Println("Unable to count lookups: " + err.Error())
+	}
+	return result
+}
+
+func (s *Store) VoteName(barcode, name, ipAddr string) bool {
+	isNewVote := false
+	_ = s.db.Update(func(tx *bbolt.Tx) error {
+		isNewVote = setIfNotExists(tx.Bucket(bucketVotes), ipAddr+":"+barcode+":"+name)
+		if !isNewVote {
+			return nil
+		}
+		err := incrementCounter(tx, statTotalVotes)
+		if err != nil {
+			return err
+		}
+		names, err := getOrCreateBarcode(tx, barcode)
+		if err != nil {
+			return err
+		}
+		err = incrementScore(names, name, 1)
+		if err != nil {
+			return err
+		}
+		err = logChange(tx, storage.NewChange(storage.ChangeVote, barcode, name, bytesToFloat64(names.Get([]byte(name)))))
+		if err != nil {
+			return err
+		}
+		return touchBarcode(tx, barcode)
+	})
+	return isNewVote
+}
+
+func (s *Store) ReportName(barcode, name, ipAddr string) bool {
+	isReported := false
+	_ = s.db.Update(func(tx *bbolt.Tx) error {
+		if !setIfNotExists(tx.Bucket(bucketReportsIp), ipAddr+":"+barcode+":"+name) {
+			return nil
+		}
+		err := incrementCounter(tx, statTotalReports)
+		if err != nil {
+			return err
+		}
+		names := tx.Bucket(bucketBarcodes).Bucket([]byte(barcode))
+		if names == nil || names.Get([]byte(name)) == nil {
+			return nil
+		}
+		isReported = true
+		err = incrementScore(names, name, -2)
+		if err != nil {
+			return err
+		}
+		err = logChange(tx, storage.NewChange(storage.ChangeReport, barcode, name, bytesToFloat64(names.Get([]byte(name)))))
+		if err != nil {
+			return err
+		}
+		err = touchBarcode(tx, barcode)
+		if err != nil {
+			return err
+		}
+		reported, err := tx.Bucket(bucketReported).CreateBucketIfNotExists([]byte(barcode))
+		if err != nil {
+			return err
+		}
+		err = incrementScore(reported, name, 1)
+		if err != nil {
+			return err
+		}
+		return incrementScore(tx.Bucket(bucketReports), barcode+":"+name, 1)
+	})
+	return isReported
+}
+
+func (s *Store) ProcessReport(report storage.Report, dismissReport bool) {
+	score := float64(-100)
+	changeType := storage.ChangeRemove
+	if dismissReport {
+		score = 1
+		changeType = storage.ChangeDismiss
+	}
+	barcode, name := storage.SplitReport(report)
+
+	_ = s.db.Update(func(tx *bbolt.Tx) error {
+		names, err := getOrCreateBarcode(tx, barcode)
+		if err != nil {
+			return err
+		}
+		err = names.Put([]byte(name), float64ToBytes(score))
+		if err != nil {
+			return err
+		}
+		err = logChange(tx, storage.NewChange(changeType, barcode, name, score))
+		if err != nil {
+			return err
+		}
+		err = touchBarcode(tx, barcode)
+		if err != nil {
+			return err
+		}
+		reported := tx.Bucket(bucketReported).Bucket([]byte(barcode))
+		if reported != nil {
+			err = reported.Delete([]byte(name))
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(bucketReports).Delete([]byte(report.BarcodeAndName))
+	})
+}
+
+func (s *Store) AddGrocyBarcodes(barcodes storage.GrocyBarcodes, uuid, source string) {
+	expiry := time.Now().Unix() + storage.TimespanUploadLog
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		for _, barcode := range barcodes.Barcodes {
+			sanitized, ok := storage.SanitizeBarcode(barcode)
+			if !ok {
+				continue
+			}
+			names, err := getOrCreateBarcode(tx, sanitized.Barcode)
+			if err != nil {
+				return err
+			}
+			storedNames := readScores(names)
+			equivalentName, exists := storage.FindEquivalentName(storedNames, sanitized.Name)
+			if exists {
+				sanitized.Name = equivalentName
+			}
+			nameLanguage := storage.NameLanguage(sanitized)
+			err = setLanguage(tx, sanitized.Barcode, sanitized.Name, nameLanguage)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				err = addMergeProposals(tx, storage.FindMergeProposalsForName(sanitized.Barcode, storedNames, sanitized.Name, 1))
+				if err != nil {
+					return err
+				}
+				err = names.Put([]byte(sanitized.Name), float64ToBytes(1))
+				if err != nil {
+					return err
+				}
+				sources, err := tx.Bucket(bucketSources).CreateBucketIfNotExists([]byte(sanitized.Barcode))
+				if err != nil {
+					return err
+				}
+				err = sources.Put([]byte(sanitized.Name), []byte(source))
+				if err != nil {
+					return err
+				}
+				err = logChange(tx, storage.NewNameChange(storage.ChangeAdd, sanitized.Barcode, sanitized.Name, nameLanguage, 1))
+				if err != nil {
+					return err
+				}
+				err = touchBarcode(tx, sanitized.Barcode)
+				if err != nil {
+					return err
+				}
+			}
+			err = addMetadata(tx, sanitized.Barcode, sanitized.Metadata, source)
+			if err != nil {
+				return err
+			}
+			err = tx.Bucket(bucketUploadLog).Put([]byte(sanitized.Barcode+":"+sanitized.Name), withExpiry([]byte(uuid), expiry))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("Unable to store barcodes: " + err.Error())
+	}
+}
+
+// setLanguage stores the language of a name, unless it is empty or the name already has a language
+func setLanguage(tx *bbolt.Tx, barcode, name, nameLanguage string) error {
+	if nameLanguage == "" {
+		return nil
+	}
+	languages, err := tx.Bucket(bucketLanguages).CreateBucketIfNotExists([]byte(barcode))
+	if err != nil {
+		return err
+	}
+	if languages.Get([]byte(name)) != nil {
+		return nil
+	}
+	return languages.Put([]byte(name), []byte(nameLanguage))
+}
+
+// GetNameLanguages reads the languages of all barcodes within a single transaction
+func (s *Store) GetNameLanguages(barcodes []string) []map[string]string {
+	result := make([]map[string]string, len(barcodes))
+	_ = s.db.View(func(tx *bbolt.Tx) error {
+		for i, barcode := range barcodes {
+			result[i] = readSources(tx.Bucket(bucketLanguages).Bucket([]byte(barcode)))
+		}
+		return nil
+	})
+	return result
+}
+
+// addMetadata stores all metadata values of an uploaded barcode that do not exist yet
+func addMetadata(tx *bbolt.Tx, barcode string, metadata storage.Metadata, source string) error {
+	values := metadata.Values()
+	if len(values) == 0 {
+		return nil
+	}
+	scores, err := tx.Bucket(bucketMetadata).CreateBucketIfNotExists([]byte(barcode))
+	if err != nil {
+		return err
+	}
+	sources, err := tx.Bucket(bucketMetadataSources).CreateBucketIfNotExists([]byte(barcode))
+	if err != nil {
+		return err
+	}
+	for _, field := range storage.MetadataFields {
+		value, ok := values[field]
+		if !ok {
+			continue
+		}
+		key := []byte(storage.MetadataKey(field, value))
+		if scores.Get(key) != nil {
+			continue
+		}
+		err = scores.Put(key, float64ToBytes(1))
+		if err != nil {
+			return err
+		}
+		err = sources.Put(key, []byte(source))
+		if err != nil {
+			return err
+		}
+		err = logChange(tx, storage.NewMetadataChange(storage.ChangeAdd, barcode, field, value, 1))
+		if err != nil {
+			return err
+		}
+		err = touchBarcode(tx, barcode)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMetadata reads the metadata of all barcodes within a single transaction
+func (s *Store) GetMetadata(barcodes []string) []storage.Metadata {
+	result := make([]storage.Metadata, len(barcodes))
+	_ = s.db.View(func(tx *bbolt.Tx) error {
+		for i, barcode := range barcodes {
+			result[i] = storage.TopMetadata(readScores(tx.Bucket(bucketMetadata).Bucket([]byte(barcode))))
+		}
+		return nil
+	})
+	return result
+}
+
+func (s *Store) VoteMetadata(barcode, field, value, ipAddr string) bool {
+	key := storage.MetadataKey(field, value)
+	isNewVote := false
+	_ = s.db.Update(func(tx *bbolt.Tx) error {
+		isNewVote = setIfNotExists(tx.Bucket(bucketMetadataVotes), ipAddr+":"+barcode+":"+key)
+		if !isNewVote {
+			return nil
+		}
+		scores, err := tx.Bucket(bucketMetadata).CreateBucketIfNotExists([]byte(barcode))
+		if err != nil {
+			return err
+		}
+		err = incrementScore(scores, key, 1)
+		if err != nil {
+			return err
+		}
+		err = logChange(tx, storage.NewMetadataChange(storage.ChangeVote, barcode, field, value, bytesToFloat64(scores.Get([]byte(key)))))
+		if err != nil {
+			return err
+		}
+		return touchBarcode(tx, barcode)
+	})
+	return isNewVote
+}
+
+// increaseHits counts a lookup of every barcode in the all-time hits and the hits of today
+func increaseHits(tx *bbolt.Tx, barcodes []string) error {
+	dailyHits, err := tx.Bucket(bucketDailyHits).CreateBucketIfNotExists([]byte(storage.DayKey(time.Now())))
+	if err != nil {
+		return err
+	}
+	for _, barcode := range barcodes {
+		err = setHits(tx, barcode, bytesToFloat64(tx.Bucket(bucketHits).Get([]byte(barcode)))+1)
+		if err != nil {
+			return err
+		}
+		err = incrementScore(dailyHits, barcode, 1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setHits stores the amount of lookups of a barcode and keeps bucketTopHits up to date.
+// The hits of the barcode are removed if hits is 0
+func setHits(tx *bbolt.Tx, barcode string, hits float64) error {
+	bucket := tx.Bucket(bucketHits)
+	previous := bytesToFloat64(bucket.Get([]byte(barcode)))
+	var err error
+	if hits == 0 {
+		err = bucket.Delete([]byte(barcode))
+	} else {
+		err = bucket.Put([]byte(barcode), float64ToBytes(hits))
+	}
+	if err != nil {
+		return err
+	}
+	return updateTopHits(tx, barcode, previous, hits)
+}
+
+// updateTopHits moves a barcode within bucketTopHits after its hits have changed. A barcode that is
+// not part of it is only added if it is not full or the barcode has more hits than the lowest entry
+func updateTopHits(tx *bbolt.Tx, barcode string, previous, hits float64) error {
+	top := tx.Bucket(bucketTopHits)
+	previousKey := topHitsKey(previous, barcode)
+	if top.Get(previousKey) != nil {
+		err := top.Delete(previousKey)
+		if err != nil {
+			return err
+		}
+	} else if hits > 0 && countKeys(top) >= storage.AmountTopBarcodes {
+		lowest, _ := top.Cursor().First()
+		if hits <= topHitsOf(lowest) {
+			return nil
+		}
+		err := top.Delete(append([]byte(nil), lowest...))
+		if err != nil {
+			return err
+		}
+	}
+	if hits == 0 {
+		return nil
+	}
+	return top.Put(topHitsKey(hits, barcode), []byte{})
+}
+
+// topHitsKey returns the key of a barcode in bucketTopHits, which is ordered by the hits
+func topHitsKey(hits float64, barcode string) []byte {
+	return append(uint64ToBytes(uint64(hits)), barcode...)
+}
+
+// topHitsOf returns the hits that are part of a key of bucketTopHits
+func topHitsOf(key []byte) float64 {
+	return float64(binary.BigEndian.Uint64(key[:8]))
+}
+
+func (s *Store) GetDailyHits(days []string) map[string]float64 {
+	result := make(map[string]float64)
+	_ = s.db.View(func(tx *bbolt.Tx) error {
+		for _, day := range days {
+			for barcode, hits := range readScores(tx.Bucket(bucketDailyHits).Bucket([]byte(day))) {
+				result[barcode] += hits
+			}
+		}
+		return nil
+	})
+	return result
+}
+
+func (s *Store) LogLookupMisses(barcodes []string, uuid string) {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		return logLookupMisses(tx, barcodes, uuid)
+	})
+	if err != nil {
+		log.Println("Unable to store lookup misses: " + err.Error())
+	}
+}
+
+// logLookupMisses counts every uuid once per barcode and day
+func logLookupMisses(tx *bbolt.Tx, barcodes []string, uuid string) error {
+	if len(barcodes) == 0 {
+		return nil
+	}
+	day := []byte(storage.DayKey(time.Now()))
+	missUsers, err := tx.Bucket(bucketMissUsers).CreateBucketIfNotExists(day)
+	if err != nil {
+		return err
+	}
+	misses, err := tx.Bucket(bucketMisses).CreateBucketIfNotExists(day)
+	if err != nil {
+		return err
+	}
+	for _, barcode := range barcodes {
+		if !setIfNotExists(missUsers, barcode+":"+uuid) {
+			continue
+		}
+		err = incrementScore(misses, barcode, 1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) GetLookupMisses(days int) map[string]float64 {
+	result := make(map[string]float64)
+	_ = s.db.View(func(tx *bbolt.Tx) error {
+		for _, day := range storage.LastDays(days) {
+			for barcode, requests := range readScores(tx.Bucket(bucketMisses).Bucket([]byte(day))) {
+				result[barcode] += requests
+			}
+		}
+		return nil
+	})
+	return result
+}
+
+func (s *Store) GetMergeProposals() []storage.MergeProposal {
+	var result []storage.MergeProposal
+	_ = s.db.View(func(tx *bbolt.Tx) error {
+		result = readMergeProposals(tx)
+		return nil
+	})
+	return storage.SortMergeProposals(result)
+}
+
+// readMergeProposals returns all stored merge proposals in the order of their keys
+func readMergeProposals(tx *bbolt.Tx) []storage.MergeProposal {
+	var result []storage.MergeProposal
+	_ = tx.Bucket(bucketMergeProposals).ForEach(func(key, value []byte) error {
+		var proposal storage.MergeProposal
+		err := json.Unmarshal(value, &proposal)
+		if err == nil {
+			result = append(result, proposal)
+		}
+		return nil
+	})
+	return result
+}
+
+func (s *Store) AddMergeProposals(proposals []storage.MergeProposal) {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		return addMergeProposals(tx, proposals)
+	})
+	if err != nil {
+		log.Println("Unable to store merge proposals: " + err.Error())
+	}
+}
+
+// addMergeProposals stores all proposals that have neither been stored nor dismissed yet
+func addMergeProposals(tx *bbolt.Tx, proposals []storage.MergeProposal) error {
+	for _, proposal := range proposals {
+		key := []byte(proposal.Key())
+		if tx.Bucket(bucketDismissedMerges).Get(key) != nil || tx.Bucket(bucketMergeProposals).Get(key) != nil {
+			continue
+		}
+		content, err := json.Marshal(proposal)
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(bucketMergeProposals).Put(key, content)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) ProcessMergeProposal(proposal storage.MergeProposal, accept bool) {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		key := []byte(proposal.Key())
+		err := tx.Bucket(bucketMergeProposals).Delete(key)
+		if err != nil {
+			return err
+		}
+		if !accept {
+			return tx.Bucket(bucketDismissedMerges).Put(key, []byte{})
+		}
+		return mergeNames(tx, proposal)
+	})
+	if err != nil {
+		log.Println("Unable to process merge proposal: " + err.Error())
+	}
+}
+
+// mergeNames adds the score of the name of a proposal to its target and removes the name
+func mergeNames(tx *bbolt.Tx, proposal storage.MergeProposal) error {
+	barcode := proposal.Barcode
+	names := tx.Bucket(bucketBarcodes).Bucket([]byte(barcode))
+	if names == nil {
+		return nil
+	}
+	nameValue := names.Get([]byte(proposal.Name))
+	targetValue := names.Get([]byte(proposal.Target))
+	if nameValue == nil || targetValue == nil || bytesToFloat64(nameValue) < storage.MinScoreListed || bytesToFloat64(targetValue) < storage.MinScoreListed {
+		// One of the names has been removed since the proposal was made
+		return nil
+	}
+	mergedScore := storage.MergedScore(bytesToFloat64(targetValue), bytesToFloat64(nameValue))
+	err := names.Put([]byte(proposal.Target), float64ToBytes(mergedScore))
+	if err != nil {
+		return err
+	}
+	err = logChange(tx, storage.NewChange(storage.ChangeMerge, barcode, proposal.Target, mergedScore))
+	if err != nil {
+		return err
+	}
+	err = names.Put([]byte(proposal.Name), float64ToBytes(storage.ScoreRemoved))
+	if err != nil {
+		return err
+	}
+	err = logChange(tx, storage.NewChange(storage.ChangeRemove, barcode, proposal.Name, storage.ScoreRemoved))
+	if err != nil {
+		return err
+	}
+	err = touchBarcode(tx, barcode)
+	if err != nil {
+		return err
+	}
+	reported := tx.Bucket(bucketReported).Bucket([]byte(barcode))
+	if reported != nil {
+		err = reported.Delete([]byte(proposal.Name))
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Bucket(bucketReports).Delete([]byte(barcode + ":" + proposal.Name))
+	if err != nil {
+		return err
+	}
+	for _, other := range readMergeProposals(tx) {
+		if other.Contains(barcode, proposal.Name) {
+			err = tx.Bucket(bucketMergeProposals).Delete([]byte(other.Key()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Ping reads from the database, which fails if the database file has been closed
+func (s *Store) Ping() error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		if tx.Bucket(bucketStats) == nil {
+			return bbolt.ErrBucketNotFound
+		}
+		return nil
+	})
+}
+
+func (s *Store) GetTotalBarcodes() int {
+	return s.getCounter(statTotalBarcodes)
+}
+
+func (s *Store) GetTotalVotes() int {
+	return s.getCounter(statTotalVotes)
+}
+
+// GetTotalActiveUsers counts the users that have been seen within storage.TimespanActiveUser
+func (s *Store) GetTotalActiveUsers() int {
+	minKey := uint64ToBytes(uint64(time.Now().Unix() - storage.TimespanActiveUser))
+	amount := 0
+	_ = s.db.View(func(tx *bbolt.Tx) error {
+		cursor := tx.Bucket(bucketUsersLastSeen).Cursor()
+		for key, _ := cursor.Seek(minKey); key != nil; key, _ = cursor.Next() {
+			amount++
+		}
+		return nil
+	})
+	return amount
+}
+
+func (s *Store) GetTotalReports() int {
+	return s.getCounter(statTotalReports)
+}
+
+func (s *Store) GetTotalUsers() int {
+	return s.getCounter(statTotalUsers)
+}
+
+func (s *Store) getCounter(key []byte) int {
+	var amount uint64
+	_ = s.db.View(func(tx *bbolt.Tx) error {
+		amount = readCounter(tx, key)
+		return nil
+	})
+	return int(amount)
+}
+
+// readCounter returns a counter of the stats bucket
+func readCounter(tx *bbolt.Tx, key []byte) uint64 {
+	value := tx.Bucket(bucketStats).Get(key)
+	if value == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(value)
+}
+
+// ReconcileStatistics recounts all barcodes, votes, reports and users within a read-only
+// transaction, so that writers are not blocked by the scan. The counters are then corrected
+// by the difference to their value in that transaction, which keeps changes made in the meantime
+func (s *Store) ReconcileStatistics() {
+	counters := map[string][]byte{
+		string(statTotalBarcodes): bucketBarcodes,
+		string(statTotalVotes):    bucketVotes,
+		string(statTotalReports):  bucketReportsIp,
+		string(statTotalUsers):    bucketUsers,
+	}
+	corrections := make(map[string]int)
+	var missingLastSeen map[string][]byte
+	var topBarcodes []storage.ScoredEntry
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		for counter, bucket := range counters {
+			corrections[counter] = countKeys(tx.Bucket(bucket)) - int(readCounter(tx, []byte(counter)))
+		}
+		missingLastSeen = findMissingLastSeen(tx)
+		topBarcodes = storage.SortByScore(readScores(tx.Bucket(bucketHits)), 1)
+		if len(topBarcodes) > storage.AmountTopBarcodes {
+			topBarcodes = topBarcodes[:storage.AmountTopBarcodes]
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("Unable to reconcile statistics: " + err.Error())
+		return
+	}
+	err = s.db.Update(func(tx *bbolt.Tx) error {
+		for counter, correction := range corrections {
+			if correction == 0 {
+				continue
+			}
+			amount := int(readCounter(tx, []byte(counter))) + correction
+			if amount < 0 {
+				amount = 0
+			}
+			err := tx.Bucket(bucketStats).Put([]byte(counter), uint64ToBytes(uint64(amount)))
+			if err != nil {
+				return err
+			}
+		}
+		err := addLastSeen(tx, missingLastSeen)
+		if err != nil {
+			return err
+		}
+		return rebuildTopHits(tx, topBarcodes)
+	})
+	if err != nil {
+		log.Println("Unable to reconcile statistics: " + err.Error())
+	}
+}
+
+// findMissingLastSeen returns the active users without an entry in bucketUsersLastSeen, which
+// have been stored by older versions, mapped to the value of bucketUsers they have been read with
+func findMissingLastSeen(tx *bbolt.Tx) map[string][]byte {
+	result := make(map[string][]byte)
+	lastSeen := tx.Bucket(bucketUsersLastSeen)
+	minLastSeen := time.Now().Unix() - storage.TimespanActiveUser
+	_ = tx.Bucket(bucketUsers).ForEach(func(uuid, activeUntil []byte) error {
+		seen := int64(binary.BigEndian.Uint64(activeUntil)) - storage.TimespanActiveUser
+		if seen >= minLastSeen && lastSeen.Get(lastSeenKey(seen, string(uuid))) == nil {
+			result[string(uuid)] = append([]byte(nil), activeUntil...)
+		}
+		return nil
+	})
+	return result
+}
+
+// addLastSeen adds the users returned by findMissingLastSeen to bucketUsersLastSeen,
+// unless they have sent a request in the meantime, which already added them
+func addLastSeen(tx *bbolt.Tx, missing map[string][]byte) error {
+	users := tx.Bucket(bucketUsers)
+	for uuid, activeUntil := range missing {
+		if !bytes.Equal(users.Get([]byte(uuid)), activeUntil) {
+			continue
+		}
+		seen := int64(binary.BigEndian.Uint64(activeUntil)) - storage.TimespanActiveUser
+		err := tx.Bucket(bucketUsersLastSeen).Put(lastSeenKey(seen, uuid), []byte{})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebuildTopHits replaces bucketTopHits with the given barcodes, which also adds
+// the hits that have been stored by older versions
+func rebuildTopHits(tx *bbolt.Tx, barcodes []storage.ScoredEntry) error {
+	err := tx.DeleteBucket(bucketTopHits)
+	if err != nil {
+		return err
+	}
+	top, err := tx.CreateBucket(bucketTopHits)
+	if err != nil {
+		return err
+	}
+	for _, barcode := range barcodes {
+		// The hits may have increased since they have been read
+		hits := bytesToFloat64(tx.Bucket(bucketHits).Get([]byte(barcode.Member)))
+		if hits == 0 {
+			continue
+		}
+		err = top.Put(topHitsKey(hits, barcode.Member), []byte{})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func countKeys(bucket *bbolt.Bucket) int {
+	amount := 0
+	_ = bucket.ForEach(func(_, _ []byte) error {
+		amount++
+		return nil
+	})
+	return amount
+}
+
+func (s *Store) GetReportList() []storage.Report {
+	var reports map[string]float64
+	var result []storage.Report
+	_ = s.db.View(func(tx *bbolt.Tx) error {
+		reports = readScores(tx.Bucket(bucketReports))
+		return nil
+	})
+	for i, report := range storage.SortByScore(reports, 0) {
+		result = append(result, storage.Report{
+			Id:             i,
+			BarcodeAndName: report.Member,
+			ReportCount:    storage.FormatScore(report.Score),
+		})
+	}
+	return result
+}
+
+// GetMostPopularBarcodes reads bucketTopHits instead of the hits of all barcodes
+func (s *Store) GetMostPopularBarcodes() []storage.TopBarcode {
+	var result []storage.TopBarcode
+	_ = s.db.View(func(tx *bbolt.Tx) error {
+		cursor := tx.Bucket(bucketTopHits).Cursor()
+		for key, _ := cursor.Last(); key != nil && len(result) < storage.AmountTopBarcodes; key, _ = cursor.Prev() {
+			names := readScores(tx.Bucket(bucketBarcodes).Bucket(key[8:]))
+			result = append(result, storage.TopBarcode{
+				Barcode: string(key[8:]),
+				Hits:    storage.FormatScore(topHitsOf(key)),
+				Names:   storage.JoinNames(storage.Members(storage.SortByScore(names, storage.MinScoreListed))),
+			})
+		}
+		return nil
+	})
+	return result
+}
+
+// GetRamUsage returns the size of the database file, as the embedded database
+// is memory mapped
+func (s *Store) GetRamUsage() uint64 {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return 0
+	}
+	return uint64(info.Size())
+}
+
+func (s *Store) DeleteBarcode(barcode string) {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		err := removeBarcode(tx, barcode)
+		if err != nil {
+			return err
+		}
+		err = setHits(tx, barcode, 0)
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(bucketUpdated).Delete([]byte(barcode))
+		if err != nil {
+			return err
+		}
+		return logChange(tx, storage.NewChange(storage.ChangeClear, barcode, "", 0))
+	})
+	if err != nil {
+		log.Println("Unable to delete barcode: " + err.Error())
+	}
+}
+
+func (s *Store) ImportBarcode(record storage.ExportRecord, mode storage.ImportMode) {
+	isEmpty := len(record.Names) == 0 && len(record.Metadata) == 0
+	if isEmpty && mode != storage.ImportReplace {
+		return
+	}
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		if mode == storage.ImportReplace {
+			err := removeBarcode(tx, record.Barcode)
+			if err != nil {
+				return err
+			}
+			err = logChange(tx, storage.NewChange(storage.ChangeClear, record.Barcode, "", 0))
+			if err != nil || isEmpty {
+				return err
+			}
+		}
+		names, err := getOrCreateBarcode(tx, record.Barcode)
+		if err != nil {
+			return err
+		}
+		isModified := mode == storage.ImportReplace
+		for _, name := range record.Names {
+			err = setLanguage(tx, record.Barcode, name.Name, name.Language)
+			if err != nil {
+				return err
+			}
+			value := names.Get([]byte(name.Name))
+			score, isChanged := storage.ImportedScore(mode, bytesToFloat64(value), value != nil, name.Score)
+			if !isChanged {
+				continue
+			}
+			isModified = true
+			err = names.Put([]byte(name.Name), float64ToBytes(score))
+			if err != nil {
+				return err
+			}
+			err = logChange(tx, storage.NewNameChange(storage.ChangeImport, record.Barcode, name.Name, name.Language, score))
+			if err != nil {
+				return err
+			}
+			if value != nil {
+				continue
+			}
+			err = importNameDetails(tx, record.Barcode, name)
+			if err != nil {
+				return err
+			}
+		}
+		isMetadataModified, err := importMetadata(tx, record, mode)
+		if err != nil {
+			return err
+		}
+		isModified = isModified || isMetadataModified
+		localHits := int(bytesToFloat64(tx.Bucket(bucketHits).Get([]byte(record.Barcode))))
+		if record.Hits > localHits || (mode == storage.ImportReplace && record.Hits != localHits) {
+			err = setHits(tx, record.Barcode, float64(record.Hits))
+			if err != nil {
+				return err
+			}
+		}
+		if !isModified {
+			return nil
+		}
+		return touchBarcode(tx, record.Barcode)
+	})
+	if err != nil {
+		log.Println("Unable to import barcode: " + err.Error())
+	}
+}
+
+// importMetadata stores the metadata values of an imported barcode. Returns true if a value has been changed
+func importMetadata(tx *bbolt.Tx, record storage.ExportRecord, mode storage.ImportMode) (bool, error) {
+	if len(record.Metadata) == 0 {
+		return false, nil
+	}
+	scores, err := tx.Bucket(bucketMetadata).CreateBucketIfNotExists([]byte(record.Barcode))
+	if err != nil {
+		return false, err
+	}
+	isModified := false
+	for _, metadata := range record.Metadata {
+		key := []byte(storage.MetadataKey(metadata.Field, metadata.Value))
+		value := scores.Get(key)
+		score, isChanged := storage.ImportedScore(mode, bytesToFloat64(value), value != nil, metadata.Score)
+		if !isChanged {
+			continue
+		}
+		isModified = true
+		err = scores.Put(key, float64ToBytes(score))
+		if err != nil {
+			return false, err
+		}
+		err = logChange(tx, storage.NewMetadataChange(storage.ChangeImport, record.Barcode, metadata.Field, metadata.Value, score))
+		if err != nil {
+			return false, err
+		}
+		if value != nil || metadata.Source == "" {
+			continue
+		}
+		sources, err := tx.Bucket(bucketMetadataSources).CreateBucketIfNotExists([]byte(record.Barcode))
+		if err != nil {
+			return false, err
+		}
+		err = sources.Put(key, []byte(metadata.Source))
+		if err != nil {
+			return false, err
+		}
+	}
+	return isModified, nil
+}
+
+// importNameDetails stores the source and the reports of an imported name
+func importNameDetails(tx *bbolt.Tx, barcode string, name storage.ExportName) error {
+	if name.Source != "" {
+		sources, err := tx.Bucket(bucketSources).CreateBucketIfNotExists([]byte(barcode))
+		if err != nil {
+			return err
+		}
+		err = sources.Put([]byte(name.Name), []byte(name.Source))
+		if err != nil {
+			return err
+		}
+	}
+	if name.Reports > 0 {
+		reported, err := tx.Bucket(bucketReported).CreateBucketIfNotExists([]byte(barcode))
+		if err != nil {
+			return err
+		}
+		err = reported.Put([]byte(name.Name), float64ToBytes(float64(name.Reports)))
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bucketReports).Put([]byte(barcode+":"+name.Name), float64ToBytes(float64(name.Reports)))
+	}
+	return nil
+}
+
+// removeBarcode deletes all names and metadata of a barcode, including their sources, languages and reports
+func removeBarcode(tx *bbolt.Tx, barcode string) error {
+	reported := tx.Bucket(bucketReported).Bucket([]byte(barcode))
+	if reported != nil {
+		for name := range readScores(reported) {
+			err := tx.Bucket(bucketReports).Delete([]byte(barcode + ":" + name))
+			if err != nil {
+				return err
+			}
+		}
+		err := tx.Bucket(bucketReported).DeleteBucket([]byte(barcode))
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range [][]byte{bucketSources, bucketLanguages, bucketMetadata, bucketMetadataSources} {
+		if tx.Bucket(name).Bucket([]byte(barcode)) != nil {
+			err := tx.Bucket(name).DeleteBucket([]byte(barcode))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if tx.Bucket(bucketBarcodes).Bucket([]byte(barcode)) == nil {
+		return nil
+	}
+	err := tx.Bucket(bucketBarcodes).DeleteBucket([]byte(barcode))
+	if err != nil {
+		return err
+	}
+	return decrementCounter(tx, statTotalBarcodes)
+}
+
+func (s *Store) GetChanges(since int64, limit int) storage.ChangeLog {
+	result := storage.ChangeLog{}
+	_ = s.db.View(func(tx *bbolt.Tx) error {
+		changes := tx.Bucket(bucketChanges)
+		result.LatestCursor = int64(changes.Sequence())
+		cursor := changes.Cursor()
+		oldest, _ := cursor.First()
+		if oldest != nil {
+			result.OldestCursor = int64(binary.BigEndian.Uint64(oldest))
+		}
+		if since < 0 {
+			since = 0
+		}
+		for key, value := cursor.Seek(uint64ToBytes(uint64(since) + 1)); key != nil && len(result.Changes) < limit; key, value = cursor.Next() {
+			var change storage.Change
+			if json.Unmarshal(value, &change) != nil {
+				continue
+			}
+			result.Changes = append(result.Changes, change)
+		}
+		return nil
+	})
+	return result
+}
+
+// logChange appends a change to the change log, using the next sequence of the bucket as cursor
+func logChange(tx *bbolt.Tx, change storage.Change) error {
+	changes := tx.Bucket(bucketChanges)
+	cursor, err := changes.NextSequence()
+	if err != nil {
+		return err
+	}
+	change.Cursor = int64(cursor)
+	content, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	return changes.Put(uint64ToBytes(cursor), content)
+}
+
+// trimChanges deletes all but the last storage.MaxStoredChanges changes
+func trimChanges(changes *bbolt.Bucket) error {
+	if changes.Sequence() <= storage.MaxStoredChanges {
+		return nil
+	}
+	oldestKept := changes.Sequence() - storage.MaxStoredChanges + 1
+	var removedKeys [][]byte
+	cursor := changes.Cursor()
+	for key, _ := cursor.First(); key != nil && binary.BigEndian.Uint64(key) < oldestKept; key, _ = cursor.Next() {
+		removedKeys = append(removedKeys, key)
+	}
+	for _, key := range removedKeys {
+		err := changes.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddMetricsSnapshot stores a snapshot as JSON, keyed by its time so that snapshots are ordered
+func (s *Store) AddMetricsSnapshot(snapshot storage.MetricsSnapshot) {
+	value, err := json.Marshal(snapshot)
+	if err != nil {
+		return
+	}
+	_ = s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketMetrics).Put(uint64ToBytes(uint64(snapshot.Time)), value)
+	})
+}
+
+func (s *Store) GetMetricsSnapshots(since int64) []storage.MetricsSnapshot {
+	var result []storage.MetricsSnapshot
+	_ = s.db.View(func(tx *bbolt.Tx) error {
+		cursor := tx.Bucket(bucketMetrics).Cursor()
+		for key, value := cursor.Seek(uint64ToBytes(uint64(since) + 1)); key != nil; key, value = cursor.Next() {
+			var snapshot storage.MetricsSnapshot
+			if json.Unmarshal(value, &snapshot) == nil {
+				result = append(result, snapshot)
+			}
+		}
+		return nil
+	})
+	return result
+}
+
+func (s *Store) RemoveMetricsSnapshots(before int64) {
+	_ = s.db.Update(func(tx *bbolt.Tx) error {
+		metrics := tx.Bucket(bucketMetrics)
+		var removedKeys [][]byte
+		cursor := metrics.Cursor()
+		for key, _ := cursor.First(); key != nil && int64(binary.BigEndian.Uint64(key)) < before; key, _ = cursor.Next() {
+			removedKeys = append(removedKeys, key)
+		}
+		for _, key := range removedKeys {
+			err := metrics.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) GetSyncState(key string) int64 {
+	var result int64
+	_ = s.db.View(func(tx *bbolt.Tx) error {
+		value := tx.Bucket(bucketSyncState).Get([]byte(key))
+		if value != nil {
+			result = int64(binary.BigEndian.Uint64(value))
+		}
+		return nil
+	})
+	return result
+}
+
+func (s *Store) SetSyncState(key string, value int64) {
+	_ = s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketSyncState).Put([]byte(key), uint64ToBytes(uint64(value)))
+	})
+}
+
+// ExportBarcodes reads the barcodes in pages of short read-only transactions and calls
+// exportFunc outside of them, so that a slow client does not keep a transaction open
+func (s *Store) ExportBarcodes(filter storage.ExportFilter, exportFunc storage.ExportFunc) error {
+	var nextKey []byte
+	for {
+		records, key, err := s.readExportPage(filter, nextKey)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			err = exportFunc(record)
+			if err != nil {
+				return err
+			}
+		}
+		if key == nil {
+			return nil
+		}
+		nextKey = key
+	}
+}
+
+// readExportPage returns the records of up to exportPageSize barcodes, starting with startKey.
+// The returned key is the first barcode of the next page, or nil if all barcodes have been read
+func (s *Store) readExportPage(filter storage.ExportFilter, startKey []byte) ([]storage.ExportRecord, []byte, error) {
+	var records []storage.ExportRecord
+	var nextKey []byte
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		cursor := tx.Bucket(bucketBarcodes).Cursor()
+		barcode, _ := cursor.First()
+		if startKey != nil {
+			barcode, _ = cursor.Seek(startKey)
+		}
+		for read := 0; barcode != nil && read < exportPageSize; barcode, _ = cursor.Next() {
+			read++
+			record, ok := filter.Apply(readRecord(tx, barcode))
+			if ok {
+				records = append(records, record)
+			}
+		}
+		if barcode != nil {
+			// Keys are only valid during the transaction
+			nextKey = append([]byte(nil), barcode...)
+		}
+		return nil
+	})
+	return records, nextKey, err
+}
+
+// readRecord returns all data that is stored for a barcode
+func readRecord(tx *bbolt.Tx, barcode []byte) storage.ExportRecord {
+	var updated int64
+	value := tx.Bucket(bucketUpdated).Get(barcode)
+	if value != nil {
+		updated = int64(binary.BigEndian.Uint64(value))
+	}
+	names := readScores(tx.Bucket(bucketBarcodes).Bucket(barcode))
+	reports := readScores(tx.Bucket(bucketReported).Bucket(barcode))
+	metadata := readScores(tx.Bucket(bucketMetadata).Bucket(barcode))
+	return storage.ExportRecord{
+		Barcode:  string(barcode),
+		Hits:     int(bytesToFloat64(tx.Bucket(bucketHits).Get(barcode))),
+		Updated:  updated,
+		Names:    storage.NewExportNames(names, reports, readSources(tx.Bucket(bucketSources).Bucket(barcode)), readSources(tx.Bucket(bucketLanguages).Bucket(barcode))),
+		Metadata: storage.NewExportMetadata(metadata, readSources(tx.Bucket(bucketMetadataSources).Bucket(barcode))),
+	}
+}
+
+// readSources returns the sources or languages stored in a nested bucket. The bucket can be nil
+func readSources(bucket *bbolt.Bucket) map[string]string {
+	sources := make(map[string]string)
+	if bucket != nil {
+		_ = bucket.ForEach(func(key, source []byte) error {
+			sources[string(key)] = string(source)
+			return nil
+		})
+	}
+	return sources
+}
+
+// getOrCreateBarcode returns the bucket of a barcode and keeps the barcode counter up to date
+func getOrCreateBarcode(tx *bbolt.Tx, barcode string) (*bbolt.Bucket, error) {
+	names := tx.Bucket(bucketBarcodes).Bucket([]byte(barcode))
+	if names != nil {
+		return names, nil
+	}
+	err := incrementCounter(tx, statTotalBarcodes)
+	if err != nil {
+		return nil, err
+	}
+	return tx.Bucket(bucketBarcodes).CreateBucket([]byte(barcode))
+}
+
+// touchBarcode stores the current time as the time of the last change of the barcode
+func touchBarcode(tx *bbolt.Tx, barcode string) error {
+	return tx.Bucket(bucketUpdated).Put([]byte(barcode), uint64ToBytes(uint64(time.Now().Unix())))
+}
+
+// incrementCounter increases a counter of the stats bucket by one
+func incrementCounter(tx *bbolt.Tx, key []byte) error {
+	bucket := tx.Bucket(bucketStats)
+	var amount uint64
+	value := bucket.Get(key)
+	if value != nil {
+		amount = binary.BigEndian.Uint64(value)
+	}
+	return bucket.Put(key, uint64ToBytes(amount+1))
+}
+
+// decrementCounter decreases a counter of the stats bucket by one
+func decrementCounter(tx *bbolt.Tx, key []byte) error {
+	bucket := tx.Bucket(bucketStats)
+	value := bucket.Get(key)
+	if value == nil || binary.BigEndian.Uint64(value) == 0 {
+		return nil
+	}
+	return bucket.Put(key, uint64ToBytes(binary.BigEndian.Uint64(value)-1))
+}
+
+// readScores returns all keys of the bucket with their score. Returns an
+// empty map if the bucket does not exist
+func readScores(bucket *bbolt.Bucket) map[string]float64 {
+	result := make(map[string]float64)
+	if bucket == nil {
+		return result
+	}
+	_ = bucket.ForEach(func(key, value []byte) error {
+		result[string(key)] = bytesToFloat64(value)
+		return nil
+	})
+	return result
+}
+
+// incrementScore behaves like ZINCRBY, a non-existing key is created with the score 0
+func incrementScore(bucket *bbolt.Bucket, key string, increment float64) error {
+	var score float64
+	value := bucket.Get([]byte(key))
+	if value != nil {
+		score = bytesToFloat64(value)
+	}
+	return bucket.Put([]byte(key), float64ToBytes(score+increment))
+}
+
+// setIfNotExists creates an empty key. Returns false if the key already existed
+func setIfNotExists(bucket *bbolt.Bucket, key string) bool {
+	if bucket.Get([]byte(key)) != nil {
+		return false
+	}
+	return bucket.Put([]byte(key), []byte{}) == nil
+}
+
+// withExpiry prefixes value with the unix time it expires at
+func withExpiry(value []byte, expiresAt int64) []byte {
+	return append(uint64ToBytes(uint64(expiresAt)), value...)
+}
+
+// isExpired returns true if a value created by withExpiry has expired
+func isExpired(value []byte, now int64) bool {
+	return len(value) < 8 || int64(binary.BigEndian.Uint64(value[:8])) <= now
+}
+
+func uint64ToBytes(value uint64) []byte {
+	result := make([]byte, 8)
+	binary.BigEndian.PutUint64(result, value)
+	return result
+}
+
+func float64ToBytes(value float64) []byte {
+	return uint64ToBytes(math.Float64bits(value))
+}
+
+func bytesToFloat64(value []byte) float64 {
+	if len(value) != 8 {
+		return 0
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(value))
+}
@@ -0,0 +1,89 @@
+package bolt
+
+import (
+	"BarcodeServer/internal/storage"
+	"BarcodeServer/internal/storage/storagetest"
+	bbolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Store {
+		store := Open(filepath.Join(t.TempDir(), "barcodes.db"))
+		t.Cleanup(func() {
+			_ = store.Close()
+		})
+		return store
+	})
+}
+
+func TestActiveUsers(t *testing.T) {
+	store := Open(filepath.Join(t.TempDir(), "barcodes.db"))
+	defer store.Close()
+	now := time.Now().Unix()
+	err := store.db.Update(func(tx *bbolt.Tx) error {
+		for uuid, lastSeen := range map[string]int64{"inactive": now - storage.TimespanActiveUser - 10, "active": now - 10} {
+			err := logUser(tx, uuid, lastSeen)
+			if err != nil {
+				return err
+			}
+		}
+		// Seen again, the user must only be counted once
+		return logUser(tx, "active", now)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if users, active := store.GetTotalUsers(), store.GetTotalActiveUsers(); users != 2 || active != 1 {
+		t.Errorf("expected 2 users and 1 active user, got %d and %d", users, active)
+	}
+
+	store.removeExpired()
+	store.ReconcileStatistics()
+	err = store.db.View(func(tx *bbolt.Tx) error {
+		if amount := countKeys(tx.Bucket(bucketUsersLastSeen)); amount != 1 {
+			t.Errorf("expected 1 last seen entry, got %d", amount)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if users, active := store.GetTotalUsers(), store.GetTotalActiveUsers(); users != 2 || active != 1 {
+		t.Errorf("after reconciling: expected 2 users and 1 active user, got %d and %d", users, active)
+	}
+}
+
+func TestExportPages(t *testing.T) {
+	previousPageSize := exportPageSize
+	exportPageSize = 2
+	t.Cleanup(func() {
+		exportPageSize = previousPageSize
+	})
+	store := Open(filepath.Join(t.TempDir(), "barcodes.db"))
+	defer store.Close()
+	barcodes := []string{"4006040000013", "4006040000020", "4006040000037", "4006040000044", "4006040000051"}
+	for _, barcode := range barcodes {
+		store.AddGrocyBarcodes(storage.GrocyBarcodes{Barcodes: []storage.Barcode{{Barcode: barcode, Name: "Name"}}}, "abcdefghijabcdefghijabcdefghij12", storage.SourceUser)
+	}
+
+	var exported []string
+	err := store.ExportBarcodes(storage.ExportFilter{MinScore: -1000}, func(record storage.ExportRecord) error {
+		exported = append(exported, record.Barcode)
+		// Writes must not wait for the export to finish
+		store.VoteName(record.Barcode, "Name", "10.0.0.1")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(exported, barcodes) {
+		t.Errorf("expected %v, got %v", barcodes, exported)
+	}
+	if votes := store.GetTotalVotes(); votes != len(barcodes) {
+		t.Errorf("expected %d votes, got %d", len(barcodes), votes)
+	}
+}
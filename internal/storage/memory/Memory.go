@@ -0,0 +1,860 @@
+package memory
+
+import (
+	"BarcodeServer/internal/helper"
+	"BarcodeServer/internal/storage"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store is an in-process implementation of storage.Store. All data is lost
+// when the process exits, which makes it suitable for tests and demo instances
+type Store struct {
+	mutex sync.Mutex
+	// barcodes maps barcodes to their names and the score of each name
+	barcodes map[string]map[string]float64
+	// reported maps barcodes to their reported names and the report count of each name
+	reported map[string]map[string]float64
+	// reports maps "barcode:name" to the report count
+	reports map[string]float64
+	// hits maps barcodes to the amount of lookups
+	hits map[string]float64
+	// topHits contains the hits of the storage.AmountTopBarcodes most looked up barcodes
+	topHits map[string]float64
+	// dailyHits maps days to the barcodes that have been looked up and the amount of lookups
+	dailyHits map[string]map[string]float64
+	// users contains all uuids that have ever sent a request
+	users map[string]bool
+	// counters contains all keys that increase and can expire, e.g. "requests:" or "vote:"
+	counters map[string]*expiringValue
+	// values contains all string keys that can expire, e.g. "users:active:" or "log:uuid:"
+	values map[string]*expiringValue
+	// sources maps barcodes to their names and the source of each name
+	sources map[string]map[string]string
+	// languages maps barcodes to their names and the language of each name
+	languages map[string]map[string]string
+	// metadata maps barcodes to their metadata values, keyed by storage.MetadataKey, and the score of each value
+	metadata map[string]map[string]float64
+	// metadataSources maps barcodes to their metadata values and the source of each value
+	metadataSources map[string]map[string]string
+	// updated maps barcodes to the unix time of their last change
+	updated map[string]int64
+	// misses maps days to the barcodes that have been requested but not found, and the amount of requesting uuids
+	misses map[string]map[string]float64
+	// missUsers maps days to "barcode:uuid" of every lookup miss that has been counted
+	missUsers map[string]map[string]bool
+	// mergeProposals maps the key of every proposed merge of similar names to the proposal
+	mergeProposals map[string]storage.MergeProposal
+	// dismissedMerges contains the keys of all merge proposals that have been dismissed
+	dismissedMerges map[string]bool
+	// syncState contains all values stored with SetSyncState
+	syncState map[string]int64
+	// metrics contains all metrics snapshots, ordered by their time
+	metrics []storage.MetricsSnapshot
+	// totalVotes is the amount of "vote:" counters
+	totalVotes int
+	// totalReports is the amount of "report:" counters
+	totalReports int
+	// changes contains the change log, ordered by cursor
+	changes []storage.Change
+	// latestCursor is the cursor of the most recent change
+	latestCursor int64
+}
+
+var _ storage.Store = (*Store)(nil)
+
+// expiringValue is a key that is deleted after expiresAt. It does not expire if expiresAt is zero
+type expiringValue struct {
+	counter   int
+	value     string
+	expiresAt time.Time
+}
+
+func (v *expiringValue) isExpired(now time.Time) bool {
+	return !v.expiresAt.IsZero() && !now.Before(v.expiresAt)
+}
+
+// cleanupInterval is the interval in which expired keys are removed
+const cleanupInterval = 10 * time.Minute
+
+// changesTrimSlack is the amount of changes the change log may grow beyond
+// storage.MaxStoredChanges before it is trimmed
+const changesTrimSlack = storage.MaxStoredChanges / 10
+
+// New returns an empty in-memory store
+func New() *Store {
+	store := &Store{
+		barcodes:        make(map[string]map[string]float64),
+		reported:        make(map[string]map[string]float64),
+		reports:         make(map[string]float64),
+		hits:            make(map[string]float64),
+		topHits:         make(map[string]float64),
+		dailyHits:       make(map[string]map[string]float64),
+		users:           make(map[string]bool),
+		counters:        make(map[string]*expiringValue),
+		values:          make(map[string]*expiringValue),
+		sources:         make(map[string]map[string]string),
+		updated:         make(map[string]int64),
+		syncState:       make(map[string]int64),
+		languages:       make(map[string]map[string]string),
+		metadata:        make(map[string]map[string]float64),
+		metadataSources: make(map[string]map[string]string),
+		misses:          make(map[string]map[string]float64),
+		missUsers:       make(map[string]map[string]bool),
+		mergeProposals:  make(map[string]storage.MergeProposal),
+		dismissedMerges: make(map[string]bool),
+	}
+	go store.startPeriodicCleanup()
+	return store
+}
+
+func (s *Store) startPeriodicCleanup() {
+	for {
+		time.Sleep(cleanupInterval)
+		s.removeExpired()
+	}
+}
+
+// removeExpired deletes all keys and daily buckets that have expired
+func (s *Store) removeExpired() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	for _, keys := range []map[string]*expiringValue{s.counters, s.values} {
+		for key, value := range keys {
+			if value.isExpired(now) {
+				delete(keys, key)
+			}
+		}
+	}
+	for day := range s.misses {
+		if storage.IsExpiredDay(day, storage.MissRetentionDays) {
+			delete(s.misses, day)
+			delete(s.missUsers, day)
+		}
+	}
+	for day := range s.dailyHits {
+		if storage.IsExpiredDay(day, storage.HitRetentionDays) {
+			delete(s.dailyHits, day)
+		}
+	}
+}
+
+// increaseHit counts a lookup of the barcode. Must be called with a lock
+func (s *Store) increaseHit(barcode string) {
+	s.hits[barcode]++
+	s.updateTopHits(barcode)
+	incrementScore(s.dailyHits, storage.DayKey(time.Now()), barcode, 1)
+}
+
+// updateTopHits adds the barcode to topHits if it is not full or the barcode has more
+// hits than the lowest entry, and removes it without hits. Must be called with a lock
+func (s *Store) updateTopHits(barcode string) {
+	hits := s.hits[barcode]
+	_, isTop := s.topHits[barcode]
+	switch {
+	case hits == 0:
+		delete(s.topHits, barcode)
+	case isTop || len(s.topHits) < storage.AmountTopBarcodes:
+		s.topHits[barcode] = hits
+	default:
+		lowest := ""
+		for member, score := range s.topHits {
+			if lowest == "" || score < s.topHits[lowest] {
+				lowest = member
+			}
+		}
+		if hits > s.topHits[lowest] {
+			delete(s.topHits, lowest)
+			s.topHits[barcode] = hits
+		}
+	}
+}
+
+// incr behaves like INCR, an expired or non-existing key starts with 0. Must be called with a lock
+func (s *Store) incr(key string) int {
+	return s.incrBy(key, 1)
+}
+
+// incrBy behaves like INCRBY. Must be called with a lock
+func (s *Store) incrBy(key string, amount int) int {
+	value, ok := s.counters[key]
+	if !ok || value.isExpired(time.Now()) {
+		value = &expiringValue{}
+		s.counters[key] = value
+	}
+	value.counter = value.counter + amount
+	return value.counter
+}
+
+// expire behaves like EXPIRE. Must be called with a lock
+func (s *Store) expire(key string, seconds int) {
+	value, ok := s.counters[key]
+	if ok {
+		value.expiresAt = time.Now().Add(time.Duration(seconds) * time.Second)
+	}
+}
+
+// setEx behaves like SET with EX. Must be called with a lock
+func (s *Store) setEx(key, value string, seconds int) {
+	s.values[key] = &expiringValue{
+		value:     value,
+		expiresAt: time.Now().Add(time.Duration(seconds) * time.Second),
+	}
+}
+
+// incrementScore behaves like ZINCRBY and returns the new score. Must be called with a lock
+func incrementScore(sortedSets map[string]map[string]float64, key, member string, increment float64) float64 {
+	set, ok := sortedSets[key]
+	if !ok {
+		set = make(map[string]float64)
+		sortedSets[key] = set
+	}
+	set[member] += increment
+	return set[member]
+}
+
+// logChange appends a change to the change log. Once the log exceeds
+// storage.MaxStoredChanges by changesTrimSlack, the oldest changes are removed,
+// so that the log is only copied once per changesTrimSlack changes. Must be called with a lock
+func (s *Store) logChange(change storage.Change) {
+	s.latestCursor++
+	change.Cursor = s.latestCursor
+	s.changes = append(s.changes, change)
+	if len(s.changes) > storage.MaxStoredChanges+changesTrimSlack {
+		s.changes = append([]storage.Change(nil), s.changes[len(s.changes)-storage.MaxStoredChanges:]...)
+	}
+}
+
+// setSource stores the source or language of a name or metadata value. Must be called with a lock
+func setSource(sources map[string]map[string]string, barcode, key, source string) {
+	if sources[barcode] == nil {
+		sources[barcode] = make(map[string]string)
+	}
+	sources[barcode][key] = source
+}
+
+// addMetadata stores all metadata values of an uploaded barcode that do not exist yet. Must be called with a lock
+func (s *Store) addMetadata(barcode string, metadata storage.Metadata, source string) {
+	values := metadata.Values()
+	for _, field := range storage.MetadataFields {
+		value, ok := values[field]
+		if !ok {
+			continue
+		}
+		key := storage.MetadataKey(field, value)
+		_, exists := s.metadata[barcode][key]
+		if exists {
+			continue
+		}
+		incrementScore(s.metadata, barcode, key, 1)
+		setSource(s.metadataSources, barcode, key, source)
+		s.logChange(storage.NewMetadataChange(storage.ChangeAdd, barcode, field, value, 1))
+		s.updated[barcode] = time.Now().Unix()
+	}
+}
+
+func (s *Store) LogNewRequest(ipAddr, uuid string, isUpload bool) int {
+	keyName := "requests:"
+	if isUpload {
+		keyName = "requests_upload:"
+	}
+	return s.logRequests(keyName, ipAddr, uuid, 1)
+}
+
+func (s *Store) LogNewRequests(ipAddr, uuid string, amount int) int {
+	return s.logRequests("requests:", ipAddr, uuid, amount)
+}
+
+func (s *Store) logRequests(keyName, ipAddr, uuid string, amount int) int {
+	secondsToMidnight, _ := strconv.Atoi(helper.GetSecondsToMidnight())
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	requests := s.incrBy(keyName+ipAddr, amount)
+	s.expire(keyName+ipAddr, secondsToMidnight)
+	s.users[uuid] = true
+	s.setEx("users:active:"+uuid, "1", storage.TimespanActiveUser)
+	return requests
+}
+
+func (s *Store) GetBarcode(barcode string, increaseHit bool) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if increaseHit {
+		s.increaseHit(barcode)
+	}
+	return storage.Members(storage.SortByScore(s.barcodes[barcode], storage.MinScoreListed))
+}
+
+func (s *Store) GetBarcodes(barcodes []string, increaseHit bool) [][]string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := make([][]string, len(barcodes))
+	for i, barcode := range barcodes {
+		if increaseHit {
+			s.increaseHit(barcode)
+		}
+		result[i] = storage.Members(storage.SortByScore(s.barcodes[barcode], storage.MinScoreListed))
+	}
+	return result
+}
+
+// LookupBarcodes reads and counts all barcodes while holding the lock once
+func (s *Store) LookupBarcodes(barcodes []string, uuid string) []storage.LookupResult {
+	day := storage.DayKey(time.Now())
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := make([]storage.LookupResult, len(barcodes))
+	for i, barcode := range barcodes {
+		isCounted := !storage.IsScopedBarcode(barcode)
+		if isCounted {
+			s.increaseHit(barcode)
+		}
+		result[i].Names = storage.Members(storage.SortByScore(s.barcodes[barcode], storage.MinScoreListed))
+		if len(result[i].Names) == 0 {
+			if isCounted {
+				s.logLookupMiss(day, barcode, uuid)
+			}
+			continue
+		}
+		result[i].Metadata = storage.TopMetadata(s.metadata[barcode])
+		result[i].Languages = s.nameLanguages(barcode)
+	}
+	return result
+}
+
+func (s *Store) VoteName(barcode, name, ipAddr string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.incr("vote:"+ipAddr+":"+barcode+":"+name) != 1 {
+		return false
+	}
+	s.totalVotes++
+	score := incrementScore(s.barcodes, barcode, name, 1)
+	s.logChange(storage.NewChange(storage.ChangeVote, barcode, name, score))
+	s.updated[barcode] = time.Now().Unix()
+	return true
+}
+
+func (s *Store) ReportName(barcode, name, ipAddr string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.incr("report:"+ipAddr+":"+barcode+":"+name) != 1 {
+		return false
+	}
+	s.totalReports++
+	_, exists := s.barcodes[barcode][name]
+	if !exists {
+		return false
+	}
+	score := incrementScore(s.barcodes, barcode, name, -2)
+	s.logChange(storage.NewChange(storage.ChangeReport, barcode, name, score))
+	s.updated[barcode] = time.Now().Unix()
+	incrementScore(s.reported, barcode, name, 1)
+	s.reports[barcode+":"+name]++
+	return true
+}
+
+func (s *Store) ProcessReport(report storage.Report, dismissReport bool) {
+	score := float64(-100)
+	changeType := storage.ChangeRemove
+	if dismissReport {
+		score = 1
+		changeType = storage.ChangeDismiss
+	}
+	barcode, name := storage.SplitReport(report)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	incrementScore(s.barcodes, barcode, name, 0)
+	s.barcodes[barcode][name] = score
+	s.logChange(storage.NewChange(changeType, barcode, name, score))
+	s.updated[barcode] = time.Now().Unix()
+	delete(s.reported[barcode], name)
+	if len(s.reported[barcode]) == 0 {
+		delete(s.reported, barcode)
+	}
+	delete(s.reports, report.BarcodeAndName)
+}
+
+func (s *Store) AddGrocyBarcodes(barcodes storage.GrocyBarcodes, uuid, source string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, barcode := range barcodes.Barcodes {
+		sanitized, ok := storage.SanitizeBarcode(barcode)
+		if !ok {
+			continue
+		}
+		equivalentName, exists := storage.FindEquivalentName(s.barcodes[sanitized.Barcode], sanitized.Name)
+		if exists {
+			sanitized.Name = equivalentName
+		}
+		nameLanguage := storage.NameLanguage(sanitized)
+		s.setLanguage(sanitized.Barcode, sanitized.Name, nameLanguage)
+		if !exists {
+			s.addMergeProposals(storage.FindMergeProposalsForName(sanitized.Barcode, s.barcodes[sanitized.Barcode], sanitized.Name, 1))
+			incrementScore(s.barcodes, sanitized.Barcode, sanitized.Name, 1)
+			setSource(s.sources, sanitized.Barcode, sanitized.Name, source)
+			s.logChange(storage.NewNameChange(storage.ChangeAdd, sanitized.Barcode, sanitized.Name, nameLanguage, 1))
+			s.updated[sanitized.Barcode] = time.Now().Unix()
+		}
+		s.addMetadata(sanitized.Barcode, sanitized.Metadata, source)
+		s.setEx("log:uuid:"+sanitized.Barcode+":"+sanitized.Name, uuid, storage.TimespanUploadLog)
+	}
+}
+
+// setLanguage stores the language of a name, unless it is empty or the name already has a language.
+// Must be called with a lock
+func (s *Store) setLanguage(barcode, name, nameLanguage string) {
+	_, exists := s.languages[barcode][name]
+	if nameLanguage != "" && !exists {
+		setSource(s.languages, barcode, name, nameLanguage)
+	}
+}
+
+func (s *Store) GetNameLanguages(barcodes []string) []map[string]string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := make([]map[string]string, len(barcodes))
+	for i, barcode := range barcodes {
+		result[i] = s.nameLanguages(barcode)
+	}
+	return result
+}
+
+// nameLanguages returns a copy of the languages of a barcode, the lock must be held
+func (s *Store) nameLanguages(barcode string) map[string]string {
+	result := make(map[string]string, len(s.languages[barcode]))
+	for name, nameLanguage := range s.languages[barcode] {
+		result[name] = nameLanguage
+	}
+	return result
+}
+
+func (s *Store) GetMetadata(barcodes []string) []storage.Metadata {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := make([]storage.Metadata, len(barcodes))
+	for i, barcode := range barcodes {
+		result[i] = storage.TopMetadata(s.metadata[barcode])
+	}
+	return result
+}
+
+func (s *Store) VoteMetadata(barcode, field, value, ipAddr string) bool {
+	key := storage.MetadataKey(field, value)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.incr("votemeta:"+ipAddr+":"+barcode+":"+key) != 1 {
+		return false
+	}
+	score := incrementScore(s.metadata, barcode, key, 1)
+	s.logChange(storage.NewMetadataChange(storage.ChangeVote, barcode, field, value, score))
+	s.updated[barcode] = time.Now().Unix()
+	return true
+}
+
+func (s *Store) GetDailyHits(days []string) map[string]float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := make(map[string]float64)
+	for _, day := range days {
+		for barcode, hits := range s.dailyHits[day] {
+			result[barcode] += hits
+		}
+	}
+	return result
+}
+
+func (s *Store) LogLookupMisses(barcodes []string, uuid string) {
+	day := storage.DayKey(time.Now())
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, barcode := range barcodes {
+		s.logLookupMiss(day, barcode, uuid)
+	}
+}
+
+// logLookupMiss counts the miss of uuid once per day, the lock must be held
+func (s *Store) logLookupMiss(day, barcode, uuid string) {
+	if s.missUsers[day] == nil {
+		s.missUsers[day] = make(map[string]bool)
+	}
+	if s.missUsers[day][barcode+":"+uuid] {
+		return
+	}
+	s.missUsers[day][barcode+":"+uuid] = true
+	incrementScore(s.misses, day, barcode, 1)
+}
+
+func (s *Store) GetLookupMisses(days int) map[string]float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := make(map[string]float64)
+	for _, day := range storage.LastDays(days) {
+		for barcode, requests := range s.misses[day] {
+			result[barcode] += requests
+		}
+	}
+	return result
+}
+
+func (s *Store) GetMergeProposals() []storage.MergeProposal {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := make([]storage.MergeProposal, 0, len(s.mergeProposals))
+	for _, proposal := range s.mergeProposals {
+		result = append(result, proposal)
+	}
+	return storage.SortMergeProposals(result)
+}
+
+func (s *Store) AddMergeProposals(proposals []storage.MergeProposal) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.addMergeProposals(proposals)
+}
+
+// addMergeProposals stores all proposals that have neither been stored nor dismissed yet. Must be called with a lock
+func (s *Store) addMergeProposals(proposals []storage.MergeProposal) {
+	for _, proposal := range proposals {
+		key := proposal.Key()
+		_, exists := s.mergeProposals[key]
+		if !exists && !s.dismissedMerges[key] {
+			s.mergeProposals[key] = proposal
+		}
+	}
+}
+
+func (s *Store) ProcessMergeProposal(proposal storage.MergeProposal, accept bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.mergeProposals, proposal.Key())
+	if !accept {
+		s.dismissedMerges[proposal.Key()] = true
+		return
+	}
+	barcode := proposal.Barcode
+	names := s.barcodes[barcode]
+	nameScore, nameExists := names[proposal.Name]
+	targetScore, targetExists := names[proposal.Target]
+	if !nameExists || !targetExists || nameScore < storage.MinScoreListed || targetScore < storage.MinScoreListed {
+		// One of the names has been removed since the proposal was made
+		return
+	}
+	names[proposal.Target] = storage.MergedScore(targetScore, nameScore)
+	s.logChange(storage.NewChange(storage.ChangeMerge, barcode, proposal.Target, names[proposal.Target]))
+	names[proposal.Name] = storage.ScoreRemoved
+	s.logChange(storage.NewChange(storage.ChangeRemove, barcode, proposal.Name, storage.ScoreRemoved))
+	s.updated[barcode] = time.Now().Unix()
+	delete(s.reported[barcode], proposal.Name)
+	if len(s.reported[barcode]) == 0 {
+		delete(s.reported, barcode)
+	}
+	delete(s.reports, barcode+":"+proposal.Name)
+	for key, other := range s.mergeProposals {
+		if other.Contains(barcode, proposal.Name) {
+			delete(s.mergeProposals, key)
+		}
+	}
+}
+
+// Ping always succeeds, as the data is stored in the server process
+func (s *Store) Ping() error {
+	return nil
+}
+
+func (s *Store) GetTotalBarcodes() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.barcodes)
+}
+
+func (s *Store) GetTotalVotes() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.totalVotes
+}
+
+func (s *Store) GetTotalActiveUsers() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	amount := 0
+	for uuid := range s.users {
+		value, ok := s.values["users:active:"+uuid]
+		if ok && !value.isExpired(now) {
+			amount++
+		}
+	}
+	return amount
+}
+
+func (s *Store) GetTotalReports() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.totalReports
+}
+
+// ReconcileStatistics recounts the vote and report counters and rebuilds topHits,
+// which can miss barcodes after entries have been deleted
+func (s *Store) ReconcileStatistics() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.totalVotes = s.countCounters("vote:")
+	s.totalReports = s.countCounters("report:")
+	s.topHits = make(map[string]float64)
+	for i, barcode := range storage.SortByScore(s.hits, 1) {
+		if i == storage.AmountTopBarcodes {
+			break
+		}
+		s.topHits[barcode.Member] = barcode.Score
+	}
+}
+
+// countCounters returns the amount of non-expired counters starting with prefix. Must be called with a lock
+func (s *Store) countCounters(prefix string) int {
+	now := time.Now()
+	amount := 0
+	for key, value := range s.counters {
+		if strings.HasPrefix(key, prefix) && !value.isExpired(now) {
+			amount++
+		}
+	}
+	return amount
+}
+
+func (s *Store) GetTotalUsers() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.users)
+}
+
+func (s *Store) GetReportList() []storage.Report {
+	var result []storage.Report
+	s.mutex.Lock()
+	reports := storage.SortByScore(s.reports, 0)
+	s.mutex.Unlock()
+	for i, report := range reports {
+		result = append(result, storage.Report{
+			Id:             i,
+			BarcodeAndName: report.Member,
+			ReportCount:    storage.FormatScore(report.Score),
+		})
+	}
+	return result
+}
+
+// GetMostPopularBarcodes sorts topHits instead of the hits of all barcodes
+func (s *Store) GetMostPopularBarcodes() []storage.TopBarcode {
+	var result []storage.TopBarcode
+	s.mutex.Lock()
+	hits := storage.SortByScore(s.topHits, 1)
+	s.mutex.Unlock()
+	for _, barcode := range hits {
+		result = append(result, storage.TopBarcode{
+			Barcode: barcode.Member,
+			Hits:    storage.FormatScore(barcode.Score),
+			Names:   storage.JoinNames(s.GetBarcode(barcode.Member, false)),
+		})
+	}
+	return result
+}
+
+// GetRamUsage returns the heap size of the server process, as all data is stored in it
+func (s *Store) GetRamUsage() uint64 {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	return memStats.HeapAlloc
+}
+
+func (s *Store) DeleteBarcode(barcode string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for name := range s.reported[barcode] {
+		delete(s.reports, barcode+":"+name)
+	}
+	delete(s.barcodes, barcode)
+	delete(s.reported, barcode)
+	delete(s.sources, barcode)
+	delete(s.languages, barcode)
+	delete(s.metadata, barcode)
+	delete(s.metadataSources, barcode)
+	delete(s.hits, barcode)
+	s.updateTopHits(barcode)
+	delete(s.updated, barcode)
+	s.logChange(storage.NewChange(storage.ChangeClear, barcode, "", 0))
+}
+
+func (s *Store) ImportBarcode(record storage.ExportRecord, mode storage.ImportMode) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	barcode := record.Barcode
+	isModified := mode == storage.ImportReplace
+	if mode == storage.ImportReplace {
+		for name := range s.reported[barcode] {
+			delete(s.reports, barcode+":"+name)
+		}
+		delete(s.barcodes, barcode)
+		delete(s.reported, barcode)
+		delete(s.sources, barcode)
+		delete(s.languages, barcode)
+		delete(s.metadata, barcode)
+		delete(s.metadataSources, barcode)
+		s.logChange(storage.NewChange(storage.ChangeClear, barcode, "", 0))
+	}
+	for _, name := range record.Names {
+		s.setLanguage(barcode, name.Name, name.Language)
+		localScore, exists := s.barcodes[barcode][name.Name]
+		score, isChanged := storage.ImportedScore(mode, localScore, exists, name.Score)
+		if !isChanged {
+			continue
+		}
+		isModified = true
+		incrementScore(s.barcodes, barcode, name.Name, 0)
+		s.barcodes[barcode][name.Name] = score
+		s.logChange(storage.NewNameChange(storage.ChangeImport, barcode, name.Name, name.Language, score))
+		if exists {
+			continue
+		}
+		if name.Source != "" {
+			setSource(s.sources, barcode, name.Name, name.Source)
+		}
+		if name.Reports > 0 {
+			incrementScore(s.reported, barcode, name.Name, float64(name.Reports))
+			s.reports[barcode+":"+name.Name] = float64(name.Reports)
+		}
+	}
+	for _, metadata := range record.Metadata {
+		key := storage.MetadataKey(metadata.Field, metadata.Value)
+		localScore, exists := s.metadata[barcode][key]
+		score, isChanged := storage.ImportedScore(mode, localScore, exists, metadata.Score)
+		if !isChanged {
+			continue
+		}
+		isModified = true
+		incrementScore(s.metadata, barcode, key, 0)
+		s.metadata[barcode][key] = score
+		s.logChange(storage.NewMetadataChange(storage.ChangeImport, barcode, metadata.Field, metadata.Value, score))
+		if !exists && metadata.Source != "" {
+			setSource(s.metadataSources, barcode, key, metadata.Source)
+		}
+	}
+	localHits := int(s.hits[barcode])
+	if record.Hits > localHits || (mode == storage.ImportReplace && record.Hits != localHits) {
+		s.hits[barcode] = float64(record.Hits)
+		s.updateTopHits(barcode)
+	}
+	if isModified {
+		s.updated[barcode] = time.Now().Unix()
+	}
+}
+
+func (s *Store) AddMetricsSnapshot(snapshot storage.MetricsSnapshot) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	index := sort.Search(len(s.metrics), func(i int) bool {
+		return s.metrics[i].Time >= snapshot.Time
+	})
+	if index < len(s.metrics) && s.metrics[index].Time == snapshot.Time {
+		s.metrics[index] = snapshot
+		return
+	}
+	s.metrics = append(s.metrics, storage.MetricsSnapshot{})
+	copy(s.metrics[index+1:], s.metrics[index:])
+	s.metrics[index] = snapshot
+}
+
+func (s *Store) GetMetricsSnapshots(since int64) []storage.MetricsSnapshot {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	index := sort.Search(len(s.metrics), func(i int) bool {
+		return s.metrics[i].Time > since
+	})
+	return append([]storage.MetricsSnapshot(nil), s.metrics[index:]...)
+}
+
+func (s *Store) RemoveMetricsSnapshots(before int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	index := sort.Search(len(s.metrics), func(i int) bool {
+		return s.metrics[i].Time >= before
+	})
+	s.metrics = append([]storage.MetricsSnapshot(nil), s.metrics[index:]...)
+}
+
+func (s *Store) GetSyncState(key string) int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.syncState[key]
+}
+
+func (s *Store) SetSyncState(key string, value int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.syncState[key] = value
+}
+
+func (s *Store) GetChanges(since int64, limit int) storage.ChangeLog {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := storage.ChangeLog{LatestCursor: s.latestCursor}
+	if len(s.changes) == 0 {
+		return result
+	}
+	result.OldestCursor = s.changes[0].Cursor
+	// Cursors are consecutive, so the position of a change can be calculated
+	start := since - result.OldestCursor + 1
+	if start < 0 {
+		start = 0
+	}
+	for i := start; i < int64(len(s.changes)) && len(result.Changes) < limit; i++ {
+		result.Changes = append(result.Changes, s.changes[i])
+	}
+	return result
+}
+
+// ExportBarcodes iterates over a copy of all barcodes, so that the store is not
+// locked while exportFunc is running
+func (s *Store) ExportBarcodes(filter storage.ExportFilter, exportFunc storage.ExportFunc) error {
+	s.mutex.Lock()
+	barcodes := make([]string, 0, len(s.barcodes))
+	for barcode := range s.barcodes {
+		barcodes = append(barcodes, barcode)
+	}
+	// Barcodes without names can have metadata
+	for barcode := range s.metadata {
+		if _, hasNames := s.barcodes[barcode]; !hasNames {
+			barcodes = append(barcodes, barcode)
+		}
+	}
+	s.mutex.Unlock()
+	for _, barcode := range barcodes {
+		record, ok := filter.Apply(s.readRecord(barcode))
+		if !ok {
+			continue
+		}
+		err := exportFunc(record)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRecord returns all data that is stored for a barcode
+func (s *Store) readRecord(barcode string) storage.ExportRecord {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return storage.ExportRecord{
+		Barcode:  barcode,
+		Hits:     int(s.hits[barcode]),
+		Updated:  s.updated[barcode],
+		Names:    storage.NewExportNames(s.barcodes[barcode], s.reported[barcode], s.sources[barcode], s.languages[barcode]),
+		Metadata: storage.NewExportMetadata(s.metadata[barcode], s.metadataSources[barcode]),
+	}
+}
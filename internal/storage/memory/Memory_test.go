@@ -0,0 +1,28 @@
+package memory
+
+import (
+	"BarcodeServer/internal/storage"
+	"BarcodeServer/internal/storage/storagetest"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Store {
+		return New()
+	})
+}
+
+func TestTrimChanges(t *testing.T) {
+	store := New()
+	for i := 0; i <= storage.MaxStoredChanges+changesTrimSlack; i++ {
+		store.logChange(storage.Change{Type: storage.ChangeAdd})
+	}
+	changes := store.GetChanges(0, 1)
+	if len(store.changes) != storage.MaxStoredChanges || changes.OldestCursor != changes.LatestCursor-storage.MaxStoredChanges+1 {
+		t.Errorf("unexpected change log of %d changes, oldest cursor %d", len(store.changes), changes.OldestCursor)
+	}
+	page := store.GetChanges(changes.LatestCursor-1, 10)
+	if len(page.Changes) != 1 || page.Changes[0].Cursor != changes.LatestCursor {
+		t.Errorf("unexpected page %+v", page)
+	}
+}
@@ -0,0 +1,929 @@
+package redis
+
+import (
+	"BarcodeServer/internal/helper"
+	"BarcodeServer/internal/metrics"
+	"BarcodeServer/internal/storage"
+	"encoding/json"
+	"github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/trace"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Store is the Redis implementation of storage.Store
+type Store struct {
+	redisPool *radix.Pool
+}
+
+var _ storage.Store = (*Store)(nil)
+
+const (
+	// keyTotalBarcodes is a counter of all stored barcode: keys
+	keyTotalBarcodes = "stats:barcodes"
+	// keyTotalVotes is a counter of all stored vote: keys
+	keyTotalVotes = "stats:votes"
+	// keyTotalReports is a counter of all stored report: keys
+	keyTotalReports = "stats:reports"
+	// keyUsersLastSeen is a sorted set of all uuids, scored by the unix time of their last request
+	keyUsersLastSeen = "users:lastseen"
+	// keyUpdated is a sorted set of all barcodes, scored by the unix time of their last change
+	keyUpdated = "updated"
+	// keySyncState is a hash containing all values stored with SetSyncState
+	keySyncState = "syncstate"
+	// keyChangeCursor is a counter containing the cursor of the most recent change
+	keyChangeCursor = "changes:cursor"
+	// keyChanges is a sorted set of "cursor:change" entries, scored by their cursor
+	keyChanges = "changes"
+	// keyMergeProposals is a hash of all proposed merges of similar names, mapping storage.MergeProposal.Key to the JSON of the proposal
+	keyMergeProposals = "merges"
+	// keyDismissedMerges is a set of the keys of all merge proposals that have been dismissed
+	keyDismissedMerges = "merges:dismissed"
+	// keyMetrics is a sorted set of the JSON of all metrics snapshots, scored by their time
+	keyMetrics = "metrics"
+)
+
+// scanCount is the amount of keys that are requested per SCAN call
+const scanCount = 1000
+
+// writeBarcodeScript runs the command ARGV[1] on the barcode KEYS[1] and increases
+// the barcode counter KEYS[2], if the barcode has been created by the command
+var writeBarcodeScript = radix.NewEvalScript(2, `
+local isNew = redis.call('EXISTS', KEYS[1]) == 0
+local result = redis.call(ARGV[1], KEYS[1], unpack(ARGV, 2))
+if isNew and redis.call('EXISTS', KEYS[1]) == 1 then
+	redis.call('INCR', KEYS[2])
+end
+return result`)
+
+// logChangeScript increases the cursor KEYS[1] and adds the change ARGV[1] to the
+// change log KEYS[2] in a single step, so that changes are never visible out of order.
+// Every 1000 changes, all but the last ARGV[2] changes are removed
+var logChangeScript = radix.NewEvalScript(2, `
+local cursor = redis.call('INCR', KEYS[1])
+redis.call('ZADD', KEYS[2], cursor, cursor .. ':' .. ARGV[1])
+if cursor % 1000 == 0 then
+	redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', cursor - tonumber(ARGV[2]))
+end
+return cursor`)
+
+// logMissScript counts the lookup miss of the barcode ARGV[1] by the uuid ARGV[2] in the
+// sorted set KEYS[2], unless the set KEYS[1] already contains it. Nothing is counted if
+// KEYS[3] is set and the barcode has a name with a score of at least ARGV[4] in it. Both
+// sets expire after ARGV[3] seconds. The script is sent with EVAL, as pipelines do not
+// fall back from EVALSHA if the script is not cached
+const logMissScript = `
+if KEYS[3] ~= '' and redis.call('ZCOUNT', KEYS[3], ARGV[4], '+inf') > 0 then
+	return 0
+end
+if redis.call('SADD', KEYS[1], ARGV[1] .. ':' .. ARGV[2]) == 1 then
+	redis.call('ZINCRBY', KEYS[2], 1, ARGV[1])
+end
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+redis.call('EXPIRE', KEYS[2], ARGV[3])
+return 1`
+
+// logMissCommand returns the command that counts a lookup miss of today. If namesKey is
+// set, the miss is only counted if the barcode has no listed names at the time it runs
+func logMissCommand(barcode, uuid, namesKey string) radix.CmdAction {
+	day := storage.DayKey(time.Now())
+	expiry := strconv.Itoa((storage.MissRetentionDays + 1) * 24 * 60 * 60)
+	return radix.Cmd(nil, "EVAL", logMissScript, "3", "missusers:"+day, "misses:"+day, namesKey,
+		barcode, uuid, expiry, strconv.Itoa(storage.MinScoreListed))
+}
+
+// logChange appends a change to the change log
+func logChange(conn radix.Client, change storage.Change) {
+	content, err := json.Marshal(change)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	_ = conn.Do(logChangeScript.Cmd(nil, keyChangeCursor, keyChanges, string(content), strconv.Itoa(storage.MaxStoredChanges)))
+}
+
+// writeBarcode runs a command on a barcode key and keeps the barcode counter
+// and the time of the last change up to date. The reply of the command is
+// stored in rcv
+func writeBarcode(conn radix.Client, rcv interface{}, barcode, command string, args ...string) {
+	keysAndArgs := append([]string{"barcode:" + barcode, keyTotalBarcodes, command}, args...)
+	_ = conn.Do(writeBarcodeScript.Cmd(rcv, keysAndArgs...))
+	touchBarcode(conn, barcode)
+}
+
+// touchBarcode stores the current time as the time of the last change of the barcode
+func touchBarcode(conn radix.Client, barcode string) {
+	_ = conn.Do(radix.FlatCmd(nil, "ZADD", keyUpdated, time.Now().Unix(), barcode))
+}
+
+// addMetadata stores all metadata values of an uploaded barcode that do not exist yet
+func addMetadata(conn radix.Client, barcode string, metadata storage.Metadata, source string) {
+	values := metadata.Values()
+	for _, field := range storage.MetadataFields {
+		value, ok := values[field]
+		if !ok {
+			continue
+		}
+		key := storage.MetadataKey(field, value)
+		var added int
+		_ = conn.Do(radix.Cmd(&added, "ZADD", "metadata:"+barcode, "NX", "1", key))
+		if added == 1 {
+			_ = conn.Do(radix.Cmd(nil, "HSET", "metasource:"+barcode, key, source))
+			logChange(conn, storage.NewMetadataChange(storage.ChangeAdd, barcode, field, value, 1))
+			touchBarcode(conn, barcode)
+		}
+	}
+}
+
+// addMergeProposals stores all proposals that have neither been stored nor dismissed yet
+func addMergeProposals(conn radix.Client, proposals []storage.MergeProposal) {
+	for _, proposal := range proposals {
+		var isDismissed int
+		_ = conn.Do(radix.Cmd(&isDismissed, "SISMEMBER", keyDismissedMerges, proposal.Key()))
+		if isDismissed == 1 {
+			continue
+		}
+		content, err := json.Marshal(proposal)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		_ = conn.Do(radix.Cmd(nil, "HSETNX", keyMergeProposals, proposal.Key(), string(content)))
+	}
+}
+
+// Connect creates a new connection pool to the Redis server
+func Connect(url string, size int) *Store {
+	redisPool, err := radix.NewPool("tcp", url, size, radix.PoolWithTrace(trace.PoolTrace{
+		DoCompleted: func(completed trace.PoolDoCompleted) {
+			metrics.ObserveRedisCommand(completed.ElapsedTime, completed.Err)
+		},
+	}))
+	if err != nil {
+		log.Fatal(err)
+	}
+	metrics.RegisterGauge("barcodeserver_redis_pool_available_connections", "Connections of the Redis pool that are not in use", func() float64 {
+		return float64(redisPool.NumAvailConns())
+	})
+	metrics.RegisterGauge("barcodeserver_redis_pool_size", "Configured size of the Redis pool", func() float64 {
+		return float64(size)
+	})
+	return &Store{redisPool: redisPool}
+}
+
+func (s *Store) LogNewRequest(ipAddr, uuid string, isUpload bool) int {
+	keyName := "requests:"
+	if isUpload {
+		keyName = "requests_upload:"
+	}
+	return s.logRequests(keyName, ipAddr, uuid, 1)
+}
+
+func (s *Store) LogNewRequests(ipAddr, uuid string, amount int) int {
+	return s.logRequests("requests:", ipAddr, uuid, amount)
+}
+
+func (s *Store) logRequests(keyName, ipAddr, uuid string, amount int) int {
+	var requests int
+	_ = s.redisPool.Do(radix.FlatCmd(&requests, "INCRBY", keyName+ipAddr, amount))
+	_ = s.redisPool.Do(radix.Cmd(nil, "EXPIRE", keyName+ipAddr, helper.GetSecondsToMidnight()))
+	_ = s.redisPool.Do(radix.Cmd(nil, "SADD", "users", uuid))
+	_ = s.redisPool.Do(radix.FlatCmd(nil, "ZADD", keyUsersLastSeen, time.Now().Unix(), uuid))
+	return requests
+}
+
+func (s *Store) GetBarcode(barcode string, increaseHit bool) []string {
+	var storedBarcodes []string
+
+	_ = s.redisPool.Do(radix.Cmd(&storedBarcodes, "ZREVRANGEBYSCORE", "barcode:"+barcode, "+inf", "-1"))
+	if increaseHit {
+		_ = s.redisPool.Do(radix.Pipeline(hitCommands([]string{barcode})...))
+	}
+	return storedBarcodes
+}
+
+// hitCommands returns the commands that count a lookup of every barcode in the all-time hits
+// and in the sorted set "hits:<day>" of today, which expires after storage.HitRetentionDays
+func hitCommands(barcodes []string) []radix.CmdAction {
+	dailyKey := "hits:" + storage.DayKey(time.Now())
+	commands := make([]radix.CmdAction, 0, len(barcodes)*2+1)
+	for _, barcode := range barcodes {
+		commands = append(commands, radix.Cmd(nil, "ZINCRBY", "hits", "1", barcode))
+		commands = append(commands, radix.Cmd(nil, "ZINCRBY", dailyKey, "1", barcode))
+	}
+	return append(commands, radix.FlatCmd(nil, "EXPIRE", dailyKey, (storage.HitRetentionDays+1)*24*60*60))
+}
+
+// GetBarcodes requests all barcodes in a single pipeline
+func (s *Store) GetBarcodes(barcodes []string, increaseHit bool) [][]string {
+	result := make([][]string, len(barcodes))
+	if len(barcodes) == 0 {
+		return result
+	}
+	commands := make([]radix.CmdAction, 0, len(barcodes))
+	for i, barcode := range barcodes {
+		commands = append(commands, radix.Cmd(&result[i], "ZREVRANGEBYSCORE", "barcode:"+barcode, "+inf", "-1"))
+	}
+	if increaseHit {
+		commands = append(commands, hitCommands(barcodes)...)
+	}
+	_ = s.redisPool.Do(radix.Pipeline(commands...))
+	return result
+}
+
+// LookupBarcodes requests all barcodes and counts all lookups in a single pipeline.
+// Misses are counted by a script, as they depend on the names that are read
+func (s *Store) LookupBarcodes(barcodes []string, uuid string) []storage.LookupResult {
+	result := make([]storage.LookupResult, len(barcodes))
+	if len(barcodes) == 0 {
+		return result
+	}
+	metadata := make([]map[string]float64, len(barcodes))
+	var counted []string
+	commands := make([]radix.CmdAction, 0, len(barcodes)*6+1)
+	for i, barcode := range barcodes {
+		commands = append(commands,
+			radix.Cmd(&result[i].Names, "ZREVRANGEBYSCORE", "barcode:"+barcode, "+inf", strconv.Itoa(storage.MinScoreListed)),
+			radix.Cmd(&metadata[i], "ZRANGE", "metadata:"+barcode, "0", "-1", "WITHSCORES"),
+			radix.Cmd(&result[i].Languages, "HGETALL", "language:"+barcode))
+		if !storage.IsScopedBarcode(barcode) {
+			counted = append(counted, barcode)
+			commands = append(commands, logMissCommand(barcode, uuid, "barcode:"+barcode))
+		}
+	}
+	if len(counted) > 0 {
+		commands = append(commands, hitCommands(counted)...)
+	}
+	_ = s.redisPool.Do(radix.Pipeline(commands...))
+	for i := range result {
+		if len(result[i].Names) == 0 {
+			result[i] = storage.LookupResult{}
+			continue
+		}
+		result[i].Metadata = storage.TopMetadata(metadata[i])
+	}
+	return result
+}
+
+func (s *Store) VoteName(barcode, name, ipAddr string) bool {
+	var voteCount int
+	_ = s.redisPool.Do(radix.Cmd(&voteCount, "INCR", "vote:"+ipAddr+":"+barcode+":"+name))
+	if voteCount != 1 {
+		return false
+	}
+	_ = s.redisPool.Do(radix.Cmd(nil, "INCR", keyTotalVotes))
+	var score float64
+	writeBarcode(s.redisPool, &score, barcode, "ZINCRBY", "1", name)
+	logChange(s.redisPool, storage.NewChange(storage.ChangeVote, barcode, name, score))
+	return true
+}
+
+func (s *Store) ReportName(barcode, name, ipAddr string) bool {
+	var reportCount int
+	_ = s.redisPool.Do(radix.Cmd(&reportCount, "INCR", "report:"+ipAddr+":"+barcode+":"+name))
+	if reportCount != 1 {
+		return false
+	}
+	_ = s.redisPool.Do(radix.Cmd(nil, "INCR", keyTotalReports))
+
+	// Checking score first, if "" is returned the item does not exist and would be created by ZINCRBY
+	var score string
+	_ = s.redisPool.Do(radix.Cmd(&score, "ZSCORE", "barcode:"+barcode, name))
+	if score != "" {
+		var newScore float64
+		writeBarcode(s.redisPool, &newScore, barcode, "ZINCRBY", "-2", name)
+		logChange(s.redisPool, storage.NewChange(storage.ChangeReport, barcode, name, newScore))
+		_ = s.redisPool.Do(radix.Cmd(nil, "ZINCRBY", "reported:"+barcode, "1", name))
+		_ = s.redisPool.Do(radix.Cmd(nil, "ZINCRBY", "reports", "1", barcode+":"+name))
+		return true
+	} else {
+		return false
+	}
+}
+
+func (s *Store) ProcessReport(report storage.Report, dismissReport bool) {
+	score := "-100"
+	changeType := storage.ChangeRemove
+	if dismissReport {
+		score = "1"
+		changeType = storage.ChangeDismiss
+	}
+	barcode, name := storage.SplitReport(report)
+
+	writeBarcode(s.redisPool, nil, barcode, "ZADD", score, name)
+	newScore, _ := strconv.ParseFloat(score, 64)
+	logChange(s.redisPool, storage.NewChange(changeType, barcode, name, newScore))
+	_ = s.redisPool.Do(radix.Cmd(nil, "ZREM", "reported:"+barcode, name))
+	_ = s.redisPool.Do(radix.Cmd(nil, "ZREM", "reports", report.BarcodeAndName))
+}
+
+func (s *Store) AddGrocyBarcodes(barcodes storage.GrocyBarcodes, uuid, source string) {
+	key := "grocyBarcodes"
+	_ = s.redisPool.Do(radix.WithConn(key, func(conn radix.Conn) error {
+		for _, barcode := range barcodes.Barcodes {
+			sanitized, ok := storage.SanitizeBarcode(barcode)
+			if ok {
+				var storedNames map[string]float64
+				_ = conn.Do(radix.Cmd(&storedNames, "ZRANGE", "barcode:"+sanitized.Barcode, "0", "-1", "WITHSCORES"))
+				equivalentName, exists := storage.FindEquivalentName(storedNames, sanitized.Name)
+				if exists {
+					sanitized.Name = equivalentName
+				}
+				var added int
+				writeBarcode(conn, &added, sanitized.Barcode, "ZADD", "NX", "1", sanitized.Name)
+				nameLanguage := storage.NameLanguage(sanitized)
+				if nameLanguage != "" {
+					_ = conn.Do(radix.Cmd(nil, "HSETNX", "language:"+sanitized.Barcode, sanitized.Name, nameLanguage))
+				}
+				if added == 1 {
+					_ = conn.Do(radix.Cmd(nil, "HSET", "source:"+sanitized.Barcode, sanitized.Name, source))
+					logChange(conn, storage.NewNameChange(storage.ChangeAdd, sanitized.Barcode, sanitized.Name, nameLanguage, 1))
+					addMergeProposals(conn, storage.FindMergeProposalsForName(sanitized.Barcode, storedNames, sanitized.Name, 1))
+				}
+				addMetadata(conn, sanitized.Barcode, sanitized.Metadata, source)
+				_ = conn.Do(radix.FlatCmd(nil, "SET", "log:uuid:"+sanitized.Barcode+":"+sanitized.Name, uuid, "EX", storage.TimespanUploadLog))
+			}
+		}
+		return nil
+	}))
+}
+
+// GetNameLanguages requests the languages of all barcodes in a single pipeline
+func (s *Store) GetNameLanguages(barcodes []string) []map[string]string {
+	result := make([]map[string]string, len(barcodes))
+	if len(barcodes) == 0 {
+		return result
+	}
+	commands := make([]radix.CmdAction, len(barcodes))
+	for i, barcode := range barcodes {
+		commands[i] = radix.Cmd(&result[i], "HGETALL", "language:"+barcode)
+	}
+	_ = s.redisPool.Do(radix.Pipeline(commands...))
+	return result
+}
+
+// GetMetadata requests the metadata of all barcodes in a single pipeline
+func (s *Store) GetMetadata(barcodes []string) []storage.Metadata {
+	result := make([]storage.Metadata, len(barcodes))
+	if len(barcodes) == 0 {
+		return result
+	}
+	scores := make([]map[string]float64, len(barcodes))
+	commands := make([]radix.CmdAction, len(barcodes))
+	for i, barcode := range barcodes {
+		commands[i] = radix.Cmd(&scores[i], "ZRANGE", "metadata:"+barcode, "0", "-1", "WITHSCORES")
+	}
+	_ = s.redisPool.Do(radix.Pipeline(commands...))
+	for i := range barcodes {
+		result[i] = storage.TopMetadata(scores[i])
+	}
+	return result
+}
+
+func (s *Store) VoteMetadata(barcode, field, value, ipAddr string) bool {
+	key := storage.MetadataKey(field, value)
+	var voteCount int
+	_ = s.redisPool.Do(radix.Cmd(&voteCount, "INCR", "votemeta:"+ipAddr+":"+barcode+":"+key))
+	if voteCount != 1 {
+		return false
+	}
+	var score float64
+	_ = s.redisPool.Do(radix.Cmd(&score, "ZINCRBY", "metadata:"+barcode, "1", key))
+	touchBarcode(s.redisPool, barcode)
+	logChange(s.redisPool, storage.NewMetadataChange(storage.ChangeVote, barcode, field, value, score))
+	return true
+}
+
+// LogLookupMisses counts every uuid once per day in the sorted set "misses:<day>", the
+// counted lookups are stored in the set "missusers:<day>". Both expire after storage.MissRetentionDays.
+// All misses are counted in a single pipeline
+func (s *Store) LogLookupMisses(barcodes []string, uuid string) {
+	if len(barcodes) == 0 {
+		return
+	}
+	commands := make([]radix.CmdAction, len(barcodes))
+	for i, barcode := range barcodes {
+		commands[i] = logMissCommand(barcode, uuid, "")
+	}
+	_ = s.redisPool.Do(radix.Pipeline(commands...))
+}
+
+func (s *Store) GetLookupMisses(days int) map[string]float64 {
+	return s.sumDailyBuckets("misses:", storage.LastDays(days))
+}
+
+func (s *Store) GetDailyHits(days []string) map[string]float64 {
+	return s.sumDailyBuckets("hits:", days)
+}
+
+// sumDailyBuckets requests the sorted sets prefix+day of all days in a single pipeline
+// and sums up the scores of every member
+func (s *Store) sumDailyBuckets(prefix string, days []string) map[string]float64 {
+	buckets := make([]map[string]float64, len(days))
+	commands := make([]radix.CmdAction, len(days))
+	for i, day := range days {
+		commands[i] = radix.Cmd(&buckets[i], "ZRANGE", prefix+day, "0", "-1", "WITHSCORES")
+	}
+	result := make(map[string]float64)
+	if len(commands) == 0 {
+		return result
+	}
+	_ = s.redisPool.Do(radix.Pipeline(commands...))
+	for _, bucket := range buckets {
+		for member, score := range bucket {
+			result[member] += score
+		}
+	}
+	return result
+}
+
+func (s *Store) GetMergeProposals() []storage.MergeProposal {
+	var entries map[string]string
+	_ = s.redisPool.Do(radix.Cmd(&entries, "HGETALL", keyMergeProposals))
+	result := make([]storage.MergeProposal, 0, len(entries))
+	for _, entry := range entries {
+		var proposal storage.MergeProposal
+		err := json.Unmarshal([]byte(entry), &proposal)
+		if err == nil {
+			result = append(result, proposal)
+		}
+	}
+	return storage.SortMergeProposals(result)
+}
+
+func (s *Store) AddMergeProposals(proposals []storage.MergeProposal) {
+	addMergeProposals(s.redisPool, proposals)
+}
+
+func (s *Store) ProcessMergeProposal(proposal storage.MergeProposal, accept bool) {
+	_ = s.redisPool.Do(radix.Cmd(nil, "HDEL", keyMergeProposals, proposal.Key()))
+	if !accept {
+		_ = s.redisPool.Do(radix.Cmd(nil, "SADD", keyDismissedMerges, proposal.Key()))
+		return
+	}
+	barcode := proposal.Barcode
+	var nameScore, targetScore string
+	_ = s.redisPool.Do(radix.Cmd(&nameScore, "ZSCORE", "barcode:"+barcode, proposal.Name))
+	_ = s.redisPool.Do(radix.Cmd(&targetScore, "ZSCORE", "barcode:"+barcode, proposal.Target))
+	parsedNameScore, errName := strconv.ParseFloat(nameScore, 64)
+	parsedTargetScore, errTarget := strconv.ParseFloat(targetScore, 64)
+	if errName != nil || errTarget != nil || parsedNameScore < storage.MinScoreListed || parsedTargetScore < storage.MinScoreListed {
+		// One of the names has been removed since the proposal was made
+		return
+	}
+	mergedScore := storage.MergedScore(parsedTargetScore, parsedNameScore)
+	writeBarcode(s.redisPool, nil, barcode, "ZADD", storage.FormatScore(mergedScore), proposal.Target)
+	logChange(s.redisPool, storage.NewChange(storage.ChangeMerge, barcode, proposal.Target, mergedScore))
+	writeBarcode(s.redisPool, nil, barcode, "ZADD", storage.FormatScore(storage.ScoreRemoved), proposal.Name)
+	logChange(s.redisPool, storage.NewChange(storage.ChangeRemove, barcode, proposal.Name, storage.ScoreRemoved))
+	_ = s.redisPool.Do(radix.Cmd(nil, "ZREM", "reported:"+barcode, proposal.Name))
+	_ = s.redisPool.Do(radix.Cmd(nil, "ZREM", "reports", barcode+":"+proposal.Name))
+	for _, other := range s.GetMergeProposals() {
+		if other.Contains(barcode, proposal.Name) {
+			_ = s.redisPool.Do(radix.Cmd(nil, "HDEL", keyMergeProposals, other.Key()))
+		}
+	}
+}
+
+func (s *Store) Ping() error {
+	return s.redisPool.Do(radix.Cmd(nil, "PING"))
+}
+
+func (s *Store) GetTotalBarcodes() int {
+	return s.getCounter(keyTotalBarcodes)
+}
+
+func (s *Store) GetTotalVotes() int {
+	return s.getCounter(keyTotalVotes)
+}
+
+func (s *Store) GetTotalActiveUsers() int {
+	var amount int
+	_ = s.redisPool.Do(radix.FlatCmd(&amount, "ZCOUNT", keyUsersLastSeen, activeUsersMinScore(), "+inf"))
+	return amount
+}
+
+func (s *Store) GetTotalReports() int {
+	return s.getCounter(keyTotalReports)
+}
+
+func (s *Store) getCounter(key string) int {
+	var amount int
+	_ = s.redisPool.Do(radix.Cmd(&amount, "GET", key))
+	return amount
+}
+
+// activeUsersMinScore returns the unix time a user must have been seen after to count as active
+func activeUsersMinScore() int64 {
+	return time.Now().Unix() - storage.TimespanActiveUser
+}
+
+// ReconcileStatistics recounts all keys with SCAN and corrects the counters, to repair
+// drift caused by concurrent writes or data written by older versions. The difference to
+// the counter value before the scan is added with INCRBY, so that counter changes made
+// during the scan are kept
+func (s *Store) ReconcileStatistics() {
+	counters := map[string]string{
+		keyTotalBarcodes: "barcode:*",
+		keyTotalVotes:    "vote:*",
+		keyTotalReports:  "report:*",
+	}
+	for counter, pattern := range counters {
+		before := s.getCounter(counter)
+		amount, err := s.countKeys(pattern)
+		if err != nil {
+			log.Println("Unable to reconcile " + counter + ": " + err.Error())
+			continue
+		}
+		if amount != before {
+			_ = s.redisPool.Do(radix.FlatCmd(nil, "INCRBY", counter, amount-before))
+		}
+	}
+	s.migrateActiveUsers()
+	_ = s.redisPool.Do(radix.FlatCmd(nil, "ZREMRANGEBYSCORE", keyUsersLastSeen, "-inf", "("+strconv.FormatInt(activeUsersMinScore(), 10)))
+}
+
+// countKeys returns the amount of keys matching pattern, without blocking Redis
+func (s *Store) countKeys(pattern string) (int, error) {
+	amount := 0
+	var key string
+	scanner := radix.NewScanner(s.redisPool, radix.ScanOpts{Command: "SCAN", Pattern: pattern, Count: scanCount})
+	for scanner.Next(&key) {
+		amount++
+	}
+	return amount, scanner.Close()
+}
+
+// migrateActiveUsers adds users:active: keys, which were used by older versions
+// to track active users, to the last seen sorted set
+func (s *Store) migrateActiveUsers() {
+	var key string
+	scanner := radix.NewScanner(s.redisPool, radix.ScanOpts{Command: "SCAN", Pattern: "users:active:*", Count: scanCount})
+	for scanner.Next(&key) {
+		var ttl int64
+		_ = s.redisPool.Do(radix.Cmd(&ttl, "TTL", key))
+		if ttl < 0 {
+			continue
+		}
+		lastSeen := time.Now().Unix() - (storage.TimespanActiveUser - ttl)
+		_ = s.redisPool.Do(radix.FlatCmd(nil, "ZADD", keyUsersLastSeen, "NX", lastSeen, strings.TrimPrefix(key, "users:active:")))
+	}
+	_ = scanner.Close()
+}
+
+func (s *Store) GetReportList() []storage.Report {
+	var reports []string
+	var result []storage.Report
+	_ = s.redisPool.Do(radix.Cmd(&reports, "ZREVRANGEBYSCORE", "reports", "+inf", "0", "WITHSCORES"))
+	length := len(reports)
+	for i := 0; i <= length-1; i = i + 2 {
+		result = append(result, storage.Report{
+			Id:             i,
+			BarcodeAndName: reports[i],
+			ReportCount:    reports[i+1],
+		})
+	}
+	return result
+}
+
+func (s *Store) GetMostPopularBarcodes() []storage.TopBarcode {
+	var barcodes []string
+	var result []storage.TopBarcode
+	_ = s.redisPool.Do(radix.Cmd(&barcodes, "ZREVRANGEBYSCORE", "hits", "+inf", "1", "WITHSCORES", "LIMIT", "0", "50"))
+	length := len(barcodes)
+	for i := 0; i <= length-1; i = i + 2 {
+		result = append(result, storage.TopBarcode{
+			Barcode: barcodes[i],
+			Hits:    barcodes[i+1],
+			Names:   storage.JoinNames(s.GetBarcode(barcodes[i], false)),
+		})
+	}
+	return result
+}
+
+func (s *Store) GetTotalUsers() int {
+	var result int
+	_ = s.redisPool.Do(radix.Cmd(&result, "SCARD", "users"))
+	return result
+}
+
+func (s *Store) GetRamUsage() uint64 {
+	var result []string
+	_ = s.redisPool.Do(radix.Cmd(&result, "MEMORY", "STATS"))
+	for i, item := range result {
+		if item == "total.allocated" && i+1 < len(result) {
+			totalAmount, _ := strconv.ParseUint(result[i+1], 10, 64)
+			return totalAmount
+		}
+	}
+	return 0
+}
+
+func (s *Store) DeleteBarcode(barcode string) {
+	s.removeBarcode(barcode)
+	_ = s.redisPool.Do(radix.Cmd(nil, "ZREM", "hits", barcode))
+	_ = s.redisPool.Do(radix.Cmd(nil, "ZREM", keyUpdated, barcode))
+	logChange(s.redisPool, storage.NewChange(storage.ChangeClear, barcode, "", 0))
+}
+
+func (s *Store) ImportBarcode(record storage.ExportRecord, mode storage.ImportMode) {
+	localScores := make(map[string]float64)
+	localMetadata := make(map[string]float64)
+	if mode == storage.ImportReplace {
+		s.removeBarcode(record.Barcode)
+		logChange(s.redisPool, storage.NewChange(storage.ChangeClear, record.Barcode, "", 0))
+	} else {
+		_ = s.redisPool.Do(radix.Cmd(&localScores, "ZRANGE", "barcode:"+record.Barcode, "0", "-1", "WITHSCORES"))
+		_ = s.redisPool.Do(radix.Cmd(&localMetadata, "ZRANGE", "metadata:"+record.Barcode, "0", "-1", "WITHSCORES"))
+	}
+	s.importMetadata(record, mode, localMetadata)
+	for _, name := range record.Names {
+		if name.Language != "" {
+			_ = s.redisPool.Do(radix.Cmd(nil, "HSETNX", "language:"+record.Barcode, name.Name, name.Language))
+		}
+		localScore, exists := localScores[name.Name]
+		score, isChanged := storage.ImportedScore(mode, localScore, exists, name.Score)
+		if !isChanged {
+			continue
+		}
+		writeBarcode(s.redisPool, nil, record.Barcode, "ZADD", storage.FormatScore(score), name.Name)
+		logChange(s.redisPool, storage.NewNameChange(storage.ChangeImport, record.Barcode, name.Name, name.Language, score))
+		if exists {
+			continue
+		}
+		if name.Source != "" {
+			_ = s.redisPool.Do(radix.Cmd(nil, "HSET", "source:"+record.Barcode, name.Name, name.Source))
+		}
+		if name.Reports > 0 {
+			_ = s.redisPool.Do(radix.FlatCmd(nil, "ZADD", "reported:"+record.Barcode, name.Reports, name.Name))
+			_ = s.redisPool.Do(radix.FlatCmd(nil, "ZADD", "reports", name.Reports, record.Barcode+":"+name.Name))
+		}
+	}
+	var hits int
+	_ = s.redisPool.Do(radix.Cmd(&hits, "ZSCORE", "hits", record.Barcode))
+	if record.Hits > hits || (mode == storage.ImportReplace && record.Hits != hits) {
+		_ = s.redisPool.Do(radix.FlatCmd(nil, "ZADD", "hits", record.Hits, record.Barcode))
+	}
+}
+
+// importMetadata stores the metadata values of an imported barcode
+func (s *Store) importMetadata(record storage.ExportRecord, mode storage.ImportMode, localScores map[string]float64) {
+	for _, metadata := range record.Metadata {
+		key := storage.MetadataKey(metadata.Field, metadata.Value)
+		localScore, exists := localScores[key]
+		score, isChanged := storage.ImportedScore(mode, localScore, exists, metadata.Score)
+		if !isChanged {
+			continue
+		}
+		_ = s.redisPool.Do(radix.Cmd(nil, "ZADD", "metadata:"+record.Barcode, storage.FormatScore(score), key))
+		touchBarcode(s.redisPool, record.Barcode)
+		logChange(s.redisPool, storage.NewMetadataChange(storage.ChangeImport, record.Barcode, metadata.Field, metadata.Value, score))
+		if !exists && metadata.Source != "" {
+			_ = s.redisPool.Do(radix.Cmd(nil, "HSET", "metasource:"+record.Barcode, key, metadata.Source))
+		}
+	}
+}
+
+// removeBarcode deletes all names and metadata of a barcode, including their sources, languages and reports
+func (s *Store) removeBarcode(barcode string) {
+	var reportedNames []string
+	_ = s.redisPool.Do(radix.Cmd(&reportedNames, "ZRANGE", "reported:"+barcode, "0", "-1"))
+	for _, name := range reportedNames {
+		_ = s.redisPool.Do(radix.Cmd(nil, "ZREM", "reports", barcode+":"+name))
+	}
+	var deleted int
+	_ = s.redisPool.Do(radix.Cmd(&deleted, "DEL", "barcode:"+barcode))
+	if deleted == 1 {
+		_ = s.redisPool.Do(radix.Cmd(nil, "DECR", keyTotalBarcodes))
+	}
+	_ = s.redisPool.Do(radix.Cmd(nil, "DEL", "reported:"+barcode, "source:"+barcode, "metadata:"+barcode, "metasource:"+barcode, "language:"+barcode))
+	_ = s.redisPool.Do(radix.FlatCmd(nil, "ZADD", keyUpdated, time.Now().Unix(), barcode))
+}
+
+func (s *Store) AddMetricsSnapshot(snapshot storage.MetricsSnapshot) {
+	value, err := json.Marshal(snapshot)
+	if err != nil {
+		return
+	}
+	_ = s.redisPool.Do(radix.FlatCmd(nil, "ZADD", keyMetrics, snapshot.Time, value))
+}
+
+func (s *Store) GetMetricsSnapshots(since int64) []storage.MetricsSnapshot {
+	var values []string
+	_ = s.redisPool.Do(radix.FlatCmd(&values, "ZRANGEBYSCORE", keyMetrics, "("+strconv.FormatInt(since, 10), "+inf"))
+	result := make([]storage.MetricsSnapshot, 0, len(values))
+	for _, value := range values {
+		var snapshot storage.MetricsSnapshot
+		if json.Unmarshal([]byte(value), &snapshot) == nil {
+			result = append(result, snapshot)
+		}
+	}
+	return result
+}
+
+func (s *Store) RemoveMetricsSnapshots(before int64) {
+	_ = s.redisPool.Do(radix.FlatCmd(nil, "ZREMRANGEBYSCORE", keyMetrics, "-inf", "("+strconv.FormatInt(before, 10)))
+}
+
+func (s *Store) GetSyncState(key string) int64 {
+	var result int64
+	_ = s.redisPool.Do(radix.Cmd(&result, "HGET", keySyncState, key))
+	return result
+}
+
+func (s *Store) SetSyncState(key string, value int64) {
+	_ = s.redisPool.Do(radix.FlatCmd(nil, "HSET", keySyncState, key, value))
+}
+
+func (s *Store) GetChanges(since int64, limit int) storage.ChangeLog {
+	result := storage.ChangeLog{}
+	var oldest, changes []string
+	_ = s.redisPool.Do(radix.Pipeline(
+		radix.Cmd(&result.LatestCursor, "GET", keyChangeCursor),
+		radix.Cmd(&oldest, "ZRANGE", keyChanges, "0", "0"),
+		radix.FlatCmd(&changes, "ZRANGEBYSCORE", keyChanges, "("+strconv.FormatInt(since, 10), "+inf", "LIMIT", 0, limit)))
+	if len(oldest) > 0 {
+		result.OldestCursor, _ = parseChange(oldest[0])
+	}
+	for _, entry := range changes {
+		cursor, content := parseChange(entry)
+		var change storage.Change
+		if json.Unmarshal([]byte(content), &change) != nil {
+			continue
+		}
+		change.Cursor = cursor
+		result.Changes = append(result.Changes, change)
+	}
+	return result
+}
+
+// parseChange splits an entry of the change log into its cursor and content
+func parseChange(entry string) (int64, string) {
+	cursor, content, _ := strings.Cut(entry, ":")
+	result, _ := strconv.ParseInt(cursor, 10, 64)
+	return result, content
+}
+
+// ExportBarcodes iterates over all barcodes with SCAN, followed by the barcodes that
+// only have metadata. If only changed barcodes are requested, the sorted set of changes
+// is used instead. The data of each batch of barcodes is requested in a single pipeline
+func (s *Store) ExportBarcodes(filter storage.ExportFilter, exportFunc storage.ExportFunc) error {
+	if filter.ChangedSince != 0 {
+		return s.exportChangedBarcodes(filter, exportFunc)
+	}
+	err := s.exportScanned("barcode:", filter, exportFunc)
+	if err != nil {
+		return err
+	}
+	return s.exportScanned("metadata:", filter, exportFunc)
+}
+
+// exportScanned exports the barcodes of all keys starting with prefix. Barcodes of
+// metadata keys that have names are skipped, as they are exported with their names
+func (s *Store) exportScanned(prefix string, filter storage.ExportFilter, exportFunc storage.ExportFunc) error {
+	var key string
+	barcodes := make([]string, 0, scanCount)
+	exportScannedBatch := func() error {
+		if prefix == "metadata:" {
+			return s.exportBatch(s.withoutNames(barcodes), filter, exportFunc)
+		}
+		return s.exportBatch(barcodes, filter, exportFunc)
+	}
+	scanner := radix.NewScanner(s.redisPool, radix.ScanOpts{Command: "SCAN", Pattern: prefix + "*", Count: scanCount})
+	for scanner.Next(&key) {
+		barcodes = append(barcodes, strings.TrimPrefix(key, prefix))
+		if len(barcodes) == scanCount {
+			err := exportScannedBatch()
+			if err != nil {
+				_ = scanner.Close()
+				return err
+			}
+			barcodes = barcodes[:0]
+		}
+	}
+	err := scanner.Close()
+	if err != nil {
+		return err
+	}
+	return exportScannedBatch()
+}
+
+// withoutNames returns the barcodes that do not have any names
+func (s *Store) withoutNames(barcodes []string) []string {
+	exists := make([]int, len(barcodes))
+	commands := make([]radix.CmdAction, len(barcodes))
+	for i, barcode := range barcodes {
+		commands[i] = radix.Cmd(&exists[i], "EXISTS", "barcode:"+barcode)
+	}
+	_ = s.redisPool.Do(radix.Pipeline(commands...))
+	var result []string
+	for i, barcode := range barcodes {
+		if exists[i] == 0 {
+			result = append(result, barcode)
+		}
+	}
+	return result
+}
+
+// exportChangedBarcodes pages through the updated set with the score of the last page as an
+// exclusive cursor, so that barcodes changed during the export do not shift the pages. All
+// barcodes with the last score of a page are read at once, as the next page starts after it
+func (s *Store) exportChangedBarcodes(filter storage.ExportFilter, exportFunc storage.ExportFunc) error {
+	minScore := "(" + strconv.FormatInt(filter.ChangedSince, 10)
+	for {
+		var entries []string
+		err := s.redisPool.Do(radix.FlatCmd(&entries, "ZRANGEBYSCORE", keyUpdated, minScore, "+inf", "WITHSCORES", "LIMIT", 0, scanCount))
+		if err != nil {
+			return err
+		}
+		if len(entries) < scanCount*2 {
+			return s.exportBatch(barcodesOfEntries(entries, ""), filter, exportFunc)
+		}
+		lastScore := entries[len(entries)-1]
+		barcodes := barcodesOfEntries(entries, lastScore)
+		var sameScore []string
+		err = s.redisPool.Do(radix.Cmd(&sameScore, "ZRANGEBYSCORE", keyUpdated, lastScore, lastScore))
+		if err != nil {
+			return err
+		}
+		barcodes = append(barcodes, sameScore...)
+		for start := 0; start < len(barcodes); start = start + scanCount {
+			end := start + scanCount
+			if end > len(barcodes) {
+				end = len(barcodes)
+			}
+			err = s.exportBatch(barcodes[start:end], filter, exportFunc)
+			if err != nil {
+				return err
+			}
+		}
+		minScore = "(" + lastScore
+	}
+}
+
+// barcodesOfEntries returns the members of a WITHSCORES reply, except the ones with excludedScore
+func barcodesOfEntries(entries []string, excludedScore string) []string {
+	result := make([]string, 0, len(entries)/2)
+	for i := 0; i < len(entries)-1; i = i + 2 {
+		if entries[i+1] != excludedScore {
+			result = append(result, entries[i])
+		}
+	}
+	return result
+}
+
+// exportedBarcode contains the replies of all commands that are required to export a barcode
+type exportedBarcode struct {
+	names           map[string]float64
+	reports         map[string]float64
+	sources         map[string]string
+	languages       map[string]string
+	metadata        map[string]float64
+	metadataSources map[string]string
+	hits            string
+	updated         string
+}
+
+func (s *Store) exportBatch(barcodes []string, filter storage.ExportFilter, exportFunc storage.ExportFunc) error {
+	if len(barcodes) == 0 {
+		return nil
+	}
+	replies := make([]exportedBarcode, len(barcodes))
+	commands := make([]radix.CmdAction, 0, len(barcodes)*8)
+	for i, barcode := range barcodes {
+		commands = append(commands,
+			radix.Cmd(&replies[i].names, "ZRANGE", "barcode:"+barcode, "0", "-1", "WITHSCORES"),
+			radix.Cmd(&replies[i].reports, "ZRANGE", "reported:"+barcode, "0", "-1", "WITHSCORES"),
+			radix.Cmd(&replies[i].sources, "HGETALL", "source:"+barcode),
+			radix.Cmd(&replies[i].languages, "HGETALL", "language:"+barcode),
+			radix.Cmd(&replies[i].metadata, "ZRANGE", "metadata:"+barcode, "0", "-1", "WITHSCORES"),
+			radix.Cmd(&replies[i].metadataSources, "HGETALL", "metasource:"+barcode),
+			radix.Cmd(&replies[i].hits, "ZSCORE", "hits", barcode),
+			radix.Cmd(&replies[i].updated, "ZSCORE", keyUpdated, barcode))
+	}
+	err := s.redisPool.Do(radix.Pipeline(commands...))
+	if err != nil {
+		return err
+	}
+	for i, barcode := range barcodes {
+		hits, _ := strconv.Atoi(replies[i].hits)
+		updated, _ := strconv.ParseInt(replies[i].updated, 10, 64)
+		record, ok := filter.Apply(storage.ExportRecord{
+			Barcode:  barcode,
+			Hits:     hits,
+			Updated:  updated,
+			Names:    storage.NewExportNames(replies[i].names, replies[i].reports, replies[i].sources, replies[i].languages),
+			Metadata: storage.NewExportMetadata(replies[i].metadata, replies[i].metadataSources),
+		})
+		if !ok {
+			continue
+		}
+		err = exportFunc(record)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,539 @@
+// Package storagetest contains tests that every implementation of storage.Store has to pass
+package storagetest
+
+import (
+	"BarcodeServer/internal/storage"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// NewStoreFunc returns a new, empty store for a single test
+type NewStoreFunc func(t *testing.T) storage.Store
+
+// Test barcodes with valid check digits
+const (
+	barcodeMilk   = "4006040000013"
+	barcodeButter = "4006040000020"
+	barcodeEan8   = "96385074"
+	uuid          = "abcdefghijabcdefghijabcdefghij12"
+	otherUuid     = "abcdefghijabcdefghijabcdefghij34"
+)
+
+// Run runs all conformance tests against the stores returned by newStore
+func Run(t *testing.T, newStore NewStoreFunc) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, store storage.Store)
+	}{
+		{"UnknownBarcode", testUnknownBarcode},
+		{"AddBarcodes", testAddBarcodes},
+		{"EquivalentNames", testEquivalentNames},
+		{"VoteName", testVoteName},
+		{"ReportName", testReportName},
+		{"ProcessReport", testProcessReport},
+		{"RequestCounters", testRequestCounters},
+		{"Users", testUsers},
+		{"ReconcileStatistics", testReconcileStatistics},
+		{"Metadata", testMetadata},
+		{"NameLanguages", testNameLanguages},
+		{"Hits", testHits},
+		{"PopularBarcodesLimit", testPopularBarcodesLimit},
+		{"LookupMisses", testLookupMisses},
+		{"LookupBarcodes", testLookupBarcodes},
+		{"MergeProposals", testMergeProposals},
+		{"DeleteBarcode", testDeleteBarcode},
+		{"ImportMerge", testImportMerge},
+		{"ImportReplace", testImportReplace},
+		{"ImportEmptyRecord", testImportEmptyRecord},
+		{"ImportReplaceEmptyRecord", testImportReplaceEmptyRecord},
+		{"ImportMetadataOnly", testImportMetadataOnly},
+		{"ExportBarcodes", testExportBarcodes},
+		{"Changes", testChanges},
+		{"SyncState", testSyncState},
+		{"MetricsSnapshots", testMetricsSnapshots},
+		{"Ping", testPing},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newStore(t))
+		})
+	}
+}
+
+func upload(store storage.Store, barcode, name string) {
+	store.AddGrocyBarcodes(storage.GrocyBarcodes{Barcodes: []storage.Barcode{{Barcode: barcode, Name: name}}}, uuid, storage.SourceUser)
+}
+
+func expectNames(t *testing.T, store storage.Store, barcode string, expected ...string) {
+	t.Helper()
+	names := store.GetBarcode(barcode, false)
+	if len(expected) == 0 && len(names) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("names of %s: expected %q, got %q", barcode, expected, names)
+	}
+}
+
+func expectInt(t *testing.T, description string, expected, actual int) {
+	t.Helper()
+	if expected != actual {
+		t.Errorf("%s: expected %d, got %d", description, expected, actual)
+	}
+}
+
+func exportRecord(t *testing.T, store storage.Store, barcode string) (storage.ExportRecord, bool) {
+	t.Helper()
+	var result storage.ExportRecord
+	found := false
+	filter := storage.ExportFilter{MinScore: -1000}
+	err := store.ExportBarcodes(filter, func(record storage.ExportRecord) error {
+		if record.Barcode == barcode {
+			result = record
+			found = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result, found
+}
+
+func testUnknownBarcode(t *testing.T, store storage.Store) {
+	expectNames(t, store, barcodeMilk)
+	expectInt(t, "total barcodes", 0, store.GetTotalBarcodes())
+}
+
+func testAddBarcodes(t *testing.T, store storage.Store) {
+	store.AddGrocyBarcodes(storage.GrocyBarcodes{Barcodes: []storage.Barcode{
+		{Barcode: barcodeMilk, Name: "Organic milk"},
+		{Barcode: barcodeEan8, Name: "Eight digits"},
+		{Barcode: "4006040000014", Name: "Invalid check digit"},
+		{Barcode: barcodeButter, Name: "x"},
+	}}, uuid, storage.SourceUser)
+	expectNames(t, store, barcodeMilk, "Organic milk")
+	expectNames(t, store, "00000"+barcodeEan8, "Eight digits")
+	expectNames(t, store, "4006040000014")
+	expectNames(t, store, barcodeButter)
+	expectInt(t, "total barcodes", 2, store.GetTotalBarcodes())
+
+	upload(store, barcodeMilk, "Milk")
+	expectNames(t, store, barcodeMilk, "Organic milk", "Milk")
+	expectInt(t, "total barcodes", 2, store.GetTotalBarcodes())
+
+	record, _ := exportRecord(t, store, barcodeMilk)
+	if record.Names[0].Source != storage.SourceUser {
+		t.Errorf("expected source %q, got %q", storage.SourceUser, record.Names[0].Source)
+	}
+}
+
+func testEquivalentNames(t *testing.T, store storage.Store) {
+	upload(store, barcodeMilk, "Alpine milk 100G")
+	upload(store, barcodeMilk, "alpine  milk 100 g")
+	expectNames(t, store, barcodeMilk, "Alpine milk 100 g")
+}
+
+func testVoteName(t *testing.T, store storage.Store) {
+	upload(store, barcodeMilk, "First name")
+	upload(store, barcodeMilk, "Second name")
+	if !store.VoteName(barcodeMilk, "Second name", "10.0.0.1") {
+		t.Fatal("first vote has not been counted")
+	}
+	if store.VoteName(barcodeMilk, "Second name", "10.0.0.1") {
+		t.Error("second vote of the same address has been counted")
+	}
+	expectNames(t, store, barcodeMilk, "Second name", "First name")
+	expectInt(t, "total votes", 1, store.GetTotalVotes())
+}
+
+func testReportName(t *testing.T, store storage.Store) {
+	upload(store, barcodeMilk, "Wrong name")
+	if store.ReportName(barcodeMilk, "Unknown name", "10.0.0.1") {
+		t.Error("report of an unknown name has been counted")
+	}
+	if !store.ReportName(barcodeMilk, "Wrong name", "10.0.0.2") {
+		t.Fatal("report has not been counted")
+	}
+	if store.ReportName(barcodeMilk, "Wrong name", "10.0.0.2") {
+		t.Error("second report of the same address has been counted")
+	}
+	// The score drops from 1 to -1, which is still listed
+	expectNames(t, store, barcodeMilk, "Wrong name")
+	store.ReportName(barcodeMilk, "Wrong name", "10.0.0.3")
+	expectNames(t, store, barcodeMilk)
+
+	reports := store.GetReportList()
+	if len(reports) != 1 || reports[0].BarcodeAndName != barcodeMilk+":Wrong name" || reports[0].ReportCount != "2" {
+		t.Errorf("unexpected report list %+v", reports)
+	}
+}
+
+func testProcessReport(t *testing.T, store storage.Store) {
+	upload(store, barcodeMilk, "Wrong name")
+	upload(store, barcodeButter, "Right name")
+	store.ReportName(barcodeMilk, "Wrong name", "10.0.0.1")
+	store.ReportName(barcodeButter, "Right name", "10.0.0.1")
+
+	for _, report := range store.GetReportList() {
+		barcode, _ := storage.SplitReport(report)
+		store.ProcessReport(report, barcode == barcodeButter)
+	}
+	expectInt(t, "reports after processing", 0, len(store.GetReportList()))
+	expectNames(t, store, barcodeMilk)
+	expectNames(t, store, barcodeButter, "Right name")
+
+	// Removed names are not added again by uploads
+	upload(store, barcodeMilk, "Wrong name")
+	expectNames(t, store, barcodeMilk)
+}
+
+func testRequestCounters(t *testing.T, store storage.Store) {
+	expectInt(t, "first request", 1, store.LogNewRequest("10.0.0.1", uuid, false))
+	expectInt(t, "second request", 2, store.LogNewRequest("10.0.0.1", uuid, false))
+	expectInt(t, "batch of requests", 12, store.LogNewRequests("10.0.0.1", uuid, 10))
+	expectInt(t, "request of another address", 1, store.LogNewRequest("10.0.0.2", uuid, false))
+	expectInt(t, "first upload", 1, store.LogNewRequest("10.0.0.1", uuid, true))
+}
+
+func testUsers(t *testing.T, store storage.Store) {
+	expectInt(t, "users", 0, store.GetTotalUsers())
+	store.LogNewRequest("10.0.0.1", uuid, false)
+	store.LogNewRequest("10.0.0.1", uuid, false)
+	store.LogNewRequests("10.0.0.2", otherUuid, 3)
+	expectInt(t, "users", 2, store.GetTotalUsers())
+	expectInt(t, "active users", 2, store.GetTotalActiveUsers())
+}
+
+func testReconcileStatistics(t *testing.T, store storage.Store) {
+	upload(store, barcodeMilk, "Milk")
+	upload(store, barcodeButter, "Butter")
+	store.VoteName(barcodeMilk, "Milk", "10.0.0.1")
+	store.ReportName(barcodeButter, "Butter", "10.0.0.1")
+	store.LogNewRequest("10.0.0.1", uuid, false)
+	before := []int{store.GetTotalBarcodes(), store.GetTotalVotes(), store.GetTotalReports(), store.GetTotalUsers(), store.GetTotalActiveUsers()}
+	store.ReconcileStatistics()
+	after := []int{store.GetTotalBarcodes(), store.GetTotalVotes(), store.GetTotalReports(), store.GetTotalUsers(), store.GetTotalActiveUsers()}
+	if !reflect.DeepEqual(before, []int{2, 1, 1, 1, 1}) {
+		t.Errorf("unexpected statistics %v", before)
+	}
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("statistics changed by reconciling: %v, %v", before, after)
+	}
+}
+
+func testMetadata(t *testing.T, store storage.Store) {
+	store.AddGrocyBarcodes(storage.GrocyBarcodes{Barcodes: []storage.Barcode{{
+		Barcode:  barcodeMilk,
+		Name:     "Organic milk",
+		Metadata: storage.Metadata{Brand: "Example", Quantity: 1, Unit: "l"},
+	}}}, uuid, storage.SourceUser)
+	upload(store, barcodeButter, "Butter")
+
+	metadata := store.GetMetadata([]string{barcodeMilk, barcodeButter})
+	expected := []storage.Metadata{{Brand: "Example", Quantity: 1, Unit: "l"}, {}}
+	if !reflect.DeepEqual(metadata, expected) {
+		t.Errorf("expected metadata %+v, got %+v", expected, metadata)
+	}
+
+	if !store.VoteMetadata(barcodeMilk, storage.FieldBrand, "Other brand", "10.0.0.1") {
+		t.Fatal("first metadata vote has not been counted")
+	}
+	if store.VoteMetadata(barcodeMilk, storage.FieldBrand, "Other brand", "10.0.0.1") {
+		t.Error("second metadata vote of the same address has been counted")
+	}
+	store.VoteMetadata(barcodeMilk, storage.FieldBrand, "Other brand", "10.0.0.2")
+	if brand := store.GetMetadata([]string{barcodeMilk})[0].Brand; brand != "Other brand" {
+		t.Errorf("expected the voted brand, got %q", brand)
+	}
+}
+
+func testNameLanguages(t *testing.T, store storage.Store) {
+	store.AddGrocyBarcodes(storage.GrocyBarcodes{Barcodes: []storage.Barcode{
+		{Barcode: barcodeMilk, Name: "Milk", Metadata: storage.Metadata{Language: "en"}},
+		{Barcode: barcodeMilk, Name: "Milch", Metadata: storage.Metadata{Language: "de"}},
+	}}, uuid, storage.SourceUser)
+	languages := store.GetNameLanguages([]string{barcodeMilk, barcodeButter})
+	if len(languages) != 2 || languages[0]["Milk"] != "en" || languages[0]["Milch"] != "de" || len(languages[1]) != 0 {
+		t.Errorf("unexpected languages %v", languages)
+	}
+}
+
+func testHits(t *testing.T, store storage.Store) {
+	upload(store, barcodeMilk, "Milk")
+	store.GetBarcode(barcodeMilk, true)
+	store.GetBarcodes([]string{barcodeMilk, barcodeButter}, true)
+	store.GetBarcode(barcodeMilk, false)
+
+	popular := store.GetMostPopularBarcodes()
+	if len(popular) == 0 || popular[0].Barcode != barcodeMilk || popular[0].Hits != "2" {
+		t.Errorf("unexpected popular barcodes %+v", popular)
+	}
+	hits := store.GetDailyHits(storage.LastDays(1))
+	if hits[barcodeMilk] != 2 {
+		t.Errorf("expected 2 hits today, got %v", hits)
+	}
+}
+
+func testPopularBarcodesLimit(t *testing.T, store storage.Store) {
+	barcodes := make([]string, storage.AmountTopBarcodes+1)
+	for i := range barcodes {
+		barcodes[i] = fmt.Sprintf("%013d", i)
+		store.ImportBarcode(storage.ExportRecord{
+			Barcode: barcodes[i],
+			Hits:    i + 1,
+			Names:   []storage.ExportName{{Name: "Name", Score: 1}},
+		}, storage.ImportMerge)
+	}
+	popular := store.GetMostPopularBarcodes()
+	expectInt(t, "popular barcodes", storage.AmountTopBarcodes, len(popular))
+	if popular[0].Barcode != barcodes[len(barcodes)-1] || popular[len(popular)-1].Hits != "2" {
+		t.Errorf("unexpected popular barcodes %+v", popular)
+	}
+
+	// The barcode with a single hit replaces the one with two hits
+	store.GetBarcode(barcodes[0], true)
+	store.GetBarcode(barcodes[0], true)
+	hits := make(map[string]string)
+	for _, barcode := range store.GetMostPopularBarcodes() {
+		hits[barcode.Barcode] = barcode.Hits
+	}
+	if hits[barcodes[0]] != "3" || hits[barcodes[1]] != "" {
+		t.Errorf("unexpected popular barcodes %v", hits)
+	}
+
+	store.DeleteBarcode(barcodes[len(barcodes)-1])
+	store.ReconcileStatistics()
+	popular = store.GetMostPopularBarcodes()
+	expectInt(t, "popular barcodes after deleting", storage.AmountTopBarcodes, len(popular))
+	if popular[0].Barcode != barcodes[len(barcodes)-2] {
+		t.Errorf("deleted barcode is still listed: %+v", popular[0])
+	}
+}
+
+func testLookupMisses(t *testing.T, store storage.Store) {
+	store.LogLookupMisses([]string{barcodeMilk, barcodeButter}, uuid)
+	store.LogLookupMisses([]string{barcodeMilk}, uuid)
+	store.LogLookupMisses([]string{barcodeMilk}, otherUuid)
+	misses := store.GetLookupMisses(1)
+	expected := map[string]float64{barcodeMilk: 2, barcodeButter: 1}
+	if !reflect.DeepEqual(misses, expected) {
+		t.Errorf("expected misses %v, got %v", expected, misses)
+	}
+}
+
+func testLookupBarcodes(t *testing.T, store storage.Store) {
+	scoped := storage.ScopedBarcode("2112345000008", uuid)
+	store.AddGrocyBarcodes(storage.GrocyBarcodes{Barcodes: []storage.Barcode{
+		{Barcode: barcodeMilk, Name: "Milch", Metadata: storage.Metadata{Brand: "Example", Language: "de"}},
+		{Barcode: scoped, Name: "Cheese"},
+	}}, uuid, storage.SourceUser)
+
+	results := store.LookupBarcodes([]string{barcodeMilk, barcodeButter, scoped, storage.ScopedBarcode("2112345000008", otherUuid)}, uuid)
+	if len(results) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(results))
+	}
+	if !reflect.DeepEqual(results[0].Names, []string{"Milch"}) || results[0].Metadata.Brand != "Example" || results[0].Languages["Milch"] != "de" {
+		t.Errorf("unexpected result %+v", results[0])
+	}
+	if len(results[1].Names) != 0 || len(results[3].Names) != 0 {
+		t.Errorf("unexpected names of unknown barcodes %q, %q", results[1].Names, results[3].Names)
+	}
+	if !reflect.DeepEqual(results[2].Names, []string{"Cheese"}) {
+		t.Errorf("unexpected names of the scoped barcode %q", results[2].Names)
+	}
+
+	store.LookupBarcodes([]string{barcodeButter}, uuid)
+	expectedMisses := map[string]float64{barcodeButter: 1}
+	if misses := store.GetLookupMisses(1); !reflect.DeepEqual(misses, expectedMisses) {
+		t.Errorf("expected misses %v, got %v", expectedMisses, misses)
+	}
+	expectedHits := map[string]float64{barcodeMilk: 1, barcodeButter: 2}
+	if hits := store.GetDailyHits(storage.LastDays(1)); !reflect.DeepEqual(hits, expectedHits) {
+		t.Errorf("expected hits %v, got %v", expectedHits, hits)
+	}
+}
+
+func testMergeProposals(t *testing.T, store storage.Store) {
+	upload(store, barcodeMilk, "Organic whole milk")
+	store.VoteName(barcodeMilk, "Organic whole milk", "10.0.0.1")
+	upload(store, barcodeMilk, "Organic whole mlik")
+	proposals := store.GetMergeProposals()
+	if len(proposals) != 1 || proposals[0].Name != "Organic whole mlik" || proposals[0].Target != "Organic whole milk" {
+		t.Fatalf("unexpected proposals %+v", proposals)
+	}
+	store.ProcessMergeProposal(proposals[0], true)
+	expectNames(t, store, barcodeMilk, "Organic whole milk")
+	expectInt(t, "proposals after merging", 0, len(store.GetMergeProposals()))
+	record, _ := exportRecord(t, store, barcodeMilk)
+	if record.Names[0].Score != 3 {
+		t.Errorf("expected the merged score 3, got %v", record.Names[0].Score)
+	}
+
+	upload(store, barcodeButter, "Salted butter 250 g")
+	upload(store, barcodeButter, "Salted buter 250 g")
+	proposals = store.GetMergeProposals()
+	if len(proposals) != 1 {
+		t.Fatalf("unexpected proposals %+v", proposals)
+	}
+	store.ProcessMergeProposal(proposals[0], false)
+	store.AddMergeProposals(proposals)
+	expectInt(t, "proposals after dismissing", 0, len(store.GetMergeProposals()))
+	// Both names have the same score, so their order is not defined
+	names := store.GetBarcode(barcodeButter, false)
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"Salted buter 250 g", "Salted butter 250 g"}) {
+		t.Errorf("dismissed names have been changed: %q", names)
+	}
+}
+
+func testDeleteBarcode(t *testing.T, store storage.Store) {
+	upload(store, barcodeMilk, "Milk")
+	upload(store, barcodeButter, "Butter")
+	store.ReportName(barcodeMilk, "Milk", "10.0.0.1")
+	store.DeleteBarcode(barcodeMilk)
+	expectNames(t, store, barcodeMilk)
+	expectNames(t, store, barcodeButter, "Butter")
+	expectInt(t, "total barcodes", 1, store.GetTotalBarcodes())
+	expectInt(t, "reports", 0, len(store.GetReportList()))
+	if _, found := exportRecord(t, store, barcodeMilk); found {
+		t.Error("deleted barcode has been exported")
+	}
+}
+
+func testImportMerge(t *testing.T, store storage.Store) {
+	upload(store, barcodeMilk, "Milk")
+	store.VoteName(barcodeMilk, "Milk", "10.0.0.1")
+	store.ImportBarcode(storage.ExportRecord{
+		Barcode: barcodeMilk,
+		Hits:    5,
+		Names: []storage.ExportName{
+			{Name: "Milk", Score: 1},
+			{Name: "Imported milk", Score: 4, Source: "peer", Language: "en"},
+		},
+	}, storage.ImportMerge)
+	expectNames(t, store, barcodeMilk, "Imported milk", "Milk")
+	record, _ := exportRecord(t, store, barcodeMilk)
+	if record.Hits != 5 || record.Names[1].Score != 2 || record.Names[0].Source != "peer" || record.Names[0].Language != "en" {
+		t.Errorf("unexpected record %+v", record)
+	}
+	expectInt(t, "total barcodes", 1, store.GetTotalBarcodes())
+}
+
+func testImportReplace(t *testing.T, store storage.Store) {
+	upload(store, barcodeMilk, "Milk")
+	store.ImportBarcode(storage.ExportRecord{
+		Barcode: barcodeMilk,
+		Names:   []storage.ExportName{{Name: "Replaced milk", Score: 2, Reports: 1}},
+	}, storage.ImportReplace)
+	expectNames(t, store, barcodeMilk, "Replaced milk")
+	expectInt(t, "reports", 1, len(store.GetReportList()))
+	expectInt(t, "total barcodes", 1, store.GetTotalBarcodes())
+}
+
+func testImportEmptyRecord(t *testing.T, store storage.Store) {
+	store.ImportBarcode(storage.ExportRecord{Barcode: barcodeMilk}, storage.ImportMerge)
+	expectInt(t, "total barcodes", 0, store.GetTotalBarcodes())
+	if _, found := exportRecord(t, store, barcodeMilk); found {
+		t.Error("empty record has been stored")
+	}
+}
+
+func testImportReplaceEmptyRecord(t *testing.T, store storage.Store) {
+	upload(store, barcodeMilk, "Milk")
+	upload(store, barcodeButter, "Butter")
+	store.ImportBarcode(storage.ExportRecord{Barcode: barcodeMilk}, storage.ImportReplace)
+	expectNames(t, store, barcodeMilk)
+	expectNames(t, store, barcodeButter, "Butter")
+	expectInt(t, "total barcodes", 1, store.GetTotalBarcodes())
+	if _, found := exportRecord(t, store, barcodeMilk); found {
+		t.Error("replaced barcode has been exported")
+	}
+}
+
+func testImportMetadataOnly(t *testing.T, store storage.Store) {
+	store.ImportBarcode(storage.ExportRecord{
+		Barcode:  barcodeMilk,
+		Metadata: []storage.ExportMetadata{{Field: storage.FieldBrand, Value: "Example", Score: 1}},
+	}, storage.ImportMerge)
+	if brand := store.GetMetadata([]string{barcodeMilk})[0].Brand; brand != "Example" {
+		t.Errorf("expected the imported brand, got %q", brand)
+	}
+	record, found := exportRecord(t, store, barcodeMilk)
+	if !found || len(record.Names) != 0 || len(record.Metadata) != 1 {
+		t.Errorf("unexpected exported record %+v", record)
+	}
+}
+
+func testExportBarcodes(t *testing.T, store storage.Store) {
+	upload(store, barcodeMilk, "Milk")
+	upload(store, barcodeButter, "Butter")
+	store.VoteName(barcodeMilk, "Milk", "10.0.0.1")
+	var exported []string
+	err := store.ExportBarcodes(storage.ExportFilter{MinScore: 2}, func(record storage.ExportRecord) error {
+		exported = append(exported, record.Barcode)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(exported, []string{barcodeMilk}) {
+		t.Errorf("expected only %s to be exported, got %v", barcodeMilk, exported)
+	}
+}
+
+func testChanges(t *testing.T, store storage.Store) {
+	upload(store, barcodeMilk, "Milk")
+	store.VoteName(barcodeMilk, "Milk", "10.0.0.1")
+	store.ReportName(barcodeMilk, "Milk", "10.0.0.2")
+
+	changes := store.GetChanges(0, 100)
+	if changes.LatestCursor != 3 || len(changes.Changes) != 3 {
+		t.Fatalf("unexpected change log %+v", changes)
+	}
+	types := []string{changes.Changes[0].Type, changes.Changes[1].Type, changes.Changes[2].Type}
+	if !reflect.DeepEqual(types, []string{storage.ChangeAdd, storage.ChangeVote, storage.ChangeReport}) {
+		t.Errorf("unexpected change types %v", types)
+	}
+	if changes.Changes[2].Score != 0 || changes.Changes[2].Cursor != 3 {
+		t.Errorf("unexpected change %+v", changes.Changes[2])
+	}
+	page := store.GetChanges(1, 1)
+	if len(page.Changes) != 1 || page.Changes[0].Cursor != 2 {
+		t.Errorf("unexpected page %+v", page)
+	}
+}
+
+func testSyncState(t *testing.T, store storage.Store) {
+	if value := store.GetSyncState("peer:example"); value != 0 {
+		t.Errorf("expected 0 for an unknown key, got %d", value)
+	}
+	store.SetSyncState("peer:example", 1234)
+	if value := store.GetSyncState("peer:example"); value != 1234 {
+		t.Errorf("expected 1234, got %d", value)
+	}
+}
+
+func testMetricsSnapshots(t *testing.T, store storage.Store) {
+	for _, time := range []int64{300, 100, 200} {
+		store.AddMetricsSnapshot(storage.MetricsSnapshot{Time: time, TotalBarcodes: int(time), Requests: map[string]int64{"/get": 1}})
+	}
+	snapshots := store.GetMetricsSnapshots(100)
+	if len(snapshots) != 2 || snapshots[0].Time != 200 || snapshots[1].Time != 300 || snapshots[0].Requests["/get"] != 1 {
+		t.Errorf("unexpected snapshots %+v", snapshots)
+	}
+	store.RemoveMetricsSnapshots(300)
+	snapshots = store.GetMetricsSnapshots(0)
+	if len(snapshots) != 1 || snapshots[0].Time != 300 {
+		t.Errorf("unexpected snapshots after removing %+v", snapshots)
+	}
+}
+
+func testPing(t *testing.T, store storage.Store) {
+	if err := store.Ping(); err != nil {
+		t.Error(err)
+	}
+}
@@ -2,7 +2,9 @@ package webserver
 
 import (
 	"BarcodeServer/internal/configuration"
-	"BarcodeServer/internal/redis"
+	"BarcodeServer/internal/metrics"
+	"BarcodeServer/internal/mirror"
+	"BarcodeServer/internal/storage"
 	"embed"
 	"encoding/json"
 	"fmt"
@@ -19,19 +21,27 @@ var templateFolderEmbedded embed.FS
 // Variable containing all parsed templates
 var templateFolder *template.Template
 
-func Start() {
+// store is the storage backend used by all handlers
+var store storage.Store
+
+// reconcileInterval is the interval in which the statistic counters of the store are recounted
+const reconcileInterval = 24 * time.Hour
+
+// Start runs the webserver with the given storage backend
+func Start(storageBackend storage.Store) {
+	store = storageBackend
 	initTemplates()
-	go updateBarcodeCount()
-	http.HandleFunc("/", handleHome)
-	http.HandleFunc("/ping", handlePing)
-	http.HandleFunc("/amount", handleAmount)
-	http.HandleFunc("/get", handleGetBarcode)
-	http.HandleFunc("/vote", handleVote)
-	http.HandleFunc("/report", handleReport)
-	http.HandleFunc("/add", handleAdd)
-	http.HandleFunc("/login", handleLogin)
-	http.HandleFunc("/logout", handleLogout)
-	http.HandleFunc("/admin", handleAdmin)
+	go reconcileStatistics()
+	routeTable := routes()
+	if configuration.Get().MetricsAddress == "" {
+		routeTable = append(routeTable, metricsRoute())
+	} else {
+		go startMetricsServer(configuration.Get().MetricsAddress)
+	}
+	for _, r := range routeTable {
+		http.HandleFunc(r.path, countRequests(r.path, r.handler))
+	}
+	openApiDocument = newOpenApiDocument(routeTable)
 	fmt.Println("Starting webserver on " + configuration.Get().WebserverPort)
 	srv := &http.Server{
 		Addr:         configuration.Get().WebserverPort,
@@ -41,6 +51,21 @@ func Start() {
 	log.Fatal(srv.ListenAndServe())
 }
 
+// startMetricsServer serves the metrics on their own address, so that they can be
+// scraped from an internal network without being reachable from the internet
+func startMetricsServer(address string) {
+	fmt.Println("Serving metrics on " + address)
+	mux := http.NewServeMux()
+	mux.HandleFunc(MetricsPath, handleMetrics)
+	srv := &http.Server{
+		Addr:         address,
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	log.Fatal(srv.ListenAndServe())
+}
+
 // Initialises the templateFolder variable by scanning through all the templates.
 func initTemplates() {
 	var err error
@@ -50,11 +75,51 @@ func initTemplates() {
 	}
 }
 
-func updateBarcodeCount() {
+func reconcileStatistics() {
 	for {
-		redis.GetTotalBarcodes()
-		time.Sleep(6 * time.Hour)
+		store.ReconcileStatistics()
+		time.Sleep(reconcileInterval)
+	}
+}
+
+// countRequests records every request of the handler as a request of the endpoint
+// path, including its status code and the time until it has been answered
+func countRequests(path string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler(recorder, r)
+		metrics.ObserveRequest(path, recorder.status, time.Since(start))
+	}
+}
+
+// statusRecorder keeps the status code that has been sent to the client
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap allows http.ResponseController to access the original ResponseWriter
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// mirrorWrites returns the handler unchanged, unless the server is a mirror. Mirrors
+// forward the request to the upstream server if MirrorForwardWrites is set, otherwise
+// it is rejected with readOnlyHandler
+func mirrorWrites(handler, readOnlyHandler http.HandlerFunc) http.HandlerFunc {
+	if !mirror.IsEnabled() {
+		return handler
+	}
+	if configuration.Get().MirrorForwardWrites {
+		return mirror.NewForwardingProxy().ServeHTTP
 	}
+	return readOnlyHandler
 }
 
 type ResponseError struct {
@@ -64,9 +129,16 @@ type ResponseError struct {
 
 const GENERIC_RESPONSE_OK = "{\"Result\":\"ok\"}"
 
+// ResponseGeneric is the schema of GENERIC_RESPONSE_OK
+type ResponseGeneric struct {
+	Result string `json:"Result"`
+}
+
 type ResponseBarcodeFound struct {
 	Result     string   `json:"Result"`
 	FoundNames []string `json:"FoundNames"`
+	// Metadata is omitted if nothing but the names is known about the product
+	Metadata *storage.Metadata `json:"Metadata,omitempty"`
 }
 
 func isValidUuid(uuid string) bool {
@@ -74,6 +146,7 @@ func isValidUuid(uuid string) bool {
 }
 
 func sendTooManyRequests(w http.ResponseWriter) {
+	metrics.Add(metrics.CounterRateLimited, 1)
 	result := ResponseError{
 		Result:       "error",
 		ErrorMessage: "Too many requests",
@@ -99,7 +172,26 @@ func sendBarcodeNotFound(w http.ResponseWriter) {
 	fmt.Fprintf(w, string(response))
 }
 
+func sendReadOnly(w http.ResponseWriter, r *http.Request) {
+	result := ResponseError{
+		Result:       "error",
+		ErrorMessage: "Read-only mirror",
+	}
+	response, _ := json.Marshal(result)
+	http.Error(w, string(response), http.StatusForbidden)
+}
+
+func sendCursorExpired(w http.ResponseWriter) {
+	result := ResponseError{
+		Result:       "error",
+		ErrorMessage: "Cursor expired, a full export is required",
+	}
+	response, _ := json.Marshal(result)
+	http.Error(w, string(response), http.StatusGone)
+}
+
 func sendBadRequest(w http.ResponseWriter) {
+	metrics.Add(metrics.CounterBadRequests, 1)
 	result := ResponseError{
 		Result:       "error",
 		ErrorMessage: "Bad request",
@@ -1,17 +1,26 @@
 package webserver
 
 import (
+	gtin "BarcodeServer/internal/barcode"
 	"BarcodeServer/internal/configuration"
+	"BarcodeServer/internal/export"
+	"BarcodeServer/internal/federation"
 	"BarcodeServer/internal/helper"
-	"BarcodeServer/internal/redis"
+	"BarcodeServer/internal/import/backup"
+	"BarcodeServer/internal/language"
+	"BarcodeServer/internal/metrics"
+	"BarcodeServer/internal/mirror"
+	"BarcodeServer/internal/storage"
 	sessionmanager "BarcodeServer/internal/webserver/sessions"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -28,56 +37,212 @@ func handlePing(w http.ResponseWriter, r *http.Request) {
 func handleAmount(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("cache-control", "public, max-age=1800")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	fmt.Fprintf(w, strconv.Itoa(redis.AmountStoredBarcodes))
+	fmt.Fprintf(w, strconv.Itoa(store.GetTotalBarcodes()))
 }
 
 func handleGetBarcode(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("cache-control", "private")
-	barcode := r.Header.Get("barcode")
 	uuid := r.Header.Get("uuid")
 	if !isValidUuid(uuid) {
 		sendBadRequest(w)
 		return
 	}
-	requests := redis.LogNewRequest(r, uuid, false)
+	requests := store.LogNewRequest(helper.GetIpAddress(r), uuid, false)
 	if requests > configuration.Get().ApiDailyCalls {
 		sendTooManyRequests(w)
 		return
 	}
-	if len(barcode) > 4 {
-		storedNames := redis.GetBarcode(barcode, true)
-		if len(storedNames) > 0 {
-			response := ResponseBarcodeFound{
-				Result:     "OK",
-				FoundNames: storedNames,
-			}
-			responseString, _ := json.Marshal(response)
-			sendResultOK(w, responseString)
-		} else {
-			sendBarcodeNotFound(w)
+	result := lookupBarcodes([]string{r.Header.Get("barcode")}, uuid, preferredLanguages(r))[0]
+	switch result.err {
+	case nil:
+		response := ResponseBarcodeFound{
+			Result:     "OK",
+			FoundNames: result.names,
+			Metadata:   result.metadata,
 		}
-	} else {
+		responseString, _ := json.Marshal(response)
+		sendResultOK(w, responseString)
+	case errInvalidBarcode:
+		sendBadRequest(w)
+	default:
+		sendBarcodeNotFound(w)
+	}
+}
+
+// maxBatchSize is the maximum amount of barcodes that can be requested at once
+const maxBatchSize = 100
+
+// maxBatchBodySize is the maximum size of the body of a batch request
+const maxBatchBodySize = 64 * 1024
+
+// handleGetBatch returns the names of multiple barcodes. Every barcode counts
+// as a single request towards ApiDailyCalls
+func handleGetBatch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("cache-control", "private")
+	uuid := r.Header.Get("uuid")
+	if r.Method != http.MethodPost || !isValidUuid(uuid) {
+		sendBadRequest(w)
+		return
+	}
+	var request RequestBatch
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBodySize)).Decode(&request)
+	if err != nil || len(request.Barcodes) == 0 || len(request.Barcodes) > maxBatchSize {
 		sendBadRequest(w)
 		return
 	}
+	requests := store.LogNewRequests(helper.GetIpAddress(r), uuid, len(request.Barcodes))
+	if requests > configuration.Get().ApiDailyCalls {
+		sendTooManyRequests(w)
+		return
+	}
+	results := make([]BatchResult, len(request.Barcodes))
+	for i, result := range lookupBarcodes(request.Barcodes, uuid, preferredLanguages(r)) {
+		results[i] = BatchResult{Barcode: request.Barcodes[i], FoundNames: result.names, Metadata: result.metadata}
+		switch result.err {
+		case errInvalidBarcode:
+			results[i].Error = "Invalid barcode"
+		case errBarcodeNotFound, errRestrictedBarcode:
+			results[i].Error = "Barcode not found"
+		}
+	}
+	responseString, _ := json.Marshal(ResponseBatch{Result: "OK", Results: results})
+	sendResultOK(w, responseString)
+}
+
+var (
+	errInvalidBarcode    = errors.New("invalid barcode")
+	errRestrictedBarcode = errors.New("restricted circulation codes are not stored")
+	errBarcodeNotFound   = errors.New("barcode not found")
+)
+
+// parseBarcode returns the canonical form of a barcode and the key under which it is
+// stored. Variable measure codes are stored separately for every user, other
+// restricted codes are not stored
+func parseBarcode(code, uuid string) (string, string, error) {
+	barcode, err := gtin.Parse(code)
+	switch {
+	case err != nil:
+		return "", "", errInvalidBarcode
+	case barcode.Circulation == gtin.VariableMeasure:
+		return barcode.Canonical, storage.ScopedBarcode(barcode.Canonical, uuid), nil
+	case !barcode.IsShared():
+		return barcode.Canonical, "", errRestrictedBarcode
+	}
+	return barcode.Canonical, barcode.Canonical, nil
+}
+
+// scopeUploads replaces the variable measure codes of an upload with their scoped
+// barcode of uuid. Scoped barcodes that have been sent by the client are rejected
+func scopeUploads(barcodes []storage.Barcode, uuid string) []storage.Barcode {
+	result := make([]storage.Barcode, len(barcodes))
+	for i, barcode := range barcodes {
+		result[i] = barcode
+		if storage.IsScopedBarcode(barcode.Barcode) {
+			result[i].Barcode = ""
+			continue
+		}
+		parsed, err := gtin.Parse(barcode.Barcode)
+		if err == nil && parsed.Circulation == gtin.VariableMeasure {
+			result[i].Barcode = storage.ScopedBarcode(parsed.Canonical, uuid)
+		}
+	}
+	return result
+}
+
+// lookupResult contains the names of a requested barcode, or the reason why none were found
+type lookupResult struct {
+	barcode  string
+	names    []string
+	metadata *storage.Metadata
+	err      error
+}
+
+// lookupBarcodes returns the names of all requested barcodes with a single
+// storage request, in the order of the requested barcodes. Names in the
+// preferred languages are returned first. Shared barcodes that are not stored
+// are registered as lookup misses of uuid. Variable measure codes are looked up
+// for uuid only
+func lookupBarcodes(requested []string, uuid string, preferred []string) []lookupResult {
+	results := make([]lookupResult, len(requested))
+	var lookups []string
+	var lookupIndex []int
+	for i, code := range requested {
+		results[i].names = []string{}
+		canonical, key, err := parseBarcode(code, uuid)
+		results[i].barcode = canonical
+		if err != nil {
+			results[i].err = err
+			continue
+		}
+		lookups = append(lookups, key)
+		lookupIndex = append(lookupIndex, i)
+	}
+	for i, stored := range store.LookupBarcodes(lookups, uuid) {
+		result := &results[lookupIndex[i]]
+		if len(stored.Names) == 0 {
+			result.err = errBarcodeNotFound
+			continue
+		}
+		result.names = stored.Names
+		result.metadata = metadataOrNil(stored.Metadata)
+		if len(preferred) > 0 {
+			result.names = language.SortNames(result.names, stored.Languages, preferred)
+		}
+	}
+	return results
+}
+
+// preferredLanguages returns the languages requested with the lang parameter, e.g.
+// "de,en", or with the Accept-Language header, ordered by preference
+func preferredLanguages(r *http.Request) []string {
+	requested := r.URL.Query().Get("lang")
+	if requested == "" {
+		requested = r.Header.Get("Accept-Language")
+	}
+	return language.ParseAcceptLanguage(requested)
+}
+
+// metadataOrNil returns nil if no metadata is known, so that it is omitted in responses
+func metadataOrNil(metadata storage.Metadata) *storage.Metadata {
+	if metadata.IsEmpty() {
+		return nil
+	}
+	return &metadata
+}
+
+type RequestBatch struct {
+	Barcodes []string `json:"Barcodes"`
+}
+
+type ResponseBatch struct {
+	Result  string        `json:"Result"`
+	Results []BatchResult `json:"Results"`
+}
+
+// BatchResult contains the names of a barcode of a batch request, or the reason why none were found
+type BatchResult struct {
+	Barcode    string            `json:"Barcode"`
+	FoundNames []string          `json:"FoundNames"`
+	Metadata   *storage.Metadata `json:"Metadata,omitempty"`
+	Error      string            `json:"Error,omitempty"`
 }
 
 func handleVote(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("cache-control", "private")
-	barcode := r.Header.Get("barcode")
 	uuid := r.Header.Get("uuid")
+	_, barcode, err := parseBarcode(r.Header.Get("barcode"), uuid)
 	name := r.Header.Get("name")
 	if !isValidUuid(uuid) {
 		sendBadRequest(w)
 		return
 	}
-	requests := redis.LogNewRequest(r, uuid, false)
+	requests := store.LogNewRequest(helper.GetIpAddress(r), uuid, false)
 	if requests > configuration.Get().ApiDailyCalls {
 		sendTooManyRequests(w)
 		return
 	}
-	if len(barcode) > 4 && len(name) > 1 {
-		redis.VoteName(barcode, name, r)
+	if err == nil && len(name) > 1 {
+		store.VoteName(barcode, name, helper.GetIpAddress(r))
 		sendGenericResultOK(w)
 	} else {
 		sendBadRequest(w)
@@ -88,7 +253,7 @@ func handleVote(w http.ResponseWriter, r *http.Request) {
 func handleAdd(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("cache-control", "private")
 	uuid := r.Header.Get("uuid")
-	requests := redis.LogNewRequest(r, uuid, true)
+	requests := store.LogNewRequest(helper.GetIpAddress(r), uuid, true)
 	if !isValidUuid(uuid) {
 		sendBadRequest(w)
 		return
@@ -102,7 +267,7 @@ func handleAdd(w http.ResponseWriter, r *http.Request) {
 		sendBadRequest(w)
 		return
 	}
-	var barcodes redis.GrocyBarcodes
+	var barcodes storage.GrocyBarcodes
 	err = json.Unmarshal(body, &barcodes)
 	if err != nil {
 		sendBadRequest(w)
@@ -112,26 +277,28 @@ func handleAdd(w http.ResponseWriter, r *http.Request) {
 		sendBadRequest(w)
 		return
 	}
-	redis.AddGrocyBarcodes(barcodes, uuid)
+	barcodes.Barcodes = scopeUploads(barcodes.Barcodes, uuid)
+	store.AddGrocyBarcodes(barcodes, uuid, storage.SourceUser)
+	metrics.Add(metrics.CounterUploads, len(barcodes.Barcodes))
 	sendGenericResultOK(w)
 }
 
 func handleReport(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("cache-control", "private")
-	barcode := r.Header.Get("barcode")
 	uuid := r.Header.Get("uuid")
+	_, barcode, err := parseBarcode(r.Header.Get("barcode"), uuid)
 	name := r.Header.Get("name")
 	if !isValidUuid(uuid) {
 		sendBadRequest(w)
 		return
 	}
-	requests := redis.LogNewRequest(r, uuid, false)
+	requests := store.LogNewRequest(helper.GetIpAddress(r), uuid, false)
 	if requests > configuration.Get().ApiDailyCalls {
 		sendTooManyRequests(w)
 		return
 	}
-	if len(barcode) > 4 && len(name) > 1 {
-		redis.ReportName(barcode, name, r)
+	if err == nil && len(name) > 1 {
+		store.ReportName(barcode, name, helper.GetIpAddress(r))
 		sendGenericResultOK(w)
 	} else {
 		sendBadRequest(w)
@@ -185,20 +352,28 @@ func handleAdmin(w http.ResponseWriter, r *http.Request) {
 	}
 	reportIdDelete, _ := r.URL.Query()["delete"]
 	reportIdDismiss, _ := r.URL.Query()["dismiss"]
+	mergeIdAccept, _ := r.URL.Query()["merge"]
+	mergeIdDismiss, _ := r.URL.Query()["keepnames"]
+	findSimilarButton, _ := r.URL.Query()["findsimilar"]
 	exportButton, _ := r.URL.Query()["export"]
+	exportMissesButton, _ := r.URL.Query()["exportmisses"]
 
 	if exportButton != nil {
-		serveCsv(w, r, redis.GetDownloadBarcodesAsCsv())
+		serveExport(w, r)
+		return
+	}
+	if exportMissesButton != nil {
+		serveMissesExport(w, r)
 		return
 	}
 
 	if reportIdDelete != nil {
 		id, err := strconv.Atoi(reportIdDelete[0])
 		if err == nil {
-			reports := redis.GetReportList()
+			reports := store.GetReportList()
 			for _, report := range reports {
 				if report.Id == id {
-					redis.ProcessReport(report, false)
+					store.ProcessReport(report, false)
 					redirect(w, r, "admin")
 					return
 				}
@@ -210,10 +385,10 @@ func handleAdmin(w http.ResponseWriter, r *http.Request) {
 	if reportIdDismiss != nil {
 		id, err := strconv.Atoi(reportIdDismiss[0])
 		if err == nil {
-			reports := redis.GetReportList()
+			reports := store.GetReportList()
 			for _, report := range reports {
 				if report.Id == id {
-					redis.ProcessReport(report, true)
+					store.ProcessReport(report, true)
 					redirect(w, r, "admin")
 					return
 				}
@@ -222,16 +397,56 @@ func handleAdmin(w http.ResponseWriter, r *http.Request) {
 			fmt.Println("Invalid ID for report provided.")
 		}
 	}
+	if mergeIdAccept != nil && processMergeProposal(mergeIdAccept[0], true) {
+		redirect(w, r, "admin")
+		return
+	}
+	if mergeIdDismiss != nil && processMergeProposal(mergeIdDismiss[0], false) {
+		redirect(w, r, "admin")
+		return
+	}
+	if findSimilarButton != nil {
+		startFindingSimilarNames()
+		redirect(w, r, "admin")
+		return
+	}
 
+	mergeProposals := store.GetMergeProposals()
+	missDays := parseMissDays(r)
+	popularDays := parsePopularDays(r)
 	view := adminView{
-		TotalBarcodes: redis.GetTotalBarcodes(),
-		Users:         redis.GetTotalUsers(),
-		UsersActive:   redis.GetTotalActiveUsers(),
-		RamUsage:      redis.GetRamUsage(),
-		TotalVotes:    redis.GetTotalVotes(),
-		TotalReports:  redis.GetTotalReports(),
-		Reports:       redis.GetReportList(),
-		TopBarcodes:   redis.GetMostPopularBarcodes(),
+		TotalBarcodes: store.GetTotalBarcodes(),
+		Users:         store.GetTotalUsers(),
+		UsersActive:   store.GetTotalActiveUsers(),
+		RamUsage:      formatRamUsage(store.GetRamUsage()),
+		TotalVotes:    store.GetTotalVotes(),
+		TotalReports:  store.GetTotalReports(),
+		Reports:       store.GetReportList(),
+		Peers:         federation.GetPeerStatus(),
+
+		TotalMergeProposals:   len(mergeProposals),
+		IsFindingSimilarNames: isFindingSimilarNames(),
+
+		Misses:      storage.MostRequestedMisses(store, missDays, storage.AmountTopBarcodes),
+		MissDays:    missDays,
+		MissWindows: storage.MissWindows,
+
+		PopularDays:       popularDays,
+		PopularityWindows: storage.PopularityWindows,
+	}
+	if popularDays == 0 {
+		view.TopBarcodes = store.GetMostPopularBarcodes()
+	} else {
+		view.PopularBarcodes = storage.MostPopularBarcodes(store, popularDays, storage.AmountTopBarcodes)
+		view.TrendingBarcodes = storage.TrendingBarcodes(store, popularDays, storage.AmountTopBarcodes)
+	}
+	if len(mergeProposals) > maxListedMergeProposals {
+		mergeProposals = mergeProposals[:maxListedMergeProposals]
+	}
+	view.MergeProposals = mergeProposals
+	if mirror.IsEnabled() {
+		mirrorStatus := mirror.GetStatus()
+		view.Mirror = &mirrorStatus
 	}
 
 	totalRam, freeRam, err := helper.GetRamInfo()
@@ -245,6 +460,14 @@ func handleAdmin(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// formatRamUsage returns the RAM usage of the store in a human-readable format
+func formatRamUsage(bytes uint64) string {
+	if bytes == 0 {
+		return "Unknown"
+	}
+	return helper.ByteCountSI(bytes)
+}
+
 type adminView struct {
 	TotalBarcodes int
 	Users         int
@@ -253,13 +476,353 @@ type adminView struct {
 	TotalReports  int
 	RamUsage      string
 	FreeRam       string
-	Reports       []redis.Report
-	TopBarcodes   []redis.TopBarcode
+	Reports       []storage.Report
+	TopBarcodes   []storage.TopBarcode
+	Peers         []federation.PeerStatus
+	Mirror        *mirror.Status
+
+	MergeProposals        []storage.MergeProposal
+	TotalMergeProposals   int
+	IsFindingSimilarNames bool
+
+	Misses      []storage.MissedBarcode
+	MissDays    int
+	MissWindows []int
+
+	// PopularDays is the time window of PopularBarcodes and TrendingBarcodes, TopBarcodes are shown if it is 0
+	PopularDays       int
+	PopularBarcodes   []storage.PopularBarcode
+	TrendingBarcodes  []storage.PopularBarcode
+	PopularityWindows []int
+}
+
+// parsePopularDays returns the time window in days of the popular barcodes that
+// is requested with the parameter popular. 0 requests the all-time popular barcodes
+func parsePopularDays(r *http.Request) int {
+	days, err := strconv.Atoi(r.URL.Query().Get("popular"))
+	if err != nil || (days != 0 && !storage.IsPopularityWindow(days)) {
+		return defaultPopularDays
+	}
+	return days
+}
+
+// defaultMissDays is the time window of the most requested unknown barcodes on the admin page
+const defaultMissDays = 7
+
+// parseMissDays returns the time window in days that is requested with the
+// parameter days, or defaultMissDays if it is not one of storage.MissWindows
+func parseMissDays(r *http.Request) int {
+	days, err := strconv.Atoi(r.URL.Query().Get("days"))
+	if err != nil {
+		return defaultMissDays
+	}
+	for _, window := range storage.MissWindows {
+		if window == days {
+			return days
+		}
+	}
+	return defaultMissDays
 }
 
-func serveCsv(w http.ResponseWriter, r *http.Request, data [][]string) {
-	w.Header().Set("Content-Disposition", "attachment; filename=exportBarcodes.csv")
-	w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+// serveMissesExport sends all unknown barcodes that have been requested within
+// the time window of the parameter days as CSV, ordered by the amount of requests
+func serveMissesExport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Disposition", "attachment; filename=missing_barcodes.csv")
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
 	writer := csv.NewWriter(w)
-	_ = writer.WriteAll(data)
+	_ = writer.Write([]string{"barcode", "requests"})
+	for _, miss := range storage.MostRequestedMisses(store, parseMissDays(r), 0) {
+		_ = writer.Write([]string{miss.Barcode, strconv.Itoa(miss.Requests)})
+	}
+	writer.Flush()
+	if writer.Error() != nil {
+		log.Println("Unable to export missing barcodes: " + writer.Error().Error())
+	}
+}
+
+// maxListedMergeProposals is the amount of merge proposals shown on the admin page
+const maxListedMergeProposals = 100
+
+// processMergeProposal merges or dismisses the proposal with the given id.
+// Returns false if the id is invalid
+func processMergeProposal(idParameter string, accept bool) bool {
+	id, err := strconv.Atoi(idParameter)
+	if err != nil {
+		fmt.Println("Invalid ID for merge proposal provided.")
+		return false
+	}
+	for _, proposal := range store.GetMergeProposals() {
+		if proposal.Id == id {
+			store.ProcessMergeProposal(proposal, accept)
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	findingSimilarNamesMutex sync.Mutex
+	// findingSimilarNames is true while all barcodes are searched for similar names
+	findingSimilarNames bool
+)
+
+func isFindingSimilarNames() bool {
+	findingSimilarNamesMutex.Lock()
+	defer findingSimilarNamesMutex.Unlock()
+	return findingSimilarNames
+}
+
+// startFindingSimilarNames searches all barcodes for similar names in the background,
+// as comparing the names of all barcodes takes too long for a request
+func startFindingSimilarNames() {
+	findingSimilarNamesMutex.Lock()
+	defer findingSimilarNamesMutex.Unlock()
+	if findingSimilarNames {
+		return
+	}
+	findingSimilarNames = true
+	go func() {
+		amount, err := storage.ScanMergeProposals(store)
+		if err != nil {
+			log.Println("Unable to find similar names: " + err.Error())
+		} else {
+			log.Println("Found " + strconv.Itoa(amount) + " pairs of similar names")
+		}
+		findingSimilarNamesMutex.Lock()
+		findingSimilarNames = false
+		findingSimilarNamesMutex.Unlock()
+	}()
+}
+
+// handleFederationExport serves the export to other federation servers that
+// send the configured FederationKey
+func handleFederationExport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("cache-control", "private")
+	if !federation.IsValidKey(r.Header.Get(federation.HeaderKey)) {
+		time.Sleep(1 * time.Second)
+		http.Error(w, "Invalid federation key", http.StatusUnauthorized)
+		return
+	}
+	serveExport(w, r)
+}
+
+const (
+	// defaultChangesLimit is the amount of changes that are returned if no limit is requested
+	defaultChangesLimit = 1000
+	// maxChangesLimit is the maximum amount of changes that are returned per request
+	maxChangesLimit = 10000
+)
+
+// handleChanges returns all changes after the cursor "since". Clients that
+// send a uuid are rate limited, peers sending the FederationKey are not
+func handleChanges(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("cache-control", "private")
+	if !federation.IsValidKey(r.Header.Get(federation.HeaderKey)) {
+		uuid := r.Header.Get("uuid")
+		if !isValidUuid(uuid) {
+			sendBadRequest(w)
+			return
+		}
+		requests := store.LogNewRequest(helper.GetIpAddress(r), uuid, false)
+		if requests > configuration.Get().ApiDailyCalls {
+			sendTooManyRequests(w)
+			return
+		}
+	}
+	since, limit, err := parseChangesParameters(r)
+	if err != nil {
+		sendBadRequest(w)
+		return
+	}
+	changeLog := store.GetChanges(since, limit)
+	// Changes between since and the oldest stored change have been removed
+	if changeLog.OldestCursor > since+1 && since < changeLog.LatestCursor {
+		sendCursorExpired(w)
+		return
+	}
+	response := ResponseChanges{
+		Result:       "OK",
+		Changes:      []storage.Change{},
+		NextCursor:   since,
+		LatestCursor: changeLog.LatestCursor,
+	}
+	if len(changeLog.Changes) > 0 {
+		response.NextCursor = changeLog.Changes[len(changeLog.Changes)-1].Cursor
+	}
+	// Scoped barcodes are only valid for the user that uploaded them
+	for _, change := range changeLog.Changes {
+		if !storage.IsScopedBarcode(change.Barcode) {
+			response.Changes = append(response.Changes, change)
+		}
+	}
+	response.HasMore = response.NextCursor < changeLog.LatestCursor
+	responseString, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	sendResultOK(w, responseString)
+}
+
+func parseChangesParameters(r *http.Request) (int64, int, error) {
+	var since int64
+	var err error
+	limit := defaultChangesLimit
+	if r.URL.Query().Get("since") != "" {
+		since, err = strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+		if err != nil || since < 0 {
+			return 0, 0, errors.New("invalid cursor")
+		}
+	}
+	if r.URL.Query().Get("limit") != "" {
+		limit, err = strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit < 1 {
+			return 0, 0, errors.New("invalid limit")
+		}
+		if limit > maxChangesLimit {
+			limit = maxChangesLimit
+		}
+	}
+	return since, limit, nil
+}
+
+type ResponseChanges struct {
+	Result       string           `json:"Result"`
+	Changes      []storage.Change `json:"Changes"`
+	NextCursor   int64            `json:"NextCursor"`
+	HasMore      bool             `json:"HasMore"`
+	LatestCursor int64            `json:"LatestCursor"`
+}
+
+// handleMirrorStatus returns the replication state, if the server is a mirror
+func handleMirrorStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("cache-control", "private")
+	if !mirror.IsEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+	responseString, _ := json.Marshal(mirror.GetStatus())
+	w.Header().Set("Content-Type", "application/json")
+	sendResultOK(w, responseString)
+}
+
+// importTimeout is the maximum time an upload and import of a backup may take
+const importTimeout = time.Hour
+
+// maxImportMemory is the maximum size of an uploaded file that is kept in memory, larger files are buffered on disk
+const maxImportMemory = 32 << 20
+
+func handleAdminImport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("cache-control", "private")
+	if !sessionmanager.IsValidSession(w, r) {
+		time.Sleep(1 * time.Second)
+		redirect(w, r, "../login")
+		return
+	}
+	if r.Method != http.MethodPost {
+		redirect(w, r, "../admin")
+		return
+	}
+	// The default timeouts of the server are too short for large uploads
+	controller := http.NewResponseController(w)
+	_ = controller.SetReadDeadline(time.Now().Add(importTimeout))
+	_ = controller.SetWriteDeadline(time.Now().Add(importTimeout))
+
+	view := importView{}
+	err := r.ParseMultipartForm(maxImportMemory)
+	if err == nil && mirror.IsEnabled() {
+		// Imported barcodes would be overwritten by the upstream server
+		err = errors.New("this server is a read-only mirror, import the file on the upstream server instead")
+	}
+	if err == nil {
+		defer r.MultipartForm.RemoveAll()
+		view.Summary, err = importUploadedFile(r)
+	}
+	if err != nil {
+		view.Error = err.Error()
+	}
+	err = templateFolder.ExecuteTemplate(w, "import", view)
+	if err != nil {
+		log.Panicln(err)
+	}
+}
+
+func importUploadedFile(r *http.Request) (backup.Summary, error) {
+	mode, err := backup.ParseMode(r.FormValue("mode"))
+	if err != nil {
+		return backup.Summary{}, err
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		return backup.Summary{}, err
+	}
+	defer file.Close()
+	summary, err := backup.Import(store, file, r.FormValue("format"), mode)
+	metrics.Add(metrics.CounterImported, summary.Accepted)
+	metrics.Add(metrics.CounterImportRejected, summary.Rejected)
+	if err != nil {
+		metrics.Add(metrics.CounterImportFailed, 1)
+	}
+	return summary, err
+}
+
+type importView struct {
+	Summary backup.Summary
+	Error   string
+}
+
+// exportTimeout is the maximum time a download of all barcodes may take
+const exportTimeout = time.Hour
+
+// serveExport streams all barcodes in the requested format, without loading
+// the whole dataset into memory. Supported parameters:
+// format: one of the export formats, default csv
+// minscore: only names with at least this score are exported
+// since: only barcodes changed after this date (YYYY-MM-DD) or unix time are exported
+func serveExport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = export.FormatCsv
+	}
+	filter, err := parseExportFilter(r)
+	if err != nil || !export.IsValidFormat(format) {
+		http.Error(w, "Invalid export parameters", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Disposition", "attachment; filename="+export.FileName(format))
+	w.Header().Set("Content-Type", export.ContentType(format))
+	// Allows a mirror to request all changes that happen during the export afterwards
+	w.Header().Set(federation.HeaderCursor, strconv.FormatInt(store.GetChanges(0, 0).LatestCursor, 10))
+	// The default write timeout of the server is too short for large exports
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(exportTimeout))
+	writer, _ := export.NewWriter(format, w)
+	err = store.ExportBarcodes(filter, writer.Write)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		log.Println("Unable to export barcodes: " + err.Error())
+	}
+}
+
+func parseExportFilter(r *http.Request) (storage.ExportFilter, error) {
+	filter := storage.DefaultExportFilter
+	minScore := r.URL.Query().Get("minscore")
+	if minScore != "" {
+		score, err := strconv.ParseFloat(minScore, 64)
+		if err != nil {
+			return filter, err
+		}
+		filter.MinScore = score
+	}
+	since := r.URL.Query().Get("since")
+	if since != "" {
+		timestamp, err := strconv.ParseInt(since, 10, 64)
+		if err != nil {
+			date, dateErr := time.Parse("2006-01-02", since)
+			if dateErr != nil {
+				return filter, dateErr
+			}
+			timestamp = date.Unix()
+		}
+		filter.ChangedSince = timestamp
+	}
+	return filter, nil
 }
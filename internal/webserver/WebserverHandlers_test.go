@@ -0,0 +1,269 @@
+package webserver
+
+import (
+	"BarcodeServer/internal/configuration"
+	"BarcodeServer/internal/federation"
+	"BarcodeServer/internal/mirror"
+	"BarcodeServer/internal/storage"
+	"BarcodeServer/internal/storage/memory"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	testUuid    = "abcdefghijabcdefghijabcdefghij12"
+	testBarcode = "4006040000013"
+)
+
+// setupHandlerTest uses a new memory store and a daily limit of 5 requests for all handlers
+func setupHandlerTest(t *testing.T) {
+	t.Helper()
+	store = memory.New()
+	config := configuration.Get()
+	previousCalls, previousUploads := config.ApiDailyCalls, config.ApiDailyCallsUpload
+	config.ApiDailyCalls = 5
+	config.ApiDailyCallsUpload = 5
+	t.Cleanup(func() {
+		config.ApiDailyCalls = previousCalls
+		config.ApiDailyCallsUpload = previousUploads
+	})
+}
+
+func newApiRequest(method, path string, body string, headers map[string]string) *http.Request {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+	return r
+}
+
+func serve(handler http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// expectError checks that the legacy API responded with errorMessage
+func expectError(t *testing.T, w *httptest.ResponseRecorder, errorMessage string) {
+	t.Helper()
+	var response ResponseError
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+	if response.Result != "error" || response.ErrorMessage != errorMessage {
+		t.Errorf("expected error %q, got %q", errorMessage, w.Body.String())
+	}
+}
+
+func expectOk(t *testing.T, w *httptest.ResponseRecorder) {
+	t.Helper()
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != GENERIC_RESPONSE_OK {
+		t.Errorf("expected %s, got %d %q", GENERIC_RESPONSE_OK, w.Code, w.Body.String())
+	}
+}
+
+func getBarcode(barcode string) *httptest.ResponseRecorder {
+	return serve(handleGetBarcode, newApiRequest(http.MethodGet, "/get", "", map[string]string{"uuid": testUuid, "barcode": barcode}))
+}
+
+func TestHandleGetBarcode(t *testing.T) {
+	setupHandlerTest(t)
+	store.AddGrocyBarcodes(storage.GrocyBarcodes{Barcodes: []storage.Barcode{{
+		Barcode:  testBarcode,
+		Name:     "Organic milk",
+		Metadata: storage.Metadata{Brand: "Example"},
+	}}}, testUuid, storage.SourceUser)
+
+	w := getBarcode(testBarcode)
+	var found ResponseBarcodeFound
+	err := json.Unmarshal(w.Body.Bytes(), &found)
+	if err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+	if found.Result != "OK" || !reflect.DeepEqual(found.FoundNames, []string{"Organic milk"}) || found.Metadata == nil || found.Metadata.Brand != "Example" {
+		t.Errorf("unexpected response %q", w.Body.String())
+	}
+	if w.Header().Get("cache-control") != "private" {
+		t.Errorf("unexpected cache-control %q", w.Header().Get("cache-control"))
+	}
+
+	w = getBarcode("4006040000020")
+	expectError(t, w, "Barcode not found")
+	if misses := store.GetLookupMisses(1); misses["4006040000020"] != 1 {
+		t.Errorf("lookup miss has not been logged: %v", misses)
+	}
+
+	expectError(t, getBarcode("4006040000014"), "Bad request")
+	expectError(t, getBarcode("abc"), "Bad request")
+	// Store-internal codes are never stored
+	expectError(t, getBarcode("2000000000008"), "Barcode not found")
+
+	w = serve(handleGetBarcode, newApiRequest(http.MethodGet, "/get", "", map[string]string{"uuid": "short", "barcode": testBarcode}))
+	expectError(t, w, "Bad request")
+}
+
+func TestHandleGetBarcodeRateLimit(t *testing.T) {
+	setupHandlerTest(t)
+	for i := 0; i < configuration.Get().ApiDailyCalls; i++ {
+		expectError(t, getBarcode(testBarcode), "Barcode not found")
+	}
+	w := getBarcode(testBarcode)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	expectError(t, w, "Too many requests")
+}
+
+func TestHandleVote(t *testing.T) {
+	setupHandlerTest(t)
+	store.AddGrocyBarcodes(storage.GrocyBarcodes{Barcodes: []storage.Barcode{
+		{Barcode: testBarcode, Name: "First name"},
+		{Barcode: testBarcode, Name: "Second name"},
+	}}, testUuid, storage.SourceUser)
+	vote := func(barcode, name string) *httptest.ResponseRecorder {
+		return serve(handleVote, newApiRequest(http.MethodGet, "/vote", "", map[string]string{"uuid": testUuid, "barcode": barcode, "name": name}))
+	}
+
+	expectOk(t, vote(testBarcode, "Second name"))
+	if names := store.GetBarcode(testBarcode, false); !reflect.DeepEqual(names, []string{"Second name", "First name"}) {
+		t.Errorf("vote has not been counted: %q", names)
+	}
+	votes := store.GetTotalVotes()
+	expectOk(t, vote(testBarcode, "Second name"))
+	if store.GetTotalVotes() != votes {
+		t.Error("second vote of the same address has been counted")
+	}
+	expectError(t, vote(testBarcode, "x"), "Bad request")
+	expectError(t, vote("4006040000014", "First name"), "Bad request")
+}
+
+func TestHandleReport(t *testing.T) {
+	setupHandlerTest(t)
+	store.AddGrocyBarcodes(storage.GrocyBarcodes{Barcodes: []storage.Barcode{{Barcode: testBarcode, Name: "Wrong name"}}}, testUuid, storage.SourceUser)
+	report := func(barcode, name string) *httptest.ResponseRecorder {
+		return serve(handleReport, newApiRequest(http.MethodGet, "/report", "", map[string]string{"uuid": testUuid, "barcode": barcode, "name": name}))
+	}
+
+	expectOk(t, report(testBarcode, "Wrong name"))
+	reports := store.GetReportList()
+	if len(reports) != 1 || reports[0].BarcodeAndName != testBarcode+":Wrong name" {
+		t.Errorf("unexpected reports %+v", reports)
+	}
+	expectError(t, report("", "Wrong name"), "Bad request")
+	expectError(t, report(testBarcode, ""), "Bad request")
+}
+
+func TestHandleAdd(t *testing.T) {
+	setupHandlerTest(t)
+	add := func(uuid, body string) *httptest.ResponseRecorder {
+		return serve(handleAdd, newApiRequest(http.MethodPost, "/add", body, map[string]string{"uuid": uuid}))
+	}
+
+	expectOk(t, add(testUuid, `{"ServerBarcodes":[{"Barcode":"`+testBarcode+`","Name":"Organic milk"},{"Barcode":"123","Name":"Invalid"}]}`))
+	if names := store.GetBarcode(testBarcode, false); !reflect.DeepEqual(names, []string{"Organic milk"}) {
+		t.Errorf("barcode has not been added: %q", names)
+	}
+	if total := store.GetTotalBarcodes(); total != 1 {
+		t.Errorf("expected 1 barcode, got %d", total)
+	}
+
+	expectError(t, add(testUuid, `{}`), "Bad request")
+	expectError(t, add(testUuid, `not json`), "Bad request")
+	expectError(t, add("short", `{"ServerBarcodes":[]}`), "Bad request")
+}
+
+func TestVariableMeasureBarcodes(t *testing.T) {
+	setupHandlerTest(t)
+	body := `{"ServerBarcodes":[{"Barcode":"2112345012346","Name":"Mountain cheese"},{"Barcode":"` + storage.ScopedBarcode("2112345000008", "abcdefghijabcdefghijabcdefghij34") + `","Name":"Injected"}]}`
+	expectOk(t, serve(handleAdd, newApiRequest(http.MethodPost, "/add", body, map[string]string{"uuid": testUuid})))
+	if total := store.GetTotalBarcodes(); total != 1 {
+		t.Errorf("expected 1 barcode, got %d", total)
+	}
+
+	// The price of the looked up code differs from the uploaded one
+	w := getBarcode("2112345099996")
+	var found ResponseBarcodeFound
+	err := json.Unmarshal(w.Body.Bytes(), &found)
+	if err != nil || !reflect.DeepEqual(found.FoundNames, []string{"Mountain cheese"}) {
+		t.Errorf("unexpected response %q", w.Body.String())
+	}
+	if result := lookupBarcodes([]string{"2112345099996"}, testUuid, nil)[0]; result.barcode != "2112345000008" {
+		t.Errorf("expected the stripped barcode, got %q", result.barcode)
+	}
+	w = serve(handleGetBarcode, newApiRequest(http.MethodGet, "/get", "", map[string]string{"uuid": "abcdefghijabcdefghijabcdefghij34", "barcode": "2112345012346"}))
+	expectError(t, w, "Barcode not found")
+	if misses := store.GetLookupMisses(1); len(misses) != 0 {
+		t.Errorf("variable measure codes have been logged as lookup misses: %v", misses)
+	}
+
+	err = store.ExportBarcodes(storage.ExportFilter{MinScore: math.Inf(-1)}, func(record storage.ExportRecord) error {
+		t.Errorf("variable measure code has been exported: %+v", record)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestMirrorBootstrap replicates the store of the handlers into a mirror
+func TestMirrorBootstrap(t *testing.T) {
+	setupHandlerTest(t)
+	store.AddGrocyBarcodes(storage.GrocyBarcodes{Barcodes: []storage.Barcode{{Barcode: testBarcode, Name: "Organic milk"}}}, testUuid, storage.SourceUser)
+	store.ImportBarcode(storage.ExportRecord{
+		Barcode:  "4006040000020",
+		Metadata: []storage.ExportMetadata{{Field: storage.FieldBrand, Value: "Example", Score: 1}},
+	}, storage.ImportMerge)
+	store.ImportBarcode(storage.ExportRecord{
+		Barcode: "4006040000037",
+		Names:   []storage.ExportName{{Name: "Disputed name", Score: -3}},
+	}, storage.ImportMerge)
+	upstream := http.NewServeMux()
+	upstream.HandleFunc(federation.ExportPath, handleFederationExport)
+	upstream.HandleFunc(federation.ChangesPath, handleChanges)
+	server := httptest.NewServer(upstream)
+	defer server.Close()
+	config := configuration.Get()
+	previousUpstream, previousMirrorKey, previousFederationKey := config.MirrorUpstream, config.MirrorKey, config.FederationKey
+	config.MirrorUpstream, config.MirrorKey, config.FederationKey = server.URL, "federation key", "federation key"
+	t.Cleanup(func() {
+		config.MirrorUpstream, config.MirrorKey, config.FederationKey = previousUpstream, previousMirrorKey, previousFederationKey
+	})
+
+	local := memory.New()
+	// Deleted on the upstream server since the last bootstrap
+	local.ImportBarcode(storage.ExportRecord{Barcode: "4006040000044", Names: []storage.ExportName{{Name: "Deleted upstream", Score: 1}}}, storage.ImportMerge)
+	err := mirror.Replicate(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := make(map[string]storage.ExportRecord)
+	err = local.ExportBarcodes(storage.ExportFilter{MinScore: math.Inf(-1)}, func(record storage.ExportRecord) error {
+		records[record.Barcode] = record
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Errorf("expected 3 barcodes, got %+v", records)
+	}
+	if names := local.GetBarcode(testBarcode, false); !reflect.DeepEqual(names, []string{"Organic milk"}) {
+		t.Errorf("unexpected names %q", names)
+	}
+	if brand := local.GetMetadata([]string{"4006040000020"})[0].Brand; brand != "Example" {
+		t.Errorf("metadata-only record has not been replicated, brand %q", brand)
+	}
+	if names := records["4006040000037"].Names; len(names) != 1 || names[0].Score != -3 {
+		t.Errorf("name below the listed score has not been replicated: %+v", names)
+	}
+	if cursor := mirror.GetStatus().Cursor; cursor != store.GetChanges(0, 0).LatestCursor {
+		t.Errorf("expected cursor %d, got %d", store.GetChanges(0, 0).LatestCursor, cursor)
+	}
+}
@@ -0,0 +1,158 @@
+package webserver
+
+import (
+	"BarcodeServer/internal/configuration"
+	"BarcodeServer/internal/helper"
+	"BarcodeServer/internal/metrics"
+	"BarcodeServer/internal/mirror"
+	"BarcodeServer/internal/storage"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Results of a health check
+const (
+	healthOk      = "ok"
+	healthFail    = "fail"
+	healthSkipped = "skipped"
+)
+
+// maxStoreLatency is the time the storage backend may take to answer a ping
+// before the server is no longer ready
+const maxStoreLatency = time.Second
+
+// storePingTimeout is the time after which an unanswered ping is treated as failed
+const storePingTimeout = 5 * time.Second
+
+// ResponseHealth is the result of /healthz and /readyz
+type ResponseHealth struct {
+	// Status is "ok" if all checks succeeded, otherwise "fail"
+	Status string        `json:"Status"`
+	Checks []HealthCheck `json:"Checks,omitempty"`
+}
+
+// HealthCheck is the result of a single readiness check
+type HealthCheck struct {
+	Name string `json:"Name"`
+	// Status is "ok", "fail" or "skipped", if the check does not apply to this server
+	Status  string `json:"Status"`
+	Message string `json:"Message,omitempty"`
+}
+
+// handleHealth answers as long as the server is able to process requests. It
+// does not check the storage backend, so that the server is not restarted if
+// only Redis is unavailable
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	sendHealth(w, ResponseHealth{Status: healthOk})
+}
+
+// handleReady checks if the server is able to answer lookups. Responds with 503 if any check fails
+func handleReady(w http.ResponseWriter, r *http.Request) {
+	result := ResponseHealth{
+		Status: healthOk,
+		Checks: []HealthCheck{checkStore(), checkConfiguration(), checkImport(), checkFreeRam()},
+	}
+	for _, check := range result.Checks {
+		if check.Status == healthFail {
+			result.Status = healthFail
+		}
+	}
+	sendHealth(w, result)
+}
+
+func sendHealth(w http.ResponseWriter, result ResponseHealth) {
+	w.Header().Set("cache-control", "no-store")
+	w.Header().Set("Content-Type", contentTypeJson)
+	response, _ := json.Marshal(result)
+	if result.Status != healthOk {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, _ = w.Write(response)
+}
+
+func checkStore() HealthCheck {
+	result := HealthCheck{Name: "storage", Status: healthOk}
+	start := time.Now()
+	pingResult := make(chan error, 1)
+	go func() {
+		pingResult <- store.Ping()
+	}()
+	var err error
+	select {
+	case err = <-pingResult:
+	case <-time.After(storePingTimeout):
+		err = errors.New("no response within " + storePingTimeout.String())
+	}
+	latency := time.Since(start)
+	if err != nil {
+		result.Status = healthFail
+		result.Message = err.Error()
+		return result
+	}
+	result.Message = "latency " + latency.String()
+	if latency > maxStoreLatency {
+		result.Status = healthFail
+		result.Message = result.Message + ", more than " + maxStoreLatency.String()
+	}
+	return result
+}
+
+// checkConfiguration fails if the loaded configuration contains invalid values
+func checkConfiguration() HealthCheck {
+	err := configuration.Validate()
+	if err != nil {
+		return HealthCheck{Name: "configuration", Status: healthFail, Message: err.Error()}
+	}
+	return HealthCheck{Name: "configuration", Status: healthOk, Message: "version " + strconv.Itoa(configuration.Get().ConfigVersion)}
+}
+
+// checkImport fails if the Edeka import has not succeeded within ReadyMaxImportAge hours.
+// The import runs daily, so the default of 48 hours tolerates one failed run
+func checkImport() HealthCheck {
+	result := HealthCheck{Name: "import", Status: healthOk}
+	maxAgeHours := configuration.Get().ReadyMaxImportAge
+	if mirror.IsEnabled() || configuration.Get().ApiKeyEdeka == "" || maxAgeHours == 0 {
+		result.Status = healthSkipped
+		result.Message = "Edeka import is not enabled or not checked"
+		return result
+	}
+	maxAge := time.Duration(maxAgeHours) * time.Hour
+	lastImport, ok := metrics.LastSuccessfulImport(storage.SourceEdeka)
+	if !ok {
+		// The first import is running or has failed, the server is only reported as
+		// not ready once the import should have succeeded in the meantime
+		if time.Since(metrics.StartTime()) > maxAge {
+			result.Status = healthFail
+		}
+		result.Message = "no successful import since the start"
+		return result
+	}
+	age := time.Since(lastImport).Truncate(time.Second)
+	result.Message = "last successful import " + age.String() + " ago"
+	if age > maxAge {
+		result.Status = healthFail
+		result.Message = result.Message + ", more than " + maxAge.String()
+	}
+	return result
+}
+
+// checkFreeRam fails if less than ReadyMinFreeRam megabytes of RAM are free
+func checkFreeRam() HealthCheck {
+	result := HealthCheck{Name: "memory", Status: healthOk}
+	totalRam, freeRam, err := helper.GetRamBytes()
+	if err != nil {
+		result.Status = healthSkipped
+		result.Message = err.Error()
+		return result
+	}
+	result.Message = helper.ByteCountSI(freeRam) + " of " + helper.ByteCountSI(totalRam) + " free"
+	minFreeRam := configuration.Get().ReadyMinFreeRam
+	if minFreeRam > 0 && freeRam < uint64(minFreeRam)*1024*1024 {
+		result.Status = healthFail
+		result.Message = result.Message + ", less than " + strconv.Itoa(minFreeRam) + " MB"
+	}
+	return result
+}
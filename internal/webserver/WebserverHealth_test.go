@@ -0,0 +1,78 @@
+package webserver
+
+import (
+	"BarcodeServer/internal/configuration"
+	"BarcodeServer/internal/metrics"
+	"BarcodeServer/internal/storage"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// setupReadyTest uses a valid configuration without the Edeka import and without the RAM check
+func setupReadyTest(t *testing.T) *configuration.Configuration {
+	t.Helper()
+	setupHandlerTest(t)
+	config := configuration.Get()
+	previous := *config
+	config.StorageBackend = configuration.StorageMemory
+	config.WebserverPort = "127.0.0.1:18900"
+	config.ApiKeyEdeka = ""
+	config.ReadyMinFreeRam = 0
+	config.ReadyMaxImportAge = 48
+	t.Cleanup(func() {
+		*config = previous
+	})
+	return config
+}
+
+// checkReady returns the status code of /readyz and the status of every check
+func checkReady(t *testing.T) (int, map[string]string) {
+	t.Helper()
+	w := serve(handleReady, newApiRequest(http.MethodGet, "/readyz", "", nil))
+	var result ResponseHealth
+	err := json.Unmarshal(w.Body.Bytes(), &result)
+	if err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+	statuses := make(map[string]string)
+	for _, check := range result.Checks {
+		statuses[check.Name] = check.Status
+	}
+	return w.Code, statuses
+}
+
+func TestHandleReady(t *testing.T) {
+	setupReadyTest(t)
+	code, statuses := checkReady(t)
+	if code != http.StatusOK {
+		t.Errorf("expected the server to be ready, got %d %v", code, statuses)
+	}
+	if statuses["storage"] != healthOk || statuses["configuration"] != healthOk || statuses["import"] != healthSkipped {
+		t.Errorf("unexpected checks %v", statuses)
+	}
+}
+
+func TestHandleReadyConfiguration(t *testing.T) {
+	config := setupReadyTest(t)
+	config.StorageBackend = "unknown"
+	code, statuses := checkReady(t)
+	if code != http.StatusServiceUnavailable || statuses["configuration"] != healthFail {
+		t.Errorf("expected the configuration check to fail, got %d %v", code, statuses)
+	}
+}
+
+func TestHandleReadyImport(t *testing.T) {
+	config := setupReadyTest(t)
+	config.ApiKeyEdeka = "key"
+	metrics.ObserveImport(storage.SourceEdeka, 0, 1, nil)
+	code, statuses := checkReady(t)
+	if code != http.StatusOK || statuses["import"] != healthOk {
+		t.Errorf("expected a recent import to succeed, got %d %v", code, statuses)
+	}
+
+	config.ReadyMaxImportAge = 0
+	if _, statuses = checkReady(t); statuses["import"] != healthSkipped {
+		t.Errorf("expected the import check to be disabled, got %v", statuses)
+	}
+}
@@ -0,0 +1,283 @@
+package webserver
+
+import (
+	"BarcodeServer/internal/configuration"
+	"BarcodeServer/internal/helper"
+	"BarcodeServer/internal/metrics"
+	"BarcodeServer/internal/storage"
+	sessionmanager "BarcodeServer/internal/webserver/sessions"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Size of the charts in pixels
+const (
+	chartWidth  = 800
+	chartHeight = 200
+)
+
+// maxChartPoints is the maximum amount of points of a line, snapshots are combined if there are more
+const maxChartPoints = 200
+
+// chartColors are the colors of the lines of a chart. Charts have at most one line per color
+var chartColors = []string{"#4e9af1", "#f1a14e", "#6fcf6f", "#e05c5c", "#b07ae0", "#e0d05c", "#5ce0d0", "#d9d9d9"}
+
+// metricsRange is a time range that can be selected on the metrics page
+type metricsRange struct {
+	Name     string
+	Duration time.Duration
+}
+
+var metricsRanges = []metricsRange{
+	{Name: "6h", Duration: 6 * time.Hour},
+	{Name: "24h", Duration: 24 * time.Hour},
+	{Name: "7d", Duration: 7 * 24 * time.Hour},
+	{Name: "30d", Duration: 30 * 24 * time.Hour},
+	{Name: "90d", Duration: 90 * 24 * time.Hour},
+	{Name: "365d", Duration: 365 * 24 * time.Hour},
+}
+
+// defaultMetricsRange is the index of the range in metricsRanges that is shown if none is requested
+const defaultMetricsRange = 1
+
+// availableMetricsRanges returns the ranges that fit into the retention of the snapshots.
+// The default range is always available
+func availableMetricsRanges() []metricsRange {
+	retention := time.Duration(metrics.RetentionDays()) * 24 * time.Hour
+	var result []metricsRange
+	for i, timeRange := range metricsRanges {
+		if i <= defaultMetricsRange || timeRange.Duration <= retention {
+			result = append(result, timeRange)
+		}
+	}
+	return result
+}
+
+// parseMetricsRange returns the range that is requested with the parameter range
+func parseMetricsRange(r *http.Request) metricsRange {
+	name := r.URL.Query().Get("range")
+	for _, timeRange := range availableMetricsRanges() {
+		if timeRange.Name == name {
+			return timeRange
+		}
+	}
+	return metricsRanges[defaultMetricsRange]
+}
+
+// MetricsPath is the path of the metrics in the Prometheus text format
+const MetricsPath = "/metrics"
+
+// handleMetrics sends all metrics in the Prometheus text format. If MetricsToken
+// is set, it has to be sent as bearer token
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("cache-control", "private")
+	token := configuration.Get().MetricsToken
+	if token != "" && !helper.SecureStringEqual(r.Header.Get("Authorization"), "Bearer "+token) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Invalid metrics token", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", metrics.ContentType)
+	err := metrics.WritePrometheus(w, store)
+	if err != nil {
+		log.Println("Unable to send metrics: " + err.Error())
+	}
+}
+
+func handleAdminMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("cache-control", "private")
+	if !sessionmanager.IsValidSession(w, r) {
+		time.Sleep(1 * time.Second)
+		redirect(w, r, "../login")
+		return
+	}
+	timeRange := parseMetricsRange(r)
+	end := time.Now()
+	start := end.Add(-timeRange.Duration)
+	snapshots := store.GetMetricsSnapshots(start.Unix())
+	bucketSize := timeRange.Duration / maxChartPoints
+	if bucketSize < metrics.SnapshotInterval {
+		bucketSize = metrics.SnapshotInterval
+	}
+	buckets := groupSnapshots(snapshots, start, bucketSize)
+
+	view := metricsView{
+		Range:         timeRange.Name,
+		Ranges:        availableMetricsRanges(),
+		RetentionDays: metrics.RetentionDays(),
+		Snapshots:     len(snapshots),
+		Start:         start.Format("2006-01-02 15:04"),
+		End:           end.Format("2006-01-02 15:04"),
+		Width:         chartWidth,
+		Height:        chartHeight,
+	}
+	newChart := func(title string, rate time.Duration, format func(float64) string, lines ...chartSeries) {
+		view.Charts = append(view.Charts, buildChart(title, buckets, bucketSize, timeRange.Duration, rate, format, lines))
+	}
+	newChart("Total barcodes", 0, formatCount, gaugeSeries("Barcodes", func(s storage.MetricsSnapshot) float64 { return float64(s.TotalBarcodes) }))
+	newChart("Users", 0, formatCount,
+		gaugeSeries("Unique users", func(s storage.MetricsSnapshot) float64 { return float64(s.Users) }),
+		gaugeSeries("Active users", func(s storage.MetricsSnapshot) float64 { return float64(s.ActiveUsers) }))
+	newChart("Votes and reports", 0, formatCount,
+		gaugeSeries("Votes", func(s storage.MetricsSnapshot) float64 { return float64(s.TotalVotes) }),
+		gaugeSeries("Reports", func(s storage.MetricsSnapshot) float64 { return float64(s.TotalReports) }))
+	newChart("RAM usage", 0, func(value float64) string { return helper.ByteCountSI(uint64(value)) },
+		gaugeSeries("RAM", func(s storage.MetricsSnapshot) float64 { return float64(s.RamUsage) }))
+	newChart("Requests per minute", time.Minute, formatRate, requestSeries(snapshots)...)
+	newChart("Rejected requests per hour", time.Hour, formatRate,
+		counterSeries("Rate limited", metrics.CounterRateLimited),
+		counterSeries("Bad requests", metrics.CounterBadRequests))
+	newChart("Uploads and imports per hour", time.Hour, formatRate,
+		counterSeries("Uploaded barcodes", metrics.CounterUploads),
+		counterSeries("Imported barcodes", metrics.CounterImported),
+		counterSeries("Rejected rows", metrics.CounterImportRejected),
+		counterSeries("Failed imports", metrics.CounterImportFailed))
+
+	err := templateFolder.ExecuteTemplate(w, "metrics", view)
+	if err != nil {
+		log.Panicln(err)
+	}
+}
+
+type metricsView struct {
+	Range         string
+	Ranges        []metricsRange
+	RetentionDays int
+	// Snapshots is the amount of snapshots within the range
+	Snapshots int
+	Start     string
+	End       string
+	Width     int
+	Height    int
+	Charts    []metricsChart
+}
+
+type metricsChart struct {
+	Title string
+	// MaxValue is the label of the top of the chart, the bottom is always 0
+	MaxValue string
+	Lines    []metricsLine
+}
+
+type metricsLine struct {
+	Name  string
+	Color string
+	// Points are the coordinates of the line in the format of the SVG attribute points
+	Points string
+}
+
+// chartSeries is a line of a chart. Counters are summed up within a bucket, for
+// gauges the value of the last snapshot of the bucket is used
+type chartSeries struct {
+	name      string
+	isCounter bool
+	value     func(snapshot storage.MetricsSnapshot) float64
+}
+
+func gaugeSeries(name string, value func(snapshot storage.MetricsSnapshot) float64) chartSeries {
+	return chartSeries{name: name, value: value}
+}
+
+func counterSeries(name, counter string) chartSeries {
+	return chartSeries{name: name, isCounter: true, value: func(snapshot storage.MetricsSnapshot) float64 {
+		return float64(snapshot.Counters[counter])
+	}}
+}
+
+// requestSeries returns a line for each endpoint that has been requested, ordered by
+// the amount of requests. If there are more endpoints than chartColors, only the
+// most requested endpoints are shown
+func requestSeries(snapshots []storage.MetricsSnapshot) []chartSeries {
+	totals := make(map[string]float64)
+	for _, snapshot := range snapshots {
+		for endpoint, amount := range snapshot.Requests {
+			totals[endpoint] += float64(amount)
+		}
+	}
+	endpoints := storage.Members(storage.SortByScore(totals, 1))
+	if len(endpoints) > len(chartColors) {
+		endpoints = endpoints[:len(chartColors)]
+	}
+	result := make([]chartSeries, len(endpoints))
+	for i, endpoint := range endpoints {
+		endpoint := endpoint
+		result[i] = chartSeries{name: endpoint, isCounter: true, value: func(snapshot storage.MetricsSnapshot) float64 {
+			return float64(snapshot.Requests[endpoint])
+		}}
+	}
+	return result
+}
+
+// groupSnapshots splits the snapshots into buckets of bucketSize, starting at start
+func groupSnapshots(snapshots []storage.MetricsSnapshot, start time.Time, bucketSize time.Duration) map[int][]storage.MetricsSnapshot {
+	result := make(map[int][]storage.MetricsSnapshot)
+	for _, snapshot := range snapshots {
+		index := int(time.Unix(snapshot.Time, 0).Sub(start) / bucketSize)
+		result[index] = append(result[index], snapshot)
+	}
+	return result
+}
+
+// buildChart calculates the lines of a chart. If rate is set, counters are shown
+// as the amount per rate, e.g. per minute. Buckets without snapshots are skipped
+func buildChart(title string, buckets map[int][]storage.MetricsSnapshot, bucketSize, duration, rate time.Duration, format func(float64) string, series []chartSeries) metricsChart {
+	indices := make([]int, 0, len(buckets))
+	for index := range buckets {
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+
+	values := make([][]float64, len(series))
+	var maxValue float64
+	for i, line := range series {
+		values[i] = make([]float64, len(indices))
+		for j, index := range indices {
+			bucket := buckets[index]
+			value := line.value(bucket[len(bucket)-1])
+			if line.isCounter {
+				value = 0
+				for _, snapshot := range bucket {
+					value += line.value(snapshot)
+				}
+				value = value * float64(rate) / float64(bucketSize)
+			}
+			values[i][j] = value
+			if value > maxValue {
+				maxValue = value
+			}
+		}
+	}
+	if maxValue == 0 {
+		maxValue = 1
+	}
+
+	chart := metricsChart{Title: title, MaxValue: format(maxValue)}
+	for i, line := range series {
+		points := make([]string, len(indices))
+		for j, index := range indices {
+			center := (time.Duration(index) * bucketSize) + bucketSize/2
+			x := math.Min(float64(chartWidth)*float64(center)/float64(duration), chartWidth)
+			y := float64(chartHeight) * (1 - values[i][j]/maxValue)
+			points[j] = strconv.FormatFloat(x, 'f', 1, 64) + "," + strconv.FormatFloat(y, 'f', 1, 64)
+		}
+		chart.Lines = append(chart.Lines, metricsLine{
+			Name:   line.name,
+			Color:  chartColors[i%len(chartColors)],
+			Points: strings.Join(points, " "),
+		})
+	}
+	return chart
+}
+
+func formatCount(value float64) string {
+	return strconv.FormatFloat(value, 'f', 0, 64)
+}
+
+func formatRate(value float64) string {
+	return strconv.FormatFloat(value, 'f', 2, 64)
+}
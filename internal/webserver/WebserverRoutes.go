@@ -0,0 +1,471 @@
+package webserver
+
+import (
+	"BarcodeServer/internal/export"
+	"BarcodeServer/internal/federation"
+	"BarcodeServer/internal/mirror"
+	"BarcodeServer/internal/openapi"
+	"BarcodeServer/internal/storage"
+	"encoding/json"
+	"net/http"
+)
+
+// OpenApiPath is the path of the OpenAPI description of all endpoints
+const OpenApiPath = "/openapi.json"
+
+const (
+	contentTypeJson = "application/json"
+	contentTypeText = "text/plain"
+	contentTypeHtml = "text/html"
+)
+
+// Names of the security schemes of the OpenAPI description
+const (
+	securityFederationKey = "federationKey"
+	securityAdminSession  = "adminSession"
+)
+
+// route is an endpoint of the webserver. All routes are registered from the
+// route table, so that the OpenAPI description cannot miss an endpoint.
+// Routes without operations are not described
+type route struct {
+	path       string
+	handler    http.HandlerFunc
+	operations []openapi.Operation
+}
+
+// Parameters that are shared by several operations
+var (
+	headerUuid = openapi.Parameter{
+		Name:        "uuid",
+		In:          openapi.InHeader,
+		Description: "Random 32 character identifier of the Barcode Buddy installation, used for rate limiting",
+		Required:    true,
+	}
+	headerBarcode = openapi.Parameter{
+		Name:        "barcode",
+		In:          openapi.InHeader,
+		Description: "EAN-8, EAN-13, UPC-A, UPC-E or GTIN-14 barcode with a valid check digit",
+		Required:    true,
+	}
+	headerName = openapi.Parameter{
+		Name:        "name",
+		In:          openapi.InHeader,
+		Description: "Name of the product, at least 2 characters",
+		Required:    true,
+	}
+	queryFormat = openapi.Parameter{
+		Name:        "format",
+		In:          openapi.InQuery,
+		Description: "Export format: " + export.FormatCsv + " (default), " + export.FormatJson + ", " + export.FormatNdjson + " or " + export.FormatOpenFoodFacts,
+	}
+	queryMinScore = openapi.Parameter{
+		Name:        "minscore",
+		In:          openapi.InQuery,
+		Description: "Only names and metadata with at least this score are exported, -inf exports all of them",
+		Schema:      float64(0),
+	}
+	queryLang = openapi.Parameter{
+		Name:        "lang",
+		In:          openapi.InQuery,
+		Description: "Comma separated list of preferred languages, e.g. \"de,en\". Names in these languages are returned first. Overrides Accept-Language",
+	}
+	headerAcceptLanguage = openapi.Parameter{
+		Name:        "Accept-Language",
+		In:          openapi.InHeader,
+		Description: "Names in the preferred languages are returned first",
+	}
+	querySince = openapi.Parameter{
+		Name:        "since",
+		In:          openapi.InQuery,
+		Description: "Only barcodes changed after this date (YYYY-MM-DD) or unix time are exported",
+	}
+)
+
+// Bodies and responses that are shared by several operations
+var (
+	bodyLegacyError = &openapi.Body{ContentType: contentTypeJson, Schema: ResponseError{}}
+	bodyErrorV2     = &openapi.Body{ContentType: contentTypeJson, Schema: ResponseErrorV2{}}
+	bodyExport      = &openapi.Body{ContentType: "application/octet-stream", Schema: openapi.Schema{
+		Type:        "string",
+		Format:      "binary",
+		Description: "Content type depends on the format: " + export.ContentType(export.FormatCsv) + ", " + export.ContentType(export.FormatJson) + ", " + export.ContentType(export.FormatNdjson) + " or " + export.ContentType(export.FormatOpenFoodFacts),
+	}}
+	bodyHtml = &openapi.Body{ContentType: contentTypeHtml, Schema: ""}
+
+	responseLegacyOk           = openapi.Response{Status: http.StatusOK, Body: &openapi.Body{ContentType: contentTypeJson, Schema: ResponseGeneric{}}}
+	responseLegacyBadRequest   = openapi.Response{Status: http.StatusTooManyRequests, Description: "Bad request or too many requests. For compatibility with old clients, invalid requests are answered with 429 as well", Body: bodyLegacyError}
+	responseLegacyReadOnly     = openapi.Response{Status: http.StatusForbidden, Description: "The server is a read-only mirror", Body: bodyLegacyError}
+	responseRedirectLogin      = openapi.Response{Status: http.StatusTemporaryRedirect, Description: "Redirect to the login page if no valid session exists", Headers: map[string]string{"Location": "Login page"}}
+	responseErrorInvalid       = openapi.Response{Status: http.StatusBadRequest, Description: "Invalid request, uuid or barcode", Body: bodyErrorV2}
+	responseErrorRateLimited   = openapi.Response{Status: http.StatusTooManyRequests, Description: "Daily limit of requests reached", Headers: map[string]string{"Retry-After": "Seconds until the limit is reset at midnight"}, Body: bodyErrorV2}
+	responseErrorMethod        = openapi.Response{Status: http.StatusMethodNotAllowed, Description: "Only POST is allowed", Headers: map[string]string{"Allow": "Allowed methods"}, Body: bodyErrorV2}
+	responseErrorReadOnly      = openapi.Response{Status: http.StatusForbidden, Description: "The server is a read-only mirror", Body: bodyErrorV2}
+	responseFederationNotValid = openapi.Response{Status: http.StatusUnauthorized, Description: "Invalid federation key", Body: &openapi.Body{ContentType: contentTypeText, Schema: ""}}
+)
+
+// metricsRoute returns the endpoint of the metrics, it is only part of the
+// webserver if no separate MetricsAddress is configured
+func metricsRoute() route {
+	return route{path: MetricsPath, handler: handleMetrics, operations: []openapi.Operation{{
+		Method:      http.MethodGet,
+		Summary:     "Returns metrics of the server in the Prometheus text format",
+		Description: "Requires the header \"Authorization: Bearer <MetricsToken>\" if MetricsToken is set",
+		Tag:         "monitoring",
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Body: &openapi.Body{ContentType: "text/plain; version=0.0.4", Schema: ""}},
+			{Status: http.StatusUnauthorized, Description: "Invalid or missing metrics token", Headers: map[string]string{"WWW-Authenticate": "Bearer"}, Body: &openapi.Body{ContentType: contentTypeText, Schema: ""}},
+		},
+	}}}
+}
+
+// routes returns all endpoints of the webserver
+func routes() []route {
+	return []route{
+		{path: "/", handler: handleHome},
+		{path: "/ping", handler: handlePing, operations: []openapi.Operation{{
+			Method:    http.MethodGet,
+			Summary:   "Checks if the server is running",
+			Tag:       "legacy",
+			Responses: []openapi.Response{{Status: http.StatusOK, Body: &openapi.Body{ContentType: contentTypeText, Schema: openapi.Schema{Type: "string", Enum: []string{"pong"}}}}},
+		}}},
+		{path: "/healthz", handler: handleHealth, operations: []openapi.Operation{{
+			Method:      http.MethodGet,
+			Summary:     "Liveness check, succeeds as long as the server processes requests",
+			Description: "Does not check the storage backend, use /readyz to check if the server can answer lookups",
+			Tag:         "monitoring",
+			Responses:   []openapi.Response{{Status: http.StatusOK, Body: &openapi.Body{ContentType: contentTypeJson, Schema: ResponseHealth{}}}},
+		}}},
+		{path: "/readyz", handler: handleReady, operations: []openapi.Operation{{
+			Method:      http.MethodGet,
+			Summary:     "Readiness check of the storage backend, the configuration, the Edeka import and the free RAM",
+			Description: "Fails if the storage backend does not answer within 1 second, the configuration contains invalid values, the last successful Edeka import is older than ReadyMaxImportAge hours or less than ReadyMinFreeRam megabytes of RAM are free",
+			Tag:         "monitoring",
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Description: "All checks succeeded", Body: &openapi.Body{ContentType: contentTypeJson, Schema: ResponseHealth{}}},
+				{Status: http.StatusServiceUnavailable, Description: "At least one check failed", Body: &openapi.Body{ContentType: contentTypeJson, Schema: ResponseHealth{}}},
+			},
+		}}},
+		{path: "/amount", handler: handleAmount, operations: []openapi.Operation{{
+			Method:    http.MethodGet,
+			Summary:   "Returns the amount of stored barcodes as plain number",
+			Tag:       "legacy",
+			Responses: []openapi.Response{{Status: http.StatusOK, Body: &openapi.Body{ContentType: contentTypeText, Schema: 0}}},
+		}}},
+		{path: "/get", handler: handleGetBarcode, operations: []openapi.Operation{{
+			Method:      http.MethodGet,
+			Summary:     "Returns the names of a barcode",
+			Description: "Store-internal codes are never found, as they identify different products in different stores. Variable measure codes are only found for the uuid that uploaded them, regardless of the embedded price or weight",
+			Tag:         "legacy",
+			Parameters:  []openapi.Parameter{headerUuid, headerBarcode, queryLang, headerAcceptLanguage},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Description: "ResponseBarcodeFound if the barcode has been found, otherwise ResponseError", Body: &openapi.Body{ContentType: contentTypeJson, Schema: openapi.OneOf(ResponseBarcodeFound{}, ResponseError{})}},
+				responseLegacyBadRequest,
+			},
+		}}},
+		{path: "/get/batch", handler: handleGetBatch, operations: []openapi.Operation{{
+			Method:      http.MethodPost,
+			Summary:     "Returns the names of up to 100 barcodes",
+			Description: "Every barcode counts as a single request towards the daily limit",
+			Tag:         "legacy",
+			Parameters:  []openapi.Parameter{headerUuid, queryLang, headerAcceptLanguage},
+			RequestBody: &openapi.Body{ContentType: contentTypeJson, Schema: RequestBatch{}},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Body: &openapi.Body{ContentType: contentTypeJson, Schema: ResponseBatch{}}},
+				responseLegacyBadRequest,
+			},
+		}}},
+		{path: "/vote", handler: mirrorWrites(handleVote, sendReadOnly), operations: []openapi.Operation{{
+			Method:     http.MethodGet,
+			Summary:    "Votes for the name of a barcode",
+			Tag:        "legacy",
+			Parameters: []openapi.Parameter{headerUuid, headerBarcode, headerName},
+			Responses:  []openapi.Response{responseLegacyOk, responseLegacyReadOnly, responseLegacyBadRequest},
+		}}},
+		{path: "/report", handler: mirrorWrites(handleReport, sendReadOnly), operations: []openapi.Operation{{
+			Method:     http.MethodGet,
+			Summary:    "Reports the name of a barcode as wrong",
+			Tag:        "legacy",
+			Parameters: []openapi.Parameter{headerUuid, headerBarcode, headerName},
+			Responses:  []openapi.Response{responseLegacyOk, responseLegacyReadOnly, responseLegacyBadRequest},
+		}}},
+		{path: "/add", handler: mirrorWrites(handleAdd, sendReadOnly), operations: []openapi.Operation{{
+			Method:      http.MethodPost,
+			Summary:     "Uploads the barcodes of a Grocy installation",
+			Tag:         "legacy",
+			Parameters:  []openapi.Parameter{headerUuid},
+			RequestBody: &openapi.Body{ContentType: contentTypeJson, Schema: storage.GrocyBarcodes{}},
+			Responses:   []openapi.Response{responseLegacyOk, responseLegacyReadOnly, responseLegacyBadRequest},
+		}}},
+		{path: federation.ChangesPath, handler: handleChanges, operations: []openapi.Operation{{
+			Method:      http.MethodGet,
+			Summary:     "Returns all changes after a cursor",
+			Description: "Clients sending a uuid are rate limited, servers sending the federation key are not",
+			Tag:         "federation",
+			Parameters: []openapi.Parameter{
+				{Name: "uuid", In: openapi.InHeader, Description: "Required if no federation key is sent"},
+				{Name: federation.HeaderKey, In: openapi.InHeader, Description: "Federation key of the server"},
+				{Name: "since", In: openapi.InQuery, Description: "Cursor of the last known change, 0 if not set", Schema: int64(0)},
+				{Name: "limit", In: openapi.InQuery, Description: "Maximum amount of changes, default 1000, at most 10000", Schema: 0},
+			},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Body: &openapi.Body{ContentType: contentTypeJson, Schema: ResponseChanges{}}},
+				{Status: http.StatusGone, Description: "The changes after the cursor are not stored anymore, a full export is required", Body: bodyLegacyError},
+				responseLegacyBadRequest,
+			},
+		}}},
+		{path: mirror.StatusPath, handler: handleMirrorStatus, operations: []openapi.Operation{{
+			Method:  http.MethodGet,
+			Summary: "Returns the replication state of a read-only mirror",
+			Tag:     "federation",
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Body: &openapi.Body{ContentType: contentTypeJson, Schema: mirror.Status{}}},
+				{Status: http.StatusNotFound, Description: "The server is not a mirror"},
+			},
+		}}},
+		{path: federation.ExportPath, handler: handleFederationExport, operations: []openapi.Operation{{
+			Method:     http.MethodGet,
+			Summary:    "Downloads all barcodes",
+			Tag:        "federation",
+			Parameters: []openapi.Parameter{queryFormat, queryMinScore, querySince},
+			Security:   []string{securityFederationKey},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Headers: map[string]string{federation.HeaderCursor: "Cursor of the latest change at the start of the export"}, Body: bodyExport},
+				{Status: http.StatusBadRequest, Description: "Invalid export parameters", Body: &openapi.Body{ContentType: contentTypeText, Schema: ""}},
+				responseFederationNotValid,
+			},
+		}}},
+		{path: "/login", handler: handleLogin, operations: []openapi.Operation{{
+			Method:  http.MethodPost,
+			Summary: "Logs in the admin",
+			Tag:     "admin",
+			RequestBody: &openapi.Body{ContentType: "application/x-www-form-urlencoded", Schema: openapi.Schema{
+				Type:       "object",
+				Properties: map[string]*openapi.Schema{"username": {Type: "string"}, "password": {Type: "string", Format: "password"}},
+				Required:   []string{"password", "username"},
+			}},
+			Responses: []openapi.Response{
+				{Status: http.StatusTemporaryRedirect, Description: "Redirect to the admin page, sets the session cookie", Headers: map[string]string{"Set-Cookie": "Session cookie"}},
+				{Status: http.StatusOK, Description: "Login page, shows an error if the login failed", Body: bodyHtml},
+			},
+		}}},
+		{path: "/logout", handler: handleLogout, operations: []openapi.Operation{{
+			Method:    http.MethodGet,
+			Summary:   "Logs out the admin",
+			Tag:       "admin",
+			Responses: []openapi.Response{{Status: http.StatusTemporaryRedirect, Description: "Redirect to the login page"}},
+		}}},
+		{path: "/admin", handler: handleAdmin, operations: []openapi.Operation{{
+			Method:      http.MethodGet,
+			Summary:     "Shows the admin page, handles reports and merge proposals or downloads all barcodes",
+			Description: "If export is set, all barcodes are downloaded with the parameters format, minscore and since",
+			Tag:         "admin",
+			Parameters: []openapi.Parameter{
+				{Name: "delete", In: openapi.InQuery, Description: "Id of a report, removes the reported name", Schema: 0},
+				{Name: "dismiss", In: openapi.InQuery, Description: "Id of a report, dismisses the report", Schema: 0},
+				{Name: "merge", In: openapi.InQuery, Description: "Id of a merge proposal, merges the similar names", Schema: 0},
+				{Name: "keepnames", In: openapi.InQuery, Description: "Id of a merge proposal, dismisses the proposal", Schema: 0},
+				{Name: "findsimilar", In: openapi.InQuery, Description: "Searches all barcodes for similar names in the background if set"},
+				{Name: "export", In: openapi.InQuery, Description: "Downloads all barcodes if set"},
+				{Name: "exportmisses", In: openapi.InQuery, Description: "Downloads the unknown barcodes that have been requested within the last days as CSV if set"},
+				{Name: "days", In: openapi.InQuery, Description: "Time window of the most requested unknown barcodes in days: 1, 7 (default) or 30", Schema: 0},
+				{Name: "popular", In: openapi.InQuery, Description: "Time window of the most popular and trending barcodes in days: 1, 7 (default) or 30, 0 shows the all-time popular barcodes", Schema: 0},
+				queryFormat, queryMinScore, querySince,
+			},
+			Security: []string{securityAdminSession},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Description: "Admin page, or the export if requested", Body: bodyHtml},
+				responseRedirectLogin,
+			},
+		}}},
+		{path: "/admin/import", handler: handleAdminImport, operations: []openapi.Operation{{
+			Method:  http.MethodPost,
+			Summary: "Imports an exported file",
+			Tag:     "admin",
+			RequestBody: &openapi.Body{ContentType: "multipart/form-data", Schema: openapi.Schema{
+				Type: "object",
+				Properties: map[string]*openapi.Schema{
+					"file":   {Type: "string", Format: "binary"},
+					"mode":   {Type: "string", Enum: []string{"merge", "replace"}},
+					"format": {Type: "string", Description: "Detected automatically if not set", Enum: []string{export.FormatCsv, export.FormatJson, export.FormatNdjson, export.FormatOpenFoodFacts}},
+				},
+				Required: []string{"file"},
+			}},
+			Security: []string{securityAdminSession},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Description: "Summary of the import", Body: bodyHtml},
+				responseRedirectLogin,
+			},
+		}}},
+		{path: "/admin/metrics", handler: handleAdminMetrics, operations: []openapi.Operation{{
+			Method:      http.MethodGet,
+			Summary:     "Shows charts of the statistics of the server over time",
+			Description: "A snapshot of the statistics is stored every 5 minutes and kept for MetricsRetention days",
+			Tag:         "admin",
+			Parameters: []openapi.Parameter{
+				{Name: "range", In: openapi.InQuery, Description: "Time range of the charts: 6h, 24h (default), 7d, 30d, 90d or 365d. Ranges longer than MetricsRetention are not available", Schema: openapi.Schema{Type: "string", Enum: []string{"6h", "24h", "7d", "30d", "90d", "365d"}}},
+			},
+			Security: []string{securityAdminSession},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Description: "Page with the charts", Body: bodyHtml},
+				responseRedirectLogin,
+			},
+		}}},
+		{path: "/v2/", handler: handleUnknownV2},
+		{path: "/v2/lookup", handler: handleLookupV2, operations: []openapi.Operation{
+			{
+				Method:  http.MethodGet,
+				Summary: "Returns the names of a barcode",
+				Tag:     "v2",
+				Parameters: []openapi.Parameter{
+					{Name: "uuid", In: openapi.InQuery, Description: headerUuid.Description, Required: true},
+					{Name: "barcode", In: openapi.InQuery, Description: headerBarcode.Description, Required: true},
+					queryLang,
+					headerAcceptLanguage,
+				},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Body: &openapi.Body{ContentType: contentTypeJson, Schema: ResponseLookupV2{}}},
+					{Status: http.StatusNotFound, Description: "Barcode not found", Body: bodyErrorV2},
+					responseErrorInvalid,
+					responseErrorRateLimited,
+				},
+			},
+			{
+				Method:      http.MethodPost,
+				Summary:     "Returns the names of up to 100 barcodes",
+				Description: "Every barcode counts as a single request towards the daily limit. Barcodes that are invalid or have not been found contain an error",
+				Tag:         "v2",
+				Parameters:  []openapi.Parameter{queryLang, headerAcceptLanguage},
+				RequestBody: &openapi.Body{ContentType: contentTypeJson, Schema: RequestLookupV2{}},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Body: &openapi.Body{ContentType: contentTypeJson, Schema: ResponseBatchV2{}}},
+					responseErrorInvalid,
+					responseErrorRateLimited,
+				},
+			},
+		}},
+		{path: "/v2/vote", handler: mirrorWrites(handleVoteV2, sendReadOnlyV2), operations: []openapi.Operation{nameOperationV2("Votes for the name of a barcode")}},
+		{path: "/v2/report", handler: mirrorWrites(handleReportV2, sendReadOnlyV2), operations: []openapi.Operation{nameOperationV2("Reports the name of a barcode as wrong")}},
+		{path: "/v2/metadata/vote", handler: mirrorWrites(handleMetadataVoteV2, sendReadOnlyV2), operations: []openapi.Operation{{
+			Method:      http.MethodPost,
+			Summary:     "Votes for a metadata value of a barcode",
+			Description: "Values that do not exist yet are added. Quantities are sent with their unit, e.g. \"500 g\"",
+			Tag:         "v2",
+			RequestBody: &openapi.Body{ContentType: contentTypeJson, Schema: RequestMetadataVoteV2{}},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Body: &openapi.Body{ContentType: contentTypeJson, Schema: ResponseNameV2{}}},
+				{Status: http.StatusNotFound, Description: "Barcode not found", Body: bodyErrorV2},
+				responseErrorInvalid,
+				responseErrorReadOnly,
+				responseErrorMethod,
+				responseErrorRateLimited,
+			},
+		}}},
+		{path: "/v2/barcodes", handler: mirrorWrites(handleUploadV2, sendReadOnlyV2), operations: []openapi.Operation{{
+			Method:      http.MethodPost,
+			Summary:     "Uploads barcodes and their names",
+			Tag:         "v2",
+			RequestBody: &openapi.Body{ContentType: contentTypeJson, Schema: RequestUploadV2{}},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Body: &openapi.Body{ContentType: contentTypeJson, Schema: ResponseUploadV2{}}},
+				responseErrorInvalid,
+				responseErrorReadOnly,
+				responseErrorMethod,
+				responseErrorRateLimited,
+			},
+		}}},
+		{path: "/v2/amount", handler: handleAmountV2, operations: []openapi.Operation{{
+			Method:    http.MethodGet,
+			Summary:   "Returns the amount of stored barcodes",
+			Tag:       "v2",
+			Responses: []openapi.Response{{Status: http.StatusOK, Body: &openapi.Body{ContentType: contentTypeJson, Schema: ResponseAmountV2{}}}},
+		}}},
+		{path: "/v2/popular", handler: handlePopularV2, operations: []openapi.Operation{popularityOperationV2(
+			"Returns the barcodes that have been looked up most within the last days",
+			"Barcodes are ordered by their lookups. PreviousHits are the lookups within the same amount of days before")}},
+		{path: "/v2/trending", handler: handleTrendingV2, operations: []openapi.Operation{popularityOperationV2(
+			"Returns the barcodes with the biggest rise of lookups within the last days",
+			"Barcodes are ordered by the difference of Hits and PreviousHits, the lookups within the same amount of days before. Only barcodes with a rise are returned")}},
+		{path: OpenApiPath, handler: handleOpenApi, operations: []openapi.Operation{{
+			Method:    http.MethodGet,
+			Summary:   "Returns this description of the API",
+			Responses: []openapi.Response{{Status: http.StatusOK, Body: &openapi.Body{ContentType: contentTypeJson, Schema: openapi.Schema{Type: "object"}}}},
+		}}},
+	}
+}
+
+func popularityOperationV2(summary, description string) openapi.Operation {
+	return openapi.Operation{
+		Method:      http.MethodGet,
+		Summary:     summary,
+		Description: description,
+		Tag:         "v2",
+		Parameters: []openapi.Parameter{
+			{Name: "uuid", In: openapi.InQuery, Description: headerUuid.Description, Required: true},
+			{Name: "days", In: openapi.InQuery, Description: "Time window in days: 1, 7 (default) or 30", Schema: 0},
+			{Name: "limit", In: openapi.InQuery, Description: "Maximum amount of barcodes, between 1 and 100 (default 50)", Schema: 0},
+		},
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Body: &openapi.Body{ContentType: contentTypeJson, Schema: ResponsePopularV2{}}},
+			responseErrorInvalid,
+			responseErrorRateLimited,
+		},
+	}
+}
+
+func nameOperationV2(summary string) openapi.Operation {
+	return openapi.Operation{
+		Method:      http.MethodPost,
+		Summary:     summary,
+		Tag:         "v2",
+		RequestBody: &openapi.Body{ContentType: contentTypeJson, Schema: RequestNameV2{}},
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Body: &openapi.Body{ContentType: contentTypeJson, Schema: ResponseNameV2{}}},
+			responseErrorInvalid,
+			responseErrorReadOnly,
+			responseErrorMethod,
+			responseErrorRateLimited,
+		},
+	}
+}
+
+// openApiDocument is generated from the route table when the webserver starts
+var openApiDocument []byte
+
+// newOpenApiDocument describes all routes of the webserver
+func newOpenApiDocument(routes []route) []byte {
+	document := openapi.New(openapi.Info{
+		Title:       "Barcode Buddy Federation",
+		Description: "Shares the names of barcodes between Barcode Buddy installations",
+		Version:     "2",
+	})
+	document.AddSecurityScheme(securityFederationKey, openapi.SecurityScheme{
+		Type:        "apiKey",
+		In:          openapi.InHeader,
+		Name:        federation.HeaderKey,
+		Description: "The FederationKey of the server",
+	})
+	document.AddSecurityScheme(securityAdminSession, openapi.SecurityScheme{
+		Type:        "apiKey",
+		In:          "cookie",
+		Name:        "session_token",
+		Description: "Session cookie that is set by /login",
+	})
+	for _, r := range routes {
+		if len(r.operations) > 0 {
+			document.AddPath(r.path, r.operations)
+		}
+	}
+	result, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+func handleOpenApi(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("cache-control", "public, max-age=3600")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", contentTypeJson)
+	_, _ = w.Write(openApiDocument)
+}
@@ -0,0 +1,348 @@
+package webserver
+
+import (
+	"BarcodeServer/internal/configuration"
+	"BarcodeServer/internal/helper"
+	"BarcodeServer/internal/metrics"
+	"BarcodeServer/internal/storage"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// Error codes of the v2 API
+const (
+	errorCodeInvalidRequest   = "invalid_request"
+	errorCodeInvalidUuid      = "invalid_uuid"
+	errorCodeInvalidBarcode   = "invalid_barcode"
+	errorCodeInvalidName      = "invalid_name"
+	errorCodeNotFound         = "not_found"
+	errorCodeRateLimited      = "rate_limited"
+	errorCodeMethodNotAllowed = "method_not_allowed"
+	errorCodeReadOnly         = "read_only"
+)
+
+// maxUploadBodySize is the maximum size of the body of an upload to the v2 API
+const maxUploadBodySize = 1024 * 1024
+
+// ErrorV2 is returned by the v2 API with a status code other than 200
+type ErrorV2 struct {
+	// Code is a stable identifier of the error, e.g. "rate_limited"
+	Code    string `json:"Code"`
+	Message string `json:"Message"`
+}
+
+type ResponseErrorV2 struct {
+	Error ErrorV2 `json:"Error"`
+}
+
+type RequestLookupV2 struct {
+	Uuid     string   `json:"Uuid"`
+	Barcodes []string `json:"Barcodes"`
+}
+
+type ResponseLookupV2 struct {
+	Barcode  string            `json:"Barcode"`
+	Names    []string          `json:"Names"`
+	Metadata *storage.Metadata `json:"Metadata,omitempty"`
+}
+
+type ResponseBatchV2 struct {
+	Results []BatchResultV2 `json:"Results"`
+}
+
+// BatchResultV2 contains the names of a barcode of a batch request. Error is set
+// if the barcode is invalid or has not been found
+type BatchResultV2 struct {
+	Barcode  string            `json:"Barcode"`
+	Names    []string          `json:"Names"`
+	Metadata *storage.Metadata `json:"Metadata,omitempty"`
+	Error    *ErrorV2          `json:"Error,omitempty"`
+}
+
+type RequestNameV2 struct {
+	Uuid    string `json:"Uuid"`
+	Barcode string `json:"Barcode"`
+	Name    string `json:"Name"`
+}
+
+type RequestMetadataVoteV2 struct {
+	Uuid    string `json:"Uuid"`
+	Barcode string `json:"Barcode"`
+	// Field is one of "brand", "product", "quantity", "category" or "language"
+	Field string `json:"Field"`
+	Value string `json:"Value"`
+}
+
+type ResponseNameV2 struct {
+	// Counted is false if the user has already voted for or reported the name
+	Counted bool `json:"Counted"`
+}
+
+type RequestUploadV2 struct {
+	Uuid     string            `json:"Uuid"`
+	Barcodes []storage.Barcode `json:"Barcodes"`
+}
+
+type ResponseUploadV2 struct {
+	Accepted int `json:"Accepted"`
+	Rejected int `json:"Rejected"`
+}
+
+type ResponseAmountV2 struct {
+	TotalBarcodes int `json:"TotalBarcodes"`
+}
+
+type ResponsePopularV2 struct {
+	// Days is the time window of the lookups
+	Days     int                      `json:"Days"`
+	Barcodes []storage.PopularBarcode `json:"Barcodes"`
+}
+
+const (
+	// defaultPopularDays is the time window of popular and trending barcodes if none is requested
+	defaultPopularDays = 7
+	// maxPopularLimit is the maximum amount of popular or trending barcodes per request
+	maxPopularLimit = 100
+)
+
+func sendJsonV2(w http.ResponseWriter, status int, value interface{}) {
+	response, _ := json.Marshal(value)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write(response)
+}
+
+func sendErrorV2(w http.ResponseWriter, status int, code, message string) {
+	if status == http.StatusBadRequest {
+		metrics.Add(metrics.CounterBadRequests, 1)
+	}
+	sendJsonV2(w, status, ResponseErrorV2{Error: ErrorV2{Code: code, Message: message}})
+}
+
+func sendReadOnlyV2(w http.ResponseWriter, r *http.Request) {
+	sendErrorV2(w, http.StatusForbidden, errorCodeReadOnly, "This server is a read-only mirror")
+}
+
+// lookupErrorV2 returns the status code and error of a failed lookup
+func lookupErrorV2(err error) (int, ErrorV2) {
+	if errors.Is(err, errInvalidBarcode) {
+		return http.StatusBadRequest, ErrorV2{Code: errorCodeInvalidBarcode, Message: "Invalid barcode"}
+	}
+	return http.StatusNotFound, ErrorV2{Code: errorCodeNotFound, Message: "Barcode not found"}
+}
+
+// isAllowedV2 validates the uuid and registers amount requests of the user.
+// Sends an error and returns false if the request must not be processed
+func isAllowedV2(w http.ResponseWriter, r *http.Request, uuid string, amount int, isUpload bool) bool {
+	if !isValidUuid(uuid) {
+		sendErrorV2(w, http.StatusBadRequest, errorCodeInvalidUuid, "The uuid must be 32 characters long")
+		return false
+	}
+	limit := configuration.Get().ApiDailyCalls
+	var requests int
+	if isUpload {
+		limit = configuration.Get().ApiDailyCallsUpload
+		requests = store.LogNewRequest(helper.GetIpAddress(r), uuid, true)
+	} else {
+		requests = store.LogNewRequests(helper.GetIpAddress(r), uuid, amount)
+	}
+	if requests > limit {
+		// The request counters are reset at midnight
+		w.Header().Set("Retry-After", helper.GetSecondsToMidnight())
+		metrics.Add(metrics.CounterRateLimited, 1)
+		sendErrorV2(w, http.StatusTooManyRequests, errorCodeRateLimited, "Daily limit of "+strconv.Itoa(limit)+" requests reached")
+		return false
+	}
+	return true
+}
+
+// decodeBodyV2 reads the JSON body of a POST request. Sends an error and returns
+// false if the request is not valid
+func decodeBodyV2(w http.ResponseWriter, r *http.Request, maxSize int64, value interface{}) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		sendErrorV2(w, http.StatusMethodNotAllowed, errorCodeMethodNotAllowed, "Only POST is allowed")
+		return false
+	}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSize)).Decode(value)
+	if err != nil {
+		sendErrorV2(w, http.StatusBadRequest, errorCodeInvalidRequest, "Invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// handleLookupV2 returns the names of a single barcode with GET ?barcode=&uuid=,
+// or of up to maxBatchSize barcodes with a POST body
+func handleLookupV2(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("cache-control", "private")
+	if r.Method == http.MethodGet {
+		uuid := r.URL.Query().Get("uuid")
+		if !isAllowedV2(w, r, uuid, 1, false) {
+			return
+		}
+		result := lookupBarcodes([]string{r.URL.Query().Get("barcode")}, uuid, preferredLanguages(r))[0]
+		if result.err != nil {
+			status, apiError := lookupErrorV2(result.err)
+			sendErrorV2(w, status, apiError.Code, apiError.Message)
+			return
+		}
+		sendJsonV2(w, http.StatusOK, ResponseLookupV2{Barcode: result.barcode, Names: result.names, Metadata: result.metadata})
+		return
+	}
+	var request RequestLookupV2
+	if !decodeBodyV2(w, r, maxBatchBodySize, &request) {
+		return
+	}
+	if len(request.Barcodes) == 0 || len(request.Barcodes) > maxBatchSize {
+		sendErrorV2(w, http.StatusBadRequest, errorCodeInvalidRequest, "Between 1 and "+strconv.Itoa(maxBatchSize)+" barcodes are required")
+		return
+	}
+	if !isAllowedV2(w, r, request.Uuid, len(request.Barcodes), false) {
+		return
+	}
+	response := ResponseBatchV2{Results: make([]BatchResultV2, len(request.Barcodes))}
+	for i, result := range lookupBarcodes(request.Barcodes, request.Uuid, preferredLanguages(r)) {
+		response.Results[i] = BatchResultV2{Barcode: result.barcode, Names: result.names, Metadata: result.metadata}
+		if result.err != nil {
+			response.Results[i].Barcode = request.Barcodes[i]
+			_, apiError := lookupErrorV2(result.err)
+			response.Results[i].Error = &apiError
+		}
+	}
+	sendJsonV2(w, http.StatusOK, response)
+}
+
+func handleVoteV2(w http.ResponseWriter, r *http.Request) {
+	handleNameV2(w, r, store.VoteName)
+}
+
+func handleReportV2(w http.ResponseWriter, r *http.Request) {
+	handleNameV2(w, r, store.ReportName)
+}
+
+// handleNameV2 validates a vote or a report and passes it to storeFunc
+func handleNameV2(w http.ResponseWriter, r *http.Request, storeFunc func(barcode, name, ipAddr string) bool) {
+	w.Header().Set("cache-control", "private")
+	var request RequestNameV2
+	if !decodeBodyV2(w, r, maxBatchBodySize, &request) {
+		return
+	}
+	if !isAllowedV2(w, r, request.Uuid, 1, false) {
+		return
+	}
+	_, barcode, err := parseBarcode(request.Barcode, request.Uuid)
+	if err != nil {
+		status, apiError := lookupErrorV2(err)
+		sendErrorV2(w, status, apiError.Code, apiError.Message)
+		return
+	}
+	if len(request.Name) < 2 {
+		sendErrorV2(w, http.StatusBadRequest, errorCodeInvalidName, "Invalid name")
+		return
+	}
+	counted := storeFunc(barcode, request.Name, helper.GetIpAddress(r))
+	sendJsonV2(w, http.StatusOK, ResponseNameV2{Counted: counted})
+}
+
+// handleMetadataVoteV2 votes for a metadata value of a barcode. Values that do
+// not exist yet are added with a score of 1
+func handleMetadataVoteV2(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("cache-control", "private")
+	var request RequestMetadataVoteV2
+	if !decodeBodyV2(w, r, maxBatchBodySize, &request) {
+		return
+	}
+	if !isAllowedV2(w, r, request.Uuid, 1, false) {
+		return
+	}
+	_, barcode, err := parseBarcode(request.Barcode, request.Uuid)
+	if err != nil {
+		status, apiError := lookupErrorV2(err)
+		sendErrorV2(w, status, apiError.Code, apiError.Message)
+		return
+	}
+	value, ok := storage.SanitizeMetadataValue(request.Field, request.Value)
+	if !ok {
+		sendErrorV2(w, http.StatusBadRequest, errorCodeInvalidRequest, "Invalid field or value")
+		return
+	}
+	// Metadata is only stored for products that have a name
+	if len(store.GetBarcode(barcode, false)) == 0 {
+		status, apiError := lookupErrorV2(errBarcodeNotFound)
+		sendErrorV2(w, status, apiError.Code, apiError.Message)
+		return
+	}
+	counted := store.VoteMetadata(barcode, request.Field, value, helper.GetIpAddress(r))
+	sendJsonV2(w, http.StatusOK, ResponseNameV2{Counted: counted})
+}
+
+func handleUploadV2(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("cache-control", "private")
+	var request RequestUploadV2
+	if !decodeBodyV2(w, r, maxUploadBodySize, &request) {
+		return
+	}
+	if len(request.Barcodes) == 0 {
+		sendErrorV2(w, http.StatusBadRequest, errorCodeInvalidRequest, "No barcodes have been sent")
+		return
+	}
+	if !isAllowedV2(w, r, request.Uuid, 1, true) {
+		return
+	}
+	request.Barcodes = scopeUploads(request.Barcodes, request.Uuid)
+	response := ResponseUploadV2{}
+	for _, barcode := range request.Barcodes {
+		_, ok := storage.SanitizeBarcode(barcode)
+		if ok {
+			response.Accepted++
+		} else {
+			response.Rejected++
+		}
+	}
+	store.AddGrocyBarcodes(storage.GrocyBarcodes{Barcodes: request.Barcodes}, request.Uuid, storage.SourceUser)
+	metrics.Add(metrics.CounterUploads, len(request.Barcodes))
+	sendJsonV2(w, http.StatusOK, response)
+}
+
+func handleAmountV2(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("cache-control", "public, max-age=1800")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	sendJsonV2(w, http.StatusOK, ResponseAmountV2{TotalBarcodes: store.GetTotalBarcodes()})
+}
+
+func handlePopularV2(w http.ResponseWriter, r *http.Request) {
+	handlePopularityV2(w, r, storage.MostPopularBarcodes)
+}
+
+func handleTrendingV2(w http.ResponseWriter, r *http.Request) {
+	handlePopularityV2(w, r, storage.TrendingBarcodes)
+}
+
+// handlePopularityV2 validates the time window and the limit of the request and
+// returns the barcodes listed by listFunc
+func handlePopularityV2(w http.ResponseWriter, r *http.Request, listFunc func(store storage.Store, days, limit int) []storage.PopularBarcode) {
+	w.Header().Set("cache-control", "private")
+	days, limit := defaultPopularDays, storage.AmountTopBarcodes
+	var err error
+	if r.URL.Query().Get("days") != "" {
+		days, err = strconv.Atoi(r.URL.Query().Get("days"))
+	}
+	if err == nil && r.URL.Query().Get("limit") != "" {
+		limit, err = strconv.Atoi(r.URL.Query().Get("limit"))
+	}
+	if err != nil || !storage.IsPopularityWindow(days) || limit < 1 || limit > maxPopularLimit {
+		sendErrorV2(w, http.StatusBadRequest, errorCodeInvalidRequest, "days must be 1, 7 or 30 and limit between 1 and "+strconv.Itoa(maxPopularLimit))
+		return
+	}
+	if !isAllowedV2(w, r, r.URL.Query().Get("uuid"), 1, false) {
+		return
+	}
+	sendJsonV2(w, http.StatusOK, ResponsePopularV2{Days: days, Barcodes: listFunc(store, days, limit)})
+}
+
+func handleUnknownV2(w http.ResponseWriter, r *http.Request) {
+	sendErrorV2(w, http.StatusNotFound, errorCodeNotFound, "Unknown endpoint")
+}
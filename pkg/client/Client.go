@@ -0,0 +1,515 @@
+// Package client is a client for the API of a Barcode Buddy federation server
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxBatchSize is the maximum amount of barcodes the server accepts per lookup.
+// LookupBatch splits larger requests
+const MaxBatchSize = 100
+
+const (
+	defaultTimeout    = 30 * time.Second
+	defaultRetries    = 3
+	defaultBackoff    = 500 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
+	// maxResponseSize is the maximum size of a response that is decoded
+	maxResponseSize = 32 * 1024 * 1024
+)
+
+// Client sends requests to a federation server. It is safe for concurrent use
+type Client struct {
+	server        string
+	uuid          string
+	federationKey string
+	userAgent     string
+	languages     []string
+	httpClient    *http.Client
+	retries       int
+	backoff       time.Duration
+	maxBackoff    time.Duration
+}
+
+// Option changes the default settings of a Client
+type Option func(*Client)
+
+// WithHttpClient sets the http.Client that sends all requests
+func WithHttpClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetries sets how often a request is repeated if the server is not reachable,
+// returns a server error or asks the client to wait. The time between attempts
+// starts with backoff and doubles until maxBackoff is reached. Requests are not
+// repeated if the server asks the client to wait longer than maxBackoff
+func WithRetries(retries int, backoff, maxBackoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+		c.maxBackoff = maxBackoff
+	}
+}
+
+// WithFederationKey sets the FederationKey of the server. Requests for changes
+// are then not counted towards the daily limit
+func WithFederationKey(key string) Option {
+	return func(c *Client) {
+		c.federationKey = key
+	}
+}
+
+// WithUserAgent sets the User-Agent header of all requests
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// WithLanguages sets the preferred languages of the names returned by lookups,
+// e.g. "de" and "en". Names in other languages are returned after them
+func WithLanguages(languages ...string) Option {
+	return func(c *Client) {
+		c.languages = languages
+	}
+}
+
+// New returns a client for the server at the given URL, e.g.
+// https://federation.example.com. The uuid identifies the installation and must
+// be 32 characters long, see NewUuid
+func New(server, uuid string, options ...Option) (*Client, error) {
+	if len(uuid) != 32 {
+		return nil, ErrInvalidUuid
+	}
+	_, err := url.ParseRequestURI(server)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		server:     strings.TrimSuffix(server, "/"),
+		uuid:       uuid,
+		userAgent:  "Barcode Buddy Federation Client",
+		httpClient: &http.Client{Timeout: defaultTimeout},
+		retries:    defaultRetries,
+		backoff:    defaultBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c, nil
+}
+
+// NewUuid returns a random uuid that can be passed to New. It should be stored
+// and reused, as the server counts requests per uuid
+func NewUuid() string {
+	result := make([]byte, 16)
+	_, err := rand.Read(result)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(result)
+}
+
+// Barcode is a barcode and the name of its product. The metadata is optional
+type Barcode struct {
+	Barcode string `json:"Barcode"`
+	Name    string `json:"Name"`
+	Metadata
+}
+
+// Metadata contains structured data of a product. Fields that are not known are empty
+type Metadata struct {
+	Brand       string  `json:"Brand,omitempty"`
+	ProductName string  `json:"ProductName,omitempty"`
+	Quantity    float64 `json:"Quantity,omitempty"`
+	// Unit of the quantity, e.g. "g" or "ml"
+	Unit     string `json:"Unit,omitempty"`
+	Category string `json:"Category,omitempty"`
+	// Language is the ISO 639-1 code of the language of the product texts, e.g. "de"
+	Language string `json:"Language,omitempty"`
+}
+
+// Metadata fields that can be voted with VoteMetadata
+const (
+	FieldBrand       = "brand"
+	FieldProductName = "product"
+	// FieldQuantity values contain the quantity and the unit, e.g. "500 g"
+	FieldQuantity = "quantity"
+	FieldCategory = "category"
+	FieldLanguage = "language"
+)
+
+// LookupResult contains the names of a barcode, ordered by their score
+type LookupResult struct {
+	// Barcode is the canonical form of the barcode, or the requested barcode if Err is set
+	Barcode string
+	Names   []string
+	// Metadata is nil if nothing but the names is known about the product
+	Metadata *Metadata
+	// Err is set by LookupBatch if the barcode is invalid or has not been found
+	Err error
+}
+
+// UploadResult contains the amount of uploaded barcodes that have been accepted
+type UploadResult struct {
+	Accepted int `json:"Accepted"`
+	Rejected int `json:"Rejected"`
+}
+
+// PopularBarcode is a barcode with its lookups within a time window
+type PopularBarcode struct {
+	Barcode string   `json:"Barcode"`
+	Names   []string `json:"Names"`
+	Hits    int      `json:"Hits"`
+	// PreviousHits are the lookups within the same amount of days before the time window
+	PreviousHits int `json:"PreviousHits"`
+}
+
+// Change is a change of the score of a name on the server
+type Change struct {
+	Cursor  int64  `json:"Cursor"`
+	Time    int64  `json:"Time"`
+	Type    string `json:"Type"`
+	Barcode string `json:"Barcode"`
+	// Field is set if a metadata value has changed, the value is stored in Name
+	Field string  `json:"Field,omitempty"`
+	Name  string  `json:"Name,omitempty"`
+	Score float64 `json:"Score"`
+	// Language is the ISO 639-1 code of an added or imported name, if it is known
+	Language string `json:"Language,omitempty"`
+}
+
+// Changes is a page of the change log of the server
+type Changes struct {
+	Changes []Change `json:"Changes"`
+	// NextCursor is the cursor to request the next page with
+	NextCursor int64 `json:"NextCursor"`
+	// HasMore is true if changes after NextCursor are available
+	HasMore      bool  `json:"HasMore"`
+	LatestCursor int64 `json:"LatestCursor"`
+}
+
+// Ping returns an error if the server is not reachable
+func (c *Client) Ping(ctx context.Context) error {
+	var response bytes.Buffer
+	err := c.do(ctx, http.MethodGet, "/ping", nil, nil, &response)
+	if err != nil {
+		return err
+	}
+	if response.String() != "pong" {
+		return errors.New("unexpected response to ping: " + response.String())
+	}
+	return nil
+}
+
+// Amount returns the amount of barcodes stored on the server
+func (c *Client) Amount(ctx context.Context) (int, error) {
+	var response struct {
+		TotalBarcodes int `json:"TotalBarcodes"`
+	}
+	err := c.do(ctx, http.MethodGet, "/v2/amount", nil, nil, &response)
+	return response.TotalBarcodes, err
+}
+
+// Lookup returns the names of a barcode. Returns ErrNotFound if the barcode is
+// unknown or a restricted circulation code, and ErrBadRequest if it is invalid
+func (c *Client) Lookup(ctx context.Context, barcode string) (LookupResult, error) {
+	query := url.Values{"barcode": {barcode}, "uuid": {c.uuid}}
+	if len(c.languages) > 0 {
+		query.Set("lang", strings.Join(c.languages, ","))
+	}
+	var response struct {
+		Barcode  string    `json:"Barcode"`
+		Names    []string  `json:"Names"`
+		Metadata *Metadata `json:"Metadata"`
+	}
+	err := c.do(ctx, http.MethodGet, "/v2/lookup?"+query.Encode(), nil, nil, &response)
+	if err != nil {
+		return LookupResult{Barcode: barcode}, err
+	}
+	return LookupResult{Barcode: response.Barcode, Names: response.Names, Metadata: response.Metadata}, nil
+}
+
+// LookupBatch returns the names of multiple barcodes in the requested order. If
+// a single barcode is invalid or has not been found, Err of its result is set.
+// Every barcode counts as a request towards the daily limit
+func (c *Client) LookupBatch(ctx context.Context, barcodes []string) ([]LookupResult, error) {
+	results := make([]LookupResult, 0, len(barcodes))
+	for start := 0; start < len(barcodes); start += MaxBatchSize {
+		end := start + MaxBatchSize
+		if end > len(barcodes) {
+			end = len(barcodes)
+		}
+		request := struct {
+			Uuid     string   `json:"Uuid"`
+			Barcodes []string `json:"Barcodes"`
+		}{c.uuid, barcodes[start:end]}
+		var response struct {
+			Results []struct {
+				Barcode  string    `json:"Barcode"`
+				Names    []string  `json:"Names"`
+				Metadata *Metadata `json:"Metadata"`
+				Error    *struct {
+					Code    string `json:"Code"`
+					Message string `json:"Message"`
+				} `json:"Error"`
+			} `json:"Results"`
+		}
+		err := c.do(ctx, http.MethodPost, c.lookupPath(), nil, request, &response)
+		if err != nil {
+			return results, err
+		}
+		for _, result := range response.Results {
+			lookup := LookupResult{Barcode: result.Barcode, Names: result.Names, Metadata: result.Metadata}
+			if result.Error != nil {
+				lookup.Err = &ApiError{StatusCode: http.StatusOK, Code: result.Error.Code, Message: result.Error.Message}
+			}
+			results = append(results, lookup)
+		}
+	}
+	return results, nil
+}
+
+// lookupPath returns the path of batch lookups, including the preferred languages
+func (c *Client) lookupPath() string {
+	if len(c.languages) == 0 {
+		return "/v2/lookup"
+	}
+	return "/v2/lookup?" + url.Values{"lang": {strings.Join(c.languages, ",")}}.Encode()
+}
+
+// Vote votes for the name of a barcode. Returns false if the vote has not been
+// counted, because a vote has already been sent from the same IP address
+func (c *Client) Vote(ctx context.Context, barcode, name string) (bool, error) {
+	return c.sendName(ctx, "/v2/vote", barcode, name)
+}
+
+// Report reports the name of a barcode as wrong. Returns false if the report has
+// not been counted, because a report has already been sent from the same IP address
+func (c *Client) Report(ctx context.Context, barcode, name string) (bool, error) {
+	return c.sendName(ctx, "/v2/report", barcode, name)
+}
+
+// VoteMetadata votes for a metadata value of a barcode, e.g. FieldBrand. Values
+// that do not exist yet are added. Returns ErrNotFound if the barcode has no name
+// and false if a vote has already been sent from the same IP address
+func (c *Client) VoteMetadata(ctx context.Context, barcode, field, value string) (bool, error) {
+	request := struct {
+		Uuid    string `json:"Uuid"`
+		Barcode string `json:"Barcode"`
+		Field   string `json:"Field"`
+		Value   string `json:"Value"`
+	}{c.uuid, barcode, field, value}
+	var response struct {
+		Counted bool `json:"Counted"`
+	}
+	err := c.do(ctx, http.MethodPost, "/v2/metadata/vote", nil, request, &response)
+	return response.Counted, err
+}
+
+func (c *Client) sendName(ctx context.Context, path, barcode, name string) (bool, error) {
+	request := struct {
+		Uuid    string `json:"Uuid"`
+		Barcode string `json:"Barcode"`
+		Name    string `json:"Name"`
+	}{c.uuid, barcode, name}
+	var response struct {
+		Counted bool `json:"Counted"`
+	}
+	err := c.do(ctx, http.MethodPost, path, nil, request, &response)
+	return response.Counted, err
+}
+
+// Add uploads barcodes and their names. Invalid and restricted barcodes are
+// rejected by the server without failing the whole upload
+func (c *Client) Add(ctx context.Context, barcodes []Barcode) (UploadResult, error) {
+	request := struct {
+		Uuid     string    `json:"Uuid"`
+		Barcodes []Barcode `json:"Barcodes"`
+	}{c.uuid, barcodes}
+	var response UploadResult
+	err := c.do(ctx, http.MethodPost, "/v2/barcodes", nil, request, &response)
+	return response, err
+}
+
+// Popular returns up to limit barcodes that have been looked up most within the
+// last days days. The server supports 1, 7 and 30 days and at most 100 barcodes,
+// its defaults are used if days or limit are zero
+func (c *Client) Popular(ctx context.Context, days, limit int) ([]PopularBarcode, error) {
+	return c.popularity(ctx, "/v2/popular", days, limit)
+}
+
+// Trending returns up to limit barcodes with the biggest rise of lookups within
+// the last days days, compared to the same amount of days before
+func (c *Client) Trending(ctx context.Context, days, limit int) ([]PopularBarcode, error) {
+	return c.popularity(ctx, "/v2/trending", days, limit)
+}
+
+func (c *Client) popularity(ctx context.Context, path string, days, limit int) ([]PopularBarcode, error) {
+	query := url.Values{"uuid": {c.uuid}}
+	if days > 0 {
+		query.Set("days", strconv.Itoa(days))
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var response struct {
+		Barcodes []PopularBarcode `json:"Barcodes"`
+	}
+	err := c.do(ctx, http.MethodGet, path+"?"+query.Encode(), nil, nil, &response)
+	return response.Barcodes, err
+}
+
+// GetChanges returns up to limit changes after the cursor since, the server
+// default is used if limit is zero. Returns ErrCursorExpired if the changes are
+// not stored on the server anymore
+func (c *Client) GetChanges(ctx context.Context, since int64, limit int) (Changes, error) {
+	query := url.Values{"since": {strconv.FormatInt(since, 10)}}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	header := http.Header{}
+	if c.federationKey != "" {
+		header.Set("federation-key", c.federationKey)
+	} else {
+		header.Set("uuid", c.uuid)
+	}
+	var response Changes
+	err := c.do(ctx, http.MethodGet, "/changes?"+query.Encode(), header, nil, &response)
+	return response, err
+}
+
+// do sends a request and decodes the response into result, which is either a
+// *bytes.Buffer or a pointer to a value the JSON response is decoded into.
+// Temporary errors are retried
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, body, result interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = c.send(ctx, method, path, header, payload, result)
+		if err == nil || attempt >= c.retries {
+			return err
+		}
+		wait, ok := c.retryDelay(err, attempt)
+		if !ok {
+			return err
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryDelay returns the time to wait before the next attempt, or false if the
+// request must not be repeated
+func (c *Client) retryDelay(err error, attempt int) (time.Duration, bool) {
+	var apiError *ApiError
+	if errors.As(err, &apiError) {
+		if !apiError.isTemporary() {
+			return 0, false
+		}
+		if apiError.RetryAfter > 0 {
+			// The daily limit is reset at midnight, waiting that long is left to the caller
+			return apiError.RetryAfter, apiError.RetryAfter <= c.maxBackoff
+		}
+		if errors.Is(err, ErrRateLimited) {
+			return 0, false
+		}
+	} else if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return 0, false
+	}
+	wait := time.Duration(float64(c.backoff) * math.Pow(2, float64(attempt)))
+	if wait > c.maxBackoff || wait <= 0 {
+		wait = c.maxBackoff
+	}
+	// Jitter prevents many clients from retrying at the same time
+	return wait/2 + time.Duration(mathrand.Int63n(int64(wait/2)+1)), true
+}
+
+func (c *Client) send(ctx context.Context, method, path string, header http.Header, payload []byte, result interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, c.server+path, body)
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	request.Header.Set("User-Agent", c.userAgent)
+	if payload != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	reader := io.LimitReader(response.Body, maxResponseSize)
+	if response.StatusCode != http.StatusOK {
+		return parseError(response, reader)
+	}
+	buffer, ok := result.(*bytes.Buffer)
+	if ok {
+		_, err = buffer.ReadFrom(reader)
+		return err
+	}
+	return json.NewDecoder(reader).Decode(result)
+}
+
+// parseError returns the ApiError of a response. The v2 API and the legacy
+// endpoints return errors in different formats
+func parseError(response *http.Response, body io.Reader) error {
+	apiError := &ApiError{
+		StatusCode: response.StatusCode,
+		RetryAfter: parseRetryAfter(response.Header.Get("Retry-After")),
+	}
+	content, _ := io.ReadAll(body)
+	var errorV2 struct {
+		Error struct {
+			Code    string `json:"Code"`
+			Message string `json:"Message"`
+		} `json:"Error"`
+	}
+	var errorLegacy struct {
+		ErrorMessage string `json:"ErrorMessage"`
+	}
+	if json.Unmarshal(content, &errorV2) == nil && errorV2.Error.Code != "" {
+		apiError.Code = errorV2.Error.Code
+		apiError.Message = errorV2.Error.Message
+	} else if json.Unmarshal(content, &errorLegacy) == nil && errorLegacy.ErrorMessage != "" {
+		apiError.Message = errorLegacy.ErrorMessage
+	} else {
+		apiError.Message = strings.TrimSpace(string(content))
+	}
+	return apiError
+}
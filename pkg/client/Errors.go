@@ -0,0 +1,94 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned if a barcode has not been found
+	ErrNotFound = errors.New("barcode not found")
+	// ErrRateLimited is returned if the daily limit of requests has been reached
+	ErrRateLimited = errors.New("too many requests")
+	// ErrBadRequest is returned if the server rejected the request, e.g. because of an invalid barcode or name
+	ErrBadRequest = errors.New("bad request")
+	// ErrReadOnly is returned by write requests to a read-only mirror
+	ErrReadOnly = errors.New("server is a read-only mirror")
+	// ErrCursorExpired is returned if the requested changes are not stored anymore.
+	// A full export has to be downloaded instead
+	ErrCursorExpired = errors.New("cursor expired")
+	// ErrInvalidUuid is returned if the uuid is not 32 characters long
+	ErrInvalidUuid = errors.New("the uuid must be 32 characters long")
+)
+
+// ApiError is returned if the server answered with an error. It wraps one of the
+// sentinel errors above, so it can be checked with errors.Is
+type ApiError struct {
+	// StatusCode is 200 for errors of single barcodes of LookupBatch
+	StatusCode int
+	// Code is the error code of the v2 API, e.g. "rate_limited", empty for other endpoints
+	Code    string
+	Message string
+	// RetryAfter is the time after which the request may succeed, zero if unknown
+	RetryAfter time.Duration
+}
+
+func (e *ApiError) Error() string {
+	if e.StatusCode == http.StatusOK {
+		// Error of a single barcode of a successful batch request
+		return e.Message
+	}
+	if e.Message == "" {
+		return "server returned status " + strconv.Itoa(e.StatusCode)
+	}
+	return "server returned status " + strconv.Itoa(e.StatusCode) + ": " + e.Message
+}
+
+func (e *ApiError) Unwrap() error {
+	switch e.Code {
+	case "not_found":
+		return ErrNotFound
+	case "rate_limited":
+		return ErrRateLimited
+	case "read_only":
+		return ErrReadOnly
+	case "invalid_request", "invalid_uuid", "invalid_barcode", "invalid_name", "method_not_allowed":
+		return ErrBadRequest
+	}
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusTooManyRequests:
+		// The legacy endpoints answer invalid requests with 429 as well
+		if e.Message == legacyTooManyRequests {
+			return ErrRateLimited
+		}
+		return ErrBadRequest
+	case http.StatusForbidden:
+		return ErrReadOnly
+	case http.StatusGone:
+		return ErrCursorExpired
+	case http.StatusBadRequest, http.StatusMethodNotAllowed:
+		return ErrBadRequest
+	}
+	return nil
+}
+
+// isTemporary returns true if the request can be repeated
+func (e *ApiError) isTemporary() bool {
+	return e.StatusCode >= http.StatusInternalServerError || errors.Is(e, ErrRateLimited)
+}
+
+// legacyTooManyRequests is the error message of the legacy endpoints if the daily limit has been reached
+const legacyTooManyRequests = "Too many requests"
+
+// parseRetryAfter returns the duration of a Retry-After header in seconds, zero if it is not set
+func parseRetryAfter(header string) time.Duration {
+	seconds, err := strconv.Atoi(header)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}